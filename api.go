@@ -1,31 +1,61 @@
 package odtimagereplacer
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"sort"
 	"time"
 )
 
-// ImageSource represents an image from either URL or base64
+// ImageSource represents an image from a URL, base64 data or a source
+// resolved by a registered SourceResolver
 type ImageSource struct {
 	URL    string `json:"url"`
 	Base64 string `json:"base64"`
+	// Source is a "scheme:reference" resolved by RegisterResolver, e.g.
+	// "asset:logo" or "file:signatures/ceo.png"
+	Source string `json:"source,omitempty"`
+	// Asset is short for Source "asset:<Asset>"
+	Asset string `json:"asset,omitempty"`
+	// Headers are sent with the URL request
+	Headers map[string]string `json:"headers,omitempty"`
+	// Credential names a configured credential to authenticate the URL request
+	Credential string `json:"credential,omitempty"`
 }
 
 // TemplateSource represents the ODT template source
 type TemplateSource struct {
 	URL    string `json:"url"`
 	Base64 string `json:"base64"`
+	// ID selects a template uploaded to DefaultTemplates; Version 0 is
+	// the latest
+	ID      string `json:"id,omitempty"`
+	Version int    `json:"version,omitempty"`
+	// Headers are sent with the URL request
+	Headers map[string]string `json:"headers,omitempty"`
+	// Credential names a configured credential to authenticate the URL request
+	Credential string `json:"credential,omitempty"`
+	// Password opens templates saved with a password; the output is
+	// encrypted with the same password
+	Password string `json:"password,omitempty"`
 }
 
 // ReplaceRequest represents the JSON request structure for replacing images
 type ReplaceRequest struct {
 	Template TemplateSource         `json:"template"`
 	Data     map[string]ImageSource `json:"data"`
+	Meta     *DocumentMeta          `json:"meta,omitempty"`
+	// Thumbnail is "keep" (default), "regenerate" or "remove"
+	Thumbnail ThumbnailMode `json:"thumbnail,omitempty"`
+	// Signatures is "warn" (default), "refuse" or "strip"
+	Signatures SignaturePolicy `json:"signatures,omitempty"`
+	// RefreshMeta updates dates, generator and statistics in meta.xml
+	RefreshMeta bool `json:"refresh_meta,omitempty"`
+	// Strict fails the request instead of saving if any tag fails
+	Strict bool `json:"strict,omitempty"`
 }
 
 // ReplaceResponse represents the JSON response structure
@@ -34,58 +64,197 @@ type ReplaceResponse struct {
 	Message      string   `json:"message,omitempty"`
 	OutputBase64 string   `json:"output_base64,omitempty"`
 	ReplacedTags []string `json:"replaced_tags,omitempty"`
-	Error        string   `json:"error,omitempty"`
+	// FailedTags maps each tag that could not be replaced to the reason
+	FailedTags map[string]string `json:"failed_tags,omitempty"`
+	// InvalidatedSignatures lists signatures broken or stripped by the replacement
+	InvalidatedSignatures []Signature `json:"invalidated_signatures,omitempty"`
+	// Changes lists every part of the output that differs from the template
+	Changes []PartChange `json:"changes,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	// ErrorCode classifies download errors, e.g. "blocked_address"
+	ErrorCode string `json:"error_code,omitempty"`
 }
 
-// HTTPClient interface for testing
+// ExtractRequest represents the JSON request structure for extracting text
+type ExtractRequest struct {
+	Template TemplateSource `json:"template"`
+	Format   string         `json:"format"`
+}
+
+// ExtractResponse represents the JSON response structure for text extraction
+type ExtractResponse struct {
+	Success bool   `json:"success"`
+	Format  string `json:"format,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// ErrorCode classifies download errors, e.g. "blocked_address"
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// ValidateRequest represents the JSON request structure for validating a template
+type ValidateRequest struct {
+	Template TemplateSource `json:"template"`
+}
+
+// ValidateResponse represents the JSON response structure for validation
+type ValidateResponse struct {
+	Success  bool      `json:"success"`
+	Valid    bool      `json:"valid"`
+	Findings []Finding `json:"findings,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	// ErrorCode classifies download errors, e.g. "blocked_address"
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// TemplateResponse represents the JSON response of the template registry
+// endpoints
+type TemplateResponse struct {
+	Success   bool           `json:"success"`
+	Template  *TemplateInfo  `json:"template,omitempty"`
+	Templates []TemplateInfo `json:"templates,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// AssetResponse represents the JSON response of the asset library
+// endpoints
+type AssetResponse struct {
+	Success bool        `json:"success"`
+	Asset   *AssetInfo  `json:"asset,omitempty"`
+	Assets  []AssetInfo `json:"assets,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// JobResponse represents the JSON response of the job endpoints
+type JobResponse struct {
+	Success bool   `json:"success"`
+	Job     *Job   `json:"job,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// CacheResponse represents the JSON response of the cache admin endpoints
+type CacheResponse struct {
+	Success bool        `json:"success"`
+	Stats   *CacheStats `json:"stats,omitempty"`
+	Purged  int         `json:"purged,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// Supported extraction formats
+const (
+	FormatText     = "text"
+	FormatMarkdown = "markdown"
+)
+
+// HTTPClient interface for testing. Clients that also implement
+// Do(*http.Request), such as *http.Client, are sent requests carrying the
+// caller's context so that cancellation aborts downloads.
 type HTTPClient interface {
 	Get(url string) (*http.Response, error)
 }
 
-// DefaultHTTPClient is the default HTTP client with timeout
-var DefaultHTTPClient HTTPClient = &http.Client{
-	Timeout: 30 * time.Second,
+// requestDoer is implemented by HTTP clients that accept a request
+type requestDoer interface {
+	Do(req *http.Request) (*http.Response, error)
 }
 
-// fetchImageFromURL downloads an image from a URL
-func fetchImageFromURL(url string, client HTTPClient) ([]byte, error) {
+// contextReader stops reading once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// DefaultConverter renders thumbnails for requests that regenerate them.
+// It is nil unless configured, e.g. with a LibreOfficeConverter.
+var DefaultConverter Converter
+
+// DefaultHTTPClient is the default HTTP client. It enforces
+// DefaultURLPolicy and times out after 30 seconds.
+var DefaultHTTPClient HTTPClient = NewHTTPClient(DefaultURLPolicy, 30*time.Second)
+
+// fetchURL downloads url once, sending header if it is not nil, and
+// returns the body and the response headers
+func fetchURL(ctx context.Context, url string, header http.Header, client HTTPClient) ([]byte, http.Header, error) {
 	if url == "" || url == "null" {
-		return nil, fmt.Errorf("invalid URL")
+		return nil, nil, fmt.Errorf("invalid URL")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
 	}
 
-	resp, err := client.Get(url)
+	var resp *http.Response
+	var err error
+	if doer, ok := client.(requestDoer); ok {
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("fetch URL: %w", err)
+		}
+		if header != nil {
+			req.Header = header
+			if c, ok := doer.(*http.Client); ok {
+				doer = withoutCrossHostHeaders(c, req.URL.Host, header)
+			}
+		}
+		resp, err = doer.Do(req)
+	} else if header != nil {
+		return nil, nil, fmt.Errorf("fetch URL: HTTP client cannot send headers")
+	} else {
+		resp, err = client.Get(url)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("fetch URL: %w", err)
+		return nil, nil, fmt.Errorf("fetch URL: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error: %d %s", resp.StatusCode, resp.Status)
+		return nil, nil, &statusError{
+			code:       resp.StatusCode,
+			status:     resp.Status,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			header:     resp.Header,
+		}
 	}
 
 	// Limit response size to prevent memory exhaustion
-	limitReader := io.LimitReader(resp.Body, MaxIndividualFileSize+1)
+	limitReader := io.LimitReader(&contextReader{ctx, resp.Body}, MaxIndividualFileSize+1)
 	data, err := io.ReadAll(limitReader)
 	if err != nil {
-		return nil, fmt.Errorf("read response: %w", err)
+		return nil, nil, fmt.Errorf("read response: %w", err)
 	}
 
 	if len(data) > MaxIndividualFileSize {
-		return nil, fmt.Errorf("%w: image from URL exceeds limit", ErrFileTooLarge)
+		return nil, nil, fmt.Errorf("%w: image from URL exceeds limit", ErrFileTooLarge)
 	}
 
-	return data, nil
+	return data, resp.Header, nil
 }
 
-// decodeBase64Image decodes a base64-encoded image
-func decodeBase64Image(b64 string) ([]byte, error) {
+// decodeBase64Image decodes a base64-encoded image. Standard and URL-safe
+// base64, with or without padding, and data URIs are accepted.
+func decodeBase64Image(ctx context.Context, b64 string) ([]byte, error) {
 	if b64 == "" || b64 == "null" {
 		return nil, fmt.Errorf("invalid base64 data")
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	data, err := base64.StdEncoding.DecodeString(b64)
+	var data []byte
+	var err error
+	if isDataURI(b64) {
+		data, err = resolveDataURI(ctx, b64[5:])
+	} else {
+		data, err = decodeBase64Lenient(b64)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("decode base64: %w", err)
+		return nil, err
 	}
 
 	if len(data) > MaxIndividualFileSize {
@@ -95,34 +264,68 @@ func decodeBase64Image(b64 string) ([]byte, error) {
 	return data, nil
 }
 
-// getImageData retrieves image data from either URL or base64
-func getImageData(source ImageSource, client HTTPClient) ([]byte, error) {
+// getImageData retrieves image data from a resolved source, URL or base64
+func getImageData(ctx context.Context, source ImageSource, client HTTPClient, opts FetchOptions) ([]byte, error) {
+	// Registered sources take precedence
+	if source.Source != "" {
+		return resolveSource(ctx, source.Source)
+	}
+	if source.Asset != "" {
+		return resolveSource(ctx, "asset:"+source.Asset)
+	}
+
+	// Browsers often send data URIs as URLs
+	if isDataURI(source.URL) {
+		return decodeBase64Image(ctx, source.URL)
+	}
+
 	// Try URL first if provided
 	if source.URL != "" && source.URL != "null" {
-		return fetchImageFromURL(source.URL, client)
+		header, err := sourceHeader(source.URL, source.Headers, source.Credential, DefaultCredentials)
+		if err != nil {
+			return nil, err
+		}
+		return fetchRemote(ctx, source.URL, header, client, imageContent, opts)
 	}
 
 	// Try base64 if URL not provided
 	if source.Base64 != "" && source.Base64 != "null" {
-		return decodeBase64Image(source.Base64)
+		return decodeBase64Image(ctx, source.Base64)
 	}
 
-	return nil, fmt.Errorf("no valid image source provided (URL or base64)")
+	return nil, fmt.Errorf("no valid image source provided (source, asset, URL or base64)")
 }
 
 // getTemplateData retrieves ODT template data from either URL or base64
-func getTemplateData(source TemplateSource, client HTTPClient) ([]byte, error) {
+func getTemplateData(ctx context.Context, source TemplateSource, client HTTPClient) ([]byte, error) {
+	// Registered templates take precedence
+	if source.ID != "" {
+		if DefaultTemplates == nil {
+			return nil, fmt.Errorf("%w: template registry is disabled", ErrTemplateNotFound)
+		}
+		data, _, err := DefaultTemplates.Get(ctx, source.ID, source.Version)
+		return data, err
+	}
+
+	if isDataURI(source.URL) {
+		return decodeBase64Image(ctx, source.URL)
+	}
+
 	// Try URL first if provided
 	if source.URL != "" && source.URL != "null" {
-		return fetchImageFromURL(source.URL, client)
+		header, err := sourceHeader(source.URL, source.Headers, source.Credential, DefaultCredentials)
+		if err != nil {
+			return nil, err
+		}
+		return fetchRemote(ctx, source.URL, header, client, templateContent, DefaultFetchOptions)
 	}
 
 	// Try base64 if URL not provided
 	if source.Base64 != "" && source.Base64 != "null" {
-		return decodeBase64Image(source.Base64)
+		return decodeBase64Image(ctx, source.Base64)
 	}
 
-	return nil, fmt.Errorf("no valid template source provided (URL or base64)")
+	return nil, fmt.Errorf("no valid template source provided (ID, URL or base64)")
 }
 
 // ProcessReplaceRequest processes a replace request and returns the modified ODT
@@ -132,6 +335,27 @@ func ProcessReplaceRequest(req ReplaceRequest) (*ReplaceResponse, []byte, error)
 
 // ProcessReplaceRequestWithClient processes a replace request with a custom HTTP client
 func ProcessReplaceRequestWithClient(req ReplaceRequest, client HTTPClient) (*ReplaceResponse, []byte, error) {
+	return ProcessReplaceRequestWithClientContext(context.Background(), req, client)
+}
+
+// ProcessReplaceRequestContext processes a replace request, stopping
+// downloads, decoding and saving when ctx is cancelled or its deadline
+// passes
+func ProcessReplaceRequestContext(ctx context.Context, req ReplaceRequest) (*ReplaceResponse, []byte, error) {
+	return ProcessReplaceRequestWithClientContext(ctx, req, DefaultHTTPClient)
+}
+
+// ProcessReplaceRequestWithClientContext processes a replace request with
+// a custom HTTP client and cancellation
+func ProcessReplaceRequestWithClientContext(ctx context.Context, req ReplaceRequest, client HTTPClient) (*ReplaceResponse, []byte, error) {
+	return ProcessReplaceRequestWithProgress(ctx, req, client, nil)
+}
+
+// ProcessReplaceRequestWithProgress processes a replace request, reporting
+// each completed step to progress
+func ProcessReplaceRequestWithProgress(ctx context.Context, req ReplaceRequest, client HTTPClient, progress ProgressFunc) (*ReplaceResponse, []byte, error) {
+	report := progress.serialized()
+
 	// Validate request
 	if len(req.Data) == 0 {
 		return &ReplaceResponse{
@@ -139,18 +363,37 @@ func ProcessReplaceRequestWithClient(req ReplaceRequest, client HTTPClient) (*Re
 			Error:   "no images to replace",
 		}, nil, fmt.Errorf("no images to replace")
 	}
+	switch req.Thumbnail {
+	case "", ThumbnailKeep, ThumbnailRegenerate, ThumbnailRemove:
+	default:
+		return &ReplaceResponse{
+			Success: false,
+			Error:   fmt.Sprintf("invalid thumbnail mode '%s' (use keep, regenerate or remove)", req.Thumbnail),
+		}, nil, fmt.Errorf("invalid thumbnail mode: %s", req.Thumbnail)
+	}
+	switch req.Signatures {
+	case "", SignatureWarn, SignatureRefuse, SignatureStrip:
+	default:
+		return &ReplaceResponse{
+			Success: false,
+			Error:   fmt.Sprintf("invalid signature policy '%s' (use warn, refuse or strip)", req.Signatures),
+		}, nil, fmt.Errorf("invalid signature policy: %s", req.Signatures)
+	}
 
 	// Get template data
-	templateData, err := getTemplateData(req.Template, client)
+	templateData, err := getTemplateData(ctx, req.Template, client)
 	if err != nil {
 		return &ReplaceResponse{
-			Success: false,
-			Error:   fmt.Sprintf("failed to get template: %v", err),
+			Success:   false,
+			Error:     fmt.Sprintf("failed to get template: %v", err),
+			ErrorCode: fetchErrorCode(err),
 		}, nil, fmt.Errorf("get template: %w", err)
 	}
 
+	report(ProgressEvent{Type: ProgressTemplateFetched, Size: len(templateData)})
+
 	// Create temporary ODT document from template data
-	doc, err := NewODTDocumentFromBytes(templateData)
+	doc, err := NewODTDocumentFromBytesWithPassword(templateData, req.Template.Password)
 	if err != nil {
 		return &ReplaceResponse{
 			Success: false,
@@ -158,50 +401,108 @@ func ProcessReplaceRequestWithClient(req ReplaceRequest, client HTTPClient) (*Re
 		}, nil, fmt.Errorf("parse template: %w", err)
 	}
 
-	// Track successfully replaced tags
-	replacedTags := make([]string, 0, len(req.Data))
+	doc.SetMetaRefresh(req.RefreshMeta)
+	doc.SetThumbnailMode(req.Thumbnail)
+	doc.SetConverter(DefaultConverter)
+	if progress != nil && DefaultConverter != nil {
+		doc.SetConverter(progressConverter{DefaultConverter, report})
+	}
+	doc.SetSignaturePolicy(req.Signatures)
+	if req.Meta != nil {
+		if err := doc.SetMeta(*req.Meta); err != nil {
+			return &ReplaceResponse{
+				Success: false,
+				Error:   fmt.Sprintf("failed to set metadata: %v", err),
+			}, nil, fmt.Errorf("set meta: %w", err)
+		}
+	}
+
+	// Fetch every image, then replace them all in one pass
+	images := make(map[Selector]Image, len(req.Data))
+	failedTags := make(map[string]string)
 	var lastErr error
 
-	// Process each image replacement
-	for tag, imageSource := range req.Data {
-		// Get image data
-		imageData, err := getImageData(imageSource, client)
+	// Sources are fetched concurrently; results are used in tag order so
+	// that the outcome does not depend on download timing
+	done := 0
+	fetched := fetchImages(ctx, req.Data, client, DefaultFetchOptions, func(tag string, err error) {
+		done++
+		event := ProgressEvent{Type: ProgressImageFetched, Tag: tag, Done: done, Total: len(req.Data)}
 		if err != nil {
-			lastErr = fmt.Errorf("get image for tag '%s': %w", tag, err)
+			event.Type, event.Error = ProgressImageFailed, err.Error()
+		}
+		report(event)
+	})
+	tags := make([]string, 0, len(req.Data))
+	for tag := range req.Data {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	for _, tag := range tags {
+		result := fetched[tag]
+		if result.err != nil {
+			lastErr = fmt.Errorf("get image for tag '%s': %w", tag, result.err)
+			failedTags[tag] = lastErr.Error()
 			continue
 		}
 
-		// Determine image path in ODT
-		imagePath := fmt.Sprintf("Pictures/%s.png", tag)
+		// Images are stored at a path derived from the detected format
+		images[ByName(tag)] = Image{Data: result.data}
+	}
 
-		// Try to detect extension from data
-		if len(imageData) > 0 {
-			ext := detectImageExtension(imageData)
-			if ext != "" {
-				imagePath = fmt.Sprintf("Pictures/%s%s", tag, ext)
-			}
-		}
+	if err := ctx.Err(); err != nil {
+		return cancelledReplace(err)
+	}
 
-		// Replace the image
-		err = doc.ReplaceImageByTag(tag, imagePath, imageData)
-		if err != nil {
-			lastErr = fmt.Errorf("replace image for tag '%s': %w", tag, err)
+	results, err := doc.ReplaceImages(images)
+	if err != nil {
+		return &ReplaceResponse{
+			Success: false,
+			Error:   fmt.Sprintf("failed to replace images: %v", err),
+		}, nil, fmt.Errorf("replace images: %w", err)
+	}
+
+	replacedTags := make([]string, 0, len(results))
+	for i, result := range results {
+		tag := result.Selector.Value
+		event := ProgressEvent{Type: ProgressImageReplaced, Tag: tag, Done: i + 1, Total: len(results)}
+		if result.Err != nil {
+			lastErr = fmt.Errorf("replace image for tag '%s': %w", tag, result.Err)
+			failedTags[tag] = lastErr.Error()
+			event.Type, event.Error = ProgressImageFailed, lastErr.Error()
+			report(event)
 			continue
 		}
-
 		replacedTags = append(replacedTags, tag)
+		report(event)
+	}
+
+	// Strict requests are all-or-nothing
+	if req.Strict && len(failedTags) > 0 {
+		return &ReplaceResponse{
+			Success:    false,
+			FailedTags: failedTags,
+			Error:      fmt.Sprintf("strict mode: %d of %d image(s) failed: %v", len(failedTags), len(req.Data), lastErr),
+			ErrorCode:  fetchErrorCode(lastErr),
+		}, nil, fmt.Errorf("strict mode: %w", lastErr)
 	}
 
 	// Check if any images were replaced
 	if len(replacedTags) == 0 {
 		return &ReplaceResponse{
-			Success: false,
-			Error:   fmt.Sprintf("failed to replace any images: %v", lastErr),
+			Success:    false,
+			FailedTags: failedTags,
+			Error:      fmt.Sprintf("failed to replace any images: %v", lastErr),
+			ErrorCode:  fetchErrorCode(lastErr),
 		}, nil, fmt.Errorf("no images replaced: %w", lastErr)
 	}
 
 	// Save to bytes
-	outputData, err := doc.SaveToBytes()
+	outputData, err := doc.SaveToBytesContext(ctx)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return cancelledReplace(ctxErr)
+	}
 	if err != nil {
 		return &ReplaceResponse{
 			Success: false,
@@ -209,16 +510,142 @@ func ProcessReplaceRequestWithClient(req ReplaceRequest, client HTTPClient) (*Re
 		}, nil, fmt.Errorf("save output: %w", err)
 	}
 
+	report(ProgressEvent{Type: ProgressSaved, Size: len(outputData)})
+
 	// Create response
 	response := &ReplaceResponse{
-		Success:      true,
-		Message:      fmt.Sprintf("Successfully replaced %d image(s)", len(replacedTags)),
-		ReplacedTags: replacedTags,
+		Success:               true,
+		Message:               fmt.Sprintf("Successfully replaced %d image(s)", len(replacedTags)),
+		ReplacedTags:          replacedTags,
+		FailedTags:            failedTags,
+		InvalidatedSignatures: doc.InvalidatedSignatures(),
+		Changes:               doc.Changes(),
 	}
 
 	return response, outputData, nil
 }
 
+// cancelledReplace is the response to a replace request whose context
+// ended before it completed
+func cancelledReplace(err error) (*ReplaceResponse, []byte, error) {
+	return &ReplaceResponse{
+		Success: false,
+		Error:   fmt.Sprintf("request cancelled: %v", err),
+	}, nil, fmt.Errorf("request cancelled: %w", err)
+}
+
+// ProcessExtractRequest extracts plain text or Markdown from a template
+func ProcessExtractRequest(req ExtractRequest) (*ExtractResponse, error) {
+	return ProcessExtractRequestWithClient(req, DefaultHTTPClient)
+}
+
+// ProcessExtractRequestWithClient extracts text with a custom HTTP client
+func ProcessExtractRequestWithClient(req ExtractRequest, client HTTPClient) (*ExtractResponse, error) {
+	return ProcessExtractRequestWithClientContext(context.Background(), req, client)
+}
+
+// ProcessExtractRequestContext extracts text with cancellation
+func ProcessExtractRequestContext(ctx context.Context, req ExtractRequest) (*ExtractResponse, error) {
+	return ProcessExtractRequestWithClientContext(ctx, req, DefaultHTTPClient)
+}
+
+// ProcessExtractRequestWithClientContext extracts text with a custom HTTP
+// client and cancellation
+func ProcessExtractRequestWithClientContext(ctx context.Context, req ExtractRequest, client HTTPClient) (*ExtractResponse, error) {
+	format := req.Format
+	if format == "" {
+		format = FormatText
+	}
+	if format != FormatText && format != FormatMarkdown {
+		return &ExtractResponse{
+			Success: false,
+			Error:   fmt.Sprintf("unsupported format '%s' (use text or markdown)", req.Format),
+		}, fmt.Errorf("unsupported format: %s", req.Format)
+	}
+
+	// Get template data
+	templateData, err := getTemplateData(ctx, req.Template, client)
+	if err != nil {
+		return &ExtractResponse{
+			Success:   false,
+			Error:     fmt.Sprintf("failed to get template: %v", err),
+			ErrorCode: fetchErrorCode(err),
+		}, fmt.Errorf("get template: %w", err)
+	}
+
+	doc, err := NewODTDocumentFromBytesWithPassword(templateData, req.Template.Password)
+	if err != nil {
+		return &ExtractResponse{
+			Success: false,
+			Error:   fmt.Sprintf("failed to parse template: %v", err),
+		}, fmt.Errorf("parse template: %w", err)
+	}
+
+	var text string
+	if format == FormatMarkdown {
+		text, err = doc.ExtractMarkdown()
+	} else {
+		text, err = doc.ExtractText()
+	}
+	if err != nil {
+		return &ExtractResponse{
+			Success: false,
+			Error:   fmt.Sprintf("failed to extract text: %v", err),
+		}, fmt.Errorf("extract text: %w", err)
+	}
+
+	return &ExtractResponse{
+		Success: true,
+		Format:  format,
+		Text:    text,
+	}, nil
+}
+
+// ProcessValidateRequest validates a template package
+func ProcessValidateRequest(req ValidateRequest) (*ValidateResponse, error) {
+	return ProcessValidateRequestWithClient(req, DefaultHTTPClient)
+}
+
+// ProcessValidateRequestWithClient validates a template with a custom HTTP client
+func ProcessValidateRequestWithClient(req ValidateRequest, client HTTPClient) (*ValidateResponse, error) {
+	return ProcessValidateRequestWithClientContext(context.Background(), req, client)
+}
+
+// ProcessValidateRequestContext validates a template with cancellation
+func ProcessValidateRequestContext(ctx context.Context, req ValidateRequest) (*ValidateResponse, error) {
+	return ProcessValidateRequestWithClientContext(ctx, req, DefaultHTTPClient)
+}
+
+// ProcessValidateRequestWithClientContext validates a template with a
+// custom HTTP client and cancellation
+func ProcessValidateRequestWithClientContext(ctx context.Context, req ValidateRequest, client HTTPClient) (*ValidateResponse, error) {
+	// Get template data
+	templateData, err := getTemplateData(ctx, req.Template, client)
+	if err != nil {
+		return &ValidateResponse{
+			Success:   false,
+			Error:     fmt.Sprintf("failed to get template: %v", err),
+			ErrorCode: fetchErrorCode(err),
+		}, fmt.Errorf("get template: %w", err)
+	}
+
+	doc, err := NewODTDocumentFromBytesWithPassword(templateData, req.Template.Password)
+	if err != nil {
+		return &ValidateResponse{
+			Success: false,
+			Error:   fmt.Sprintf("failed to parse template: %v", err),
+		}, fmt.Errorf("parse template: %w", err)
+	}
+
+	report := doc.Validate()
+
+	return &ValidateResponse{
+		Success:  true,
+		Valid:    report.Valid,
+		Findings: report.Findings,
+	}, nil
+}
+
 // detectImageExtension detects image file extension from magic bytes
 func detectImageExtension(data []byte) string {
 	if len(data) < 12 {
@@ -251,19 +678,18 @@ func detectImageExtension(data []byte) string {
 
 // NewODTDocumentFromBytes creates an ODT document from byte data
 func NewODTDocumentFromBytes(data []byte) (*ODTDocument, error) {
+	return NewODTDocumentFromBytesWithPassword(data, "")
+}
+
+// NewODTDocumentFromBytesWithPassword creates an ODT document from byte data
+// that may be encrypted with the given password
+func NewODTDocumentFromBytesWithPassword(data []byte, password string) (*ODTDocument, error) {
 	// Validate size
 	if len(data) > MaxFileSize {
 		return nil, fmt.Errorf("%w: %d bytes (max: %d)", ErrFileTooLarge, len(data), MaxFileSize)
 	}
 
-	// Create temporary file
-	tmpFile, err := createTempODTFile(data)
-	if err != nil {
-		return nil, fmt.Errorf("create temp file: %w", err)
-	}
-
-	// Open as ODT document
-	doc, err := NewODTDocument(tmpFile)
+	doc, err := openODT("", data, password)
 	if err != nil {
 		return nil, fmt.Errorf("parse ODT: %w", err)
 	}
@@ -271,22 +697,6 @@ func NewODTDocumentFromBytes(data []byte) (*ODTDocument, error) {
 	return doc, nil
 }
 
-// createTempODTFile creates a temporary ODT file from byte data
-func createTempODTFile(data []byte) (string, error) {
-	tmpFile, err := os.CreateTemp("", "odt-*.odt")
-	if err != nil {
-		return "", err
-	}
-	defer tmpFile.Close()
-
-	if _, err := tmpFile.Write(data); err != nil {
-		os.Remove(tmpFile.Name())
-		return "", err
-	}
-
-	return tmpFile.Name(), nil
-}
-
 // ParseReplaceRequest parses a JSON request body
 func ParseReplaceRequest(body []byte) (*ReplaceRequest, error) {
 	var req ReplaceRequest
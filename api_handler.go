@@ -1,10 +1,16 @@
 package odtimagereplacer
 
 import (
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 )
 
@@ -21,8 +27,13 @@ func HandleReplaceImages(c *gin.Context) {
 		return
 	}
 
-	// Process the request
-	response, outputData, err := ProcessReplaceRequest(req)
+	if wantsEventStream(c) {
+		streamReplace(c, req)
+		return
+	}
+
+	// Process the request; a client disconnect cancels the work
+	response, outputData, err := ProcessReplaceRequestContext(c.Request.Context(), req)
 	if err != nil {
 		// Determine status code based on error
 		statusCode := http.StatusInternalServerError
@@ -40,6 +51,79 @@ func HandleReplaceImages(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// wantsEventStream reports whether the client asked for progress events
+// with "Accept: text/event-stream" or "?stream=true"
+func wantsEventStream(c *gin.Context) bool {
+	if stream, err := strconv.ParseBool(c.Query("stream")); err == nil {
+		return stream
+	}
+	return strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
+// streamReplace processes req while sending its progress as server-sent
+// events. The final "result" event carries the response, including the
+// output on success; failures end with an "error" event instead.
+func streamReplace(c *gin.Context, req ReplaceRequest) {
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	send := func(event string, data any) {
+		sse.Encode(c.Writer, sse.Event{Event: event, Data: data})
+		c.Writer.Flush()
+	}
+
+	response, outputData, err := ProcessReplaceRequestWithProgress(c.Request.Context(), req, DefaultHTTPClient, func(event ProgressEvent) {
+		send(event.Type, event)
+	})
+	if err != nil {
+		send("error", response)
+		return
+	}
+
+	response.OutputBase64 = base64.StdEncoding.EncodeToString(outputData)
+	send("result", response)
+}
+
+// batchWriter sends the ZIP headers with the first write, so that a batch
+// failing before any output can still answer with JSON
+type batchWriter struct {
+	c       *gin.Context
+	started bool
+}
+
+func (w *batchWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.c.Header("Content-Type", "application/zip")
+		w.c.Header("Content-Disposition", "attachment; filename=batch.zip")
+		w.c.Status(http.StatusOK)
+	}
+	return w.c.Writer.Write(p)
+}
+
+// HandleBatch renders many documents from one template and streams them
+// back as a ZIP with a report.json entry
+func HandleBatch(c *gin.Context) {
+	var req BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, BatchReport{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid JSON: %v", err),
+		})
+		return
+	}
+
+	w := &batchWriter{c: c}
+	report, err := ProcessBatchRequestContext(c.Request.Context(), req, DefaultHTTPClient, w)
+	if err != nil && !w.started {
+		c.JSON(http.StatusBadRequest, report)
+	}
+	// Failures after the first write truncate the ZIP, which clients detect
+}
+
 // HandleReplaceImagesDownload handles image replacement and returns the ODT file directly
 func HandleReplaceImagesDownload(c *gin.Context) {
 	var req ReplaceRequest
@@ -53,19 +137,391 @@ func HandleReplaceImagesDownload(c *gin.Context) {
 		return
 	}
 
-	// Process the request
-	response, outputData, err := ProcessReplaceRequest(req)
+	// Process the request; a client disconnect cancels the work
+	response, outputData, err := ProcessReplaceRequestContext(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, response)
 		return
 	}
 
+	// Report broken or stripped signatures since the body carries no JSON
+	if n := len(response.InvalidatedSignatures); n > 0 {
+		c.Header("X-Invalidated-Signatures", strconv.Itoa(n))
+	}
+
 	// Return the ODT file directly
 	c.Header("Content-Type", "application/vnd.oasis.opendocument.text")
 	c.Header("Content-Disposition", "attachment; filename=output.odt")
 	c.Data(http.StatusOK, "application/vnd.oasis.opendocument.text", outputData)
 }
 
+// HandleExtractText extracts plain text or Markdown from an ODT template
+func HandleExtractText(c *gin.Context) {
+	var req ExtractRequest
+
+	// Bind JSON request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ExtractResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid JSON: %v", err),
+		})
+		return
+	}
+
+	response, err := ProcessExtractRequestContext(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleValidate validates an ODT template and returns machine-readable findings
+func HandleValidate(c *gin.Context) {
+	var req ValidateRequest
+
+	// Bind JSON request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ValidateResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid JSON: %v", err),
+		})
+		return
+	}
+
+	response, err := ProcessValidateRequestContext(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// templateStatus maps template registry errors to HTTP status codes
+func templateStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrTemplateNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidPath), errors.Is(err, ErrInvalidODT), errors.Is(err, ErrFileTooLarge):
+		return http.StatusBadRequest
+	}
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// templateVersion parses the version path parameter; "latest" is 0
+func templateVersion(c *gin.Context) (int, bool) {
+	value := c.Param("version")
+	if value == "latest" {
+		return 0, true
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, TemplateResponse{
+			Success: false,
+			Error:   fmt.Sprintf("invalid version '%s'", value),
+		})
+		return 0, false
+	}
+	return version, true
+}
+
+// HandleUploadTemplate stores the request body as the next version of a
+// template
+func HandleUploadTemplate(c *gin.Context) {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MaxFileSize))
+	if err == nil {
+		var info *TemplateInfo
+		if info, err = DefaultTemplates.Upload(c.Request.Context(), c.Param("id"), data); err == nil {
+			c.JSON(http.StatusCreated, TemplateResponse{Success: true, Template: info})
+			return
+		}
+	}
+
+	c.JSON(templateStatus(err), TemplateResponse{
+		Success: false,
+		Error:   fmt.Sprintf("failed to upload template: %v", err),
+	})
+}
+
+// HandleListTemplates lists the latest version of every template
+func HandleListTemplates(c *gin.Context) {
+	infos, err := DefaultTemplates.List(c.Request.Context())
+	if err != nil {
+		c.JSON(templateStatus(err), TemplateResponse{Success: false, Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, TemplateResponse{Success: true, Templates: infos})
+}
+
+// HandleTemplateVersions lists the versions of a template
+func HandleTemplateVersions(c *gin.Context) {
+	infos, err := DefaultTemplates.Versions(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(templateStatus(err), TemplateResponse{Success: false, Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, TemplateResponse{Success: true, Templates: infos})
+}
+
+// HandleDownloadTemplate returns a version of a template as an ODT file
+func HandleDownloadTemplate(c *gin.Context) {
+	version, ok := templateVersion(c)
+	if !ok {
+		return
+	}
+
+	data, info, err := DefaultTemplates.Get(c.Request.Context(), c.Param("id"), version)
+	if err != nil {
+		c.JSON(templateStatus(err), TemplateResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	c.Header("X-Template-Version", strconv.Itoa(info.Version))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-v%d.odt", info.ID, info.Version))
+	c.Data(http.StatusOK, "application/vnd.oasis.opendocument.text", data)
+}
+
+// HandleDeleteTemplate deletes one version of a template, or all of them
+// without a version parameter
+func HandleDeleteTemplate(c *gin.Context) {
+	version := 0
+	if c.Param("version") != "" {
+		var ok bool
+		if version, ok = templateVersion(c); !ok {
+			return
+		}
+		if version == 0 {
+			c.JSON(http.StatusBadRequest, TemplateResponse{Success: false, Error: "delete a numbered version"})
+			return
+		}
+	}
+
+	if err := DefaultTemplates.Delete(c.Request.Context(), c.Param("id"), version); err != nil {
+		c.JSON(templateStatus(err), TemplateResponse{Success: false, Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, TemplateResponse{Success: true})
+}
+
+// assetStatus maps asset library errors to HTTP status codes
+func assetStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrAssetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidPath), errors.Is(err, ErrInvalidTag), errors.Is(err, ErrUnexpectedContent), errors.Is(err, ErrFileTooLarge):
+		return http.StatusBadRequest
+	}
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// HandleUploadAsset stores the request body as an image asset, tagged
+// with the tag query parameters
+func HandleUploadAsset(c *gin.Context) {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MaxIndividualFileSize))
+	if err == nil {
+		var info *AssetInfo
+		if info, err = DefaultAssets.Upload(c.Request.Context(), c.Param("id"), data, c.QueryArray("tag")); err == nil {
+			c.JSON(http.StatusCreated, AssetResponse{Success: true, Asset: info})
+			return
+		}
+	}
+
+	c.JSON(assetStatus(err), AssetResponse{
+		Success: false,
+		Error:   fmt.Sprintf("failed to upload asset: %v", err),
+	})
+}
+
+// HandleListAssets lists the assets, optionally only those with ?tag=
+func HandleListAssets(c *gin.Context) {
+	infos, err := DefaultAssets.List(c.Request.Context(), c.Query("tag"))
+	if err != nil {
+		c.JSON(assetStatus(err), AssetResponse{Success: false, Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, AssetResponse{Success: true, Assets: infos})
+}
+
+// HandleDownloadAsset returns the image of an asset
+func HandleDownloadAsset(c *gin.Context) {
+	ctx := c.Request.Context()
+	info, err := DefaultAssets.Info(ctx, c.Param("id"))
+	var data []byte
+	if err == nil {
+		data, err = DefaultAssets.Get(ctx, info.ID)
+	}
+	if err != nil {
+		c.JSON(assetStatus(err), AssetResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	c.Header("ETag", `"`+info.SHA256+`"`)
+	c.Data(http.StatusOK, info.MediaType, data)
+}
+
+// HandleTagAsset replaces the tags of an asset with those in the JSON body
+func HandleTagAsset(c *gin.Context) {
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, AssetResponse{
+			Success: false,
+			Error:   fmt.Sprintf("invalid request: %v", err),
+		})
+		return
+	}
+
+	info, err := DefaultAssets.SetTags(c.Request.Context(), c.Param("id"), req.Tags)
+	if err != nil {
+		c.JSON(assetStatus(err), AssetResponse{Success: false, Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, AssetResponse{Success: true, Asset: info})
+}
+
+// HandleDeleteAsset deletes an asset
+func HandleDeleteAsset(c *gin.Context) {
+	if err := DefaultAssets.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		c.JSON(assetStatus(err), AssetResponse{Success: false, Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, AssetResponse{Success: true})
+}
+
+// jobStatus maps job queue errors to HTTP status codes
+func jobStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrJobNotFinished):
+		return http.StatusConflict
+	case errors.Is(err, ErrQueueFull):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrURLNotAllowed):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// HandleSubmitJob queues a replace request and returns its job at once
+func HandleSubmitJob(c *gin.Context) {
+	var req JobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, JobResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid JSON: %v", err),
+		})
+		return
+	}
+	if len(req.Data) == 0 {
+		c.JSON(http.StatusBadRequest, JobResponse{Success: false, Error: "no image data provided"})
+		return
+	}
+
+	job, err := DefaultJobs.Submit(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(jobStatus(err), JobResponse{
+			Success: false,
+			Error:   fmt.Sprintf("failed to submit job: %v", err),
+		})
+		return
+	}
+
+	c.Header("Location", "/api/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, JobResponse{Success: true, Job: job})
+}
+
+// HandleGetJob returns the status and result of a job
+func HandleGetJob(c *gin.Context) {
+	job, err := DefaultJobs.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(jobStatus(err), JobResponse{Success: false, Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, JobResponse{Success: true, Job: job})
+}
+
+// HandleJobOutput returns the ODT file of a succeeded job
+func HandleJobOutput(c *gin.Context) {
+	data, err := DefaultJobs.Output(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(jobStatus(err), JobResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.odt", c.Param("id")))
+	c.Data(http.StatusOK, "application/vnd.oasis.opendocument.text", data)
+}
+
+// HandleDeleteJob deletes a finished job and its output
+func HandleDeleteJob(c *gin.Context) {
+	if err := DefaultJobs.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		c.JSON(jobStatus(err), JobResponse{Success: false, Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, JobResponse{Success: true})
+}
+
+// AdminToken authenticates the /admin endpoints, which are only served
+// when it is set. Clients send it as "Authorization: Bearer <token>".
+var AdminToken string
+
+// requireAdmin rejects requests without the admin token
+func requireAdmin(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"error":   "admin token required",
+		})
+		return
+	}
+	c.Next()
+}
+
+// HandleCacheStats reports the hits, misses and size of the download cache
+func HandleCacheStats(c *gin.Context) {
+	cache := DefaultFetchOptions.Cache
+	if cache == nil {
+		c.JSON(http.StatusNotFound, CacheResponse{Success: false, Error: "download cache is disabled"})
+		return
+	}
+
+	stats := cache.Stats()
+	c.JSON(http.StatusOK, CacheResponse{Success: true, Stats: &stats})
+}
+
+// HandlePurgeCache removes the cached downloads of the "url" query
+// parameter, or every download without it
+func HandlePurgeCache(c *gin.Context) {
+	cache := DefaultFetchOptions.Cache
+	if cache == nil {
+		c.JSON(http.StatusNotFound, CacheResponse{Success: false, Error: "download cache is disabled"})
+		return
+	}
+
+	var purged int
+	if url := c.Query("url"); url != "" {
+		purged = cache.Purge(url)
+	} else {
+		purged = cache.PurgeAll()
+	}
+
+	stats := cache.Stats()
+	c.JSON(http.StatusOK, CacheResponse{Success: true, Stats: &stats, Purged: purged})
+}
+
 // HandleHealthCheck is a simple health check endpoint
 func HandleHealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -81,10 +537,29 @@ func HandleInfo(c *gin.Context) {
 		"version":     "2.0.0",
 		"description": "Replace images in ODT documents via JSON API",
 		"endpoints": map[string]string{
-			"POST /api/replace":          "Replace images and return JSON with base64 output",
-			"POST /api/replace/download": "Replace images and download ODT file directly",
-			"GET  /health":               "Health check endpoint",
-			"GET  /info":                 "Service information",
+			"POST /api/replace":                         "Replace images and return JSON with base64 output",
+			"POST /api/replace/download":                "Replace images and download ODT file directly",
+			"POST /api/extract":                         "Extract plain text or Markdown from an ODT",
+			"POST /api/validate":                        "Validate an ODT package and list findings",
+			"POST /api/batch":                           "Render many documents from one template as a ZIP",
+			"GET  /api/templates":                       "List registered templates",
+			"POST /api/templates/:id":                   "Upload a new version of a template (ODT body)",
+			"GET  /api/templates/:id":                   "List the versions of a template",
+			"GET  /api/templates/:id/versions/:version": "Download a template version (or latest)",
+			"DELETE /api/templates/:id":                 "Delete a template, or one version under /versions/:version",
+			"GET  /api/assets":                          "List image assets, optionally ?tag=",
+			"POST /api/assets/:id":                      "Upload an image asset (image body, ?tag= repeatable)",
+			"GET  /api/assets/:id":                      "Download an image asset",
+			"PUT  /api/assets/:id/tags":                 "Replace the tags of an asset",
+			"DELETE /api/assets/:id":                    "Delete an image asset",
+			"POST /api/jobs":                            "Queue a replace request; returns a job ID",
+			"GET  /api/jobs/:id":                        "Job status and result",
+			"GET  /api/jobs/:id/output":                 "Download the ODT file of a succeeded job",
+			"DELETE /api/jobs/:id":                      "Delete a finished job and its output",
+			"GET  /admin/cache":                         "Download cache statistics (admin token)",
+			"DELETE /admin/cache":                       "Purge the download cache, or one URL with ?url= (admin token)",
+			"GET  /health":                              "Health check endpoint",
+			"GET  /info":                                "Service information",
 		},
 	})
 }
@@ -102,6 +577,54 @@ func SetupRouter() *gin.Engine {
 	{
 		api.POST("/replace", HandleReplaceImages)
 		api.POST("/replace/download", HandleReplaceImagesDownload)
+		api.POST("/extract", HandleExtractText)
+		api.POST("/validate", HandleValidate)
+		api.POST("/batch", HandleBatch)
+	}
+
+	// Template registry endpoints, when a registry is configured
+	if DefaultTemplates != nil {
+		templates := api.Group("/templates")
+		{
+			templates.GET("", HandleListTemplates)
+			templates.POST("/:id", HandleUploadTemplate)
+			templates.GET("/:id", HandleTemplateVersions)
+			templates.DELETE("/:id", HandleDeleteTemplate)
+			templates.GET("/:id/versions/:version", HandleDownloadTemplate)
+			templates.DELETE("/:id/versions/:version", HandleDeleteTemplate)
+		}
+	}
+
+	// Asset library endpoints, when a library is configured
+	if DefaultAssets != nil {
+		assets := api.Group("/assets")
+		{
+			assets.GET("", HandleListAssets)
+			assets.POST("/:id", HandleUploadAsset)
+			assets.GET("/:id", HandleDownloadAsset)
+			assets.PUT("/:id/tags", HandleTagAsset)
+			assets.DELETE("/:id", HandleDeleteAsset)
+		}
+	}
+
+	// Asynchronous job endpoints, when a job queue is configured
+	if DefaultJobs != nil {
+		jobs := api.Group("/jobs")
+		{
+			jobs.POST("", HandleSubmitJob)
+			jobs.GET("/:id", HandleGetJob)
+			jobs.GET("/:id/output", HandleJobOutput)
+			jobs.DELETE("/:id", HandleDeleteJob)
+		}
+	}
+
+	// Admin endpoints require AdminToken
+	if AdminToken != "" {
+		admin := router.Group("/admin", requireAdmin)
+		{
+			admin.GET("/cache", HandleCacheStats)
+			admin.DELETE("/cache", HandlePurgeCache)
+		}
 	}
 
 	return router
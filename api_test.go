@@ -0,0 +1,109 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetchImageFromURL_Cancelled(t *testing.T) {
+	// The server sends headers, then stalls until the test ends
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testPNG))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := fetchURL(ctx, server.URL, nil, server.Client())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("fetchURL() error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("fetchURL() returned after %v", elapsed)
+	}
+}
+
+func TestProcessReplaceRequestContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req := ReplaceRequest{
+		Template: TemplateSource{Base64: base64.StdEncoding.EncodeToString(templateTestData(t))},
+		Data:     map[string]ImageSource{"image1": {Base64: base64.StdEncoding.EncodeToString([]byte(testPNG))}},
+	}
+	response, output, err := ProcessReplaceRequestWithClientContext(ctx, req, http.DefaultClient)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ProcessReplaceRequestWithClientContext() error = %v, want Canceled", err)
+	}
+	if response == nil || response.Success || output != nil {
+		t.Errorf("response = %+v with %d output bytes", response, len(output))
+	}
+
+	// The same request succeeds without cancellation
+	response, output, err = ProcessReplaceRequestWithClientContext(context.Background(), req, http.DefaultClient)
+	if err != nil || !response.Success || len(output) == 0 {
+		t.Errorf("ProcessReplaceRequestWithClientContext() = %+v, %v", response, err)
+	}
+}
+
+func TestODTDocument_SaveToBytesContext(t *testing.T) {
+	doc, err := NewODTDocumentFromBytes(templateTestData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := doc.SaveToBytesContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("SaveToBytesContext() error = %v, want Canceled", err)
+	}
+}
+
+func TestProcessReplaceRequest_RefreshMeta(t *testing.T) {
+	template := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"meta.xml", testMetaXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	for _, refresh := range []bool{false, true} {
+		req := ReplaceRequest{
+			Template:    TemplateSource{Base64: base64.StdEncoding.EncodeToString(template)},
+			Data:        map[string]ImageSource{"image1": {Base64: base64.StdEncoding.EncodeToString([]byte(testPNG))}},
+			RefreshMeta: refresh,
+		}
+		response, output, err := ProcessReplaceRequestWithClient(req, http.DefaultClient)
+		if err != nil || !response.Success {
+			t.Fatalf("refresh %v: ProcessReplaceRequestWithClient() = %+v, %v", refresh, response, err)
+		}
+
+		doc, err := NewODTDocumentFromBytes(output)
+		if err != nil {
+			t.Fatalf("refresh %v: NewODTDocumentFromBytes() error = %v", refresh, err)
+		}
+		meta, err := doc.readFile(metaPath)
+		if err != nil {
+			t.Fatalf("refresh %v: readFile(meta.xml) error = %v", refresh, err)
+		}
+		if unchanged := string(meta) == testMetaXML; unchanged == refresh {
+			t.Errorf("refresh %v: meta.xml unchanged = %v:\n%s", refresh, unchanged, meta)
+		}
+	}
+}
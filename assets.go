@@ -0,0 +1,291 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AssetInfo describes a stored image
+type AssetInfo struct {
+	ID        string    `json:"id"`
+	SHA256    string    `json:"sha256"`
+	Size      int       `json:"size"`
+	MediaType string    `json:"media_type"`
+	Tags      []string  `json:"tags,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// AssetLibrary stores images such as logos and signatures by ID in a
+// Storage. Image data is kept once per content hash, however many IDs
+// refer to it, with a count of those IDs. An AssetLibrary implements
+// AssetStore and is safe for concurrent use.
+type AssetLibrary struct {
+	storage Storage
+	mu      sync.Mutex // serializes changes and reference counts
+}
+
+// DefaultAssets serves the /api/assets endpoints. It is nil unless
+// configured; register it with AssetResolver to resolve "asset:<id>".
+var DefaultAssets *AssetLibrary
+
+// NewAssetLibrary creates a library keeping assets in storage
+func NewAssetLibrary(storage Storage) *AssetLibrary {
+	return &AssetLibrary{storage: storage}
+}
+
+const (
+	assetMetaPrefix = "assets/meta/"
+	assetBlobPrefix = "assets/blobs/"
+	assetRefPrefix  = "assets/refs/"
+)
+
+func assetMetaKey(id string) string {
+	return assetMetaPrefix + id + ".json"
+}
+
+func assetBlobKey(sum string) string {
+	return assetBlobPrefix + sum
+}
+
+func assetRefKey(sum string) string {
+	return assetRefPrefix + sum
+}
+
+// normalizeTags trims, deduplicates and sorts tags
+func normalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if len(tag) > 64 {
+			return nil, fmt.Errorf("%w: %q is longer than 64 bytes", ErrInvalidTag, tag)
+		}
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return slices.Compact(normalized), nil
+}
+
+// Upload stores an image under id with tags, replacing an asset of the
+// same ID
+func (l *AssetLibrary) Upload(ctx context.Context, id string, data []byte, tags []string) (*AssetInfo, error) {
+	if !storageIDRegex.MatchString(id) {
+		return nil, fmt.Errorf("%w: invalid asset ID %q", ErrInvalidPath, id)
+	}
+	if len(data) > MaxIndividualFileSize {
+		return nil, fmt.Errorf("%w: asset exceeds limit", ErrFileTooLarge)
+	}
+	format := detectImageFormat(data)
+	if format == "" {
+		return nil, fmt.Errorf("%w: asset is not a supported image format", ErrUnexpectedContent)
+	}
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	info := &AssetInfo{
+		ID:        id,
+		SHA256:    hex.EncodeToString(sum[:]),
+		Size:      len(data),
+		MediaType: detectMIMEType("asset" + format),
+		Tags:      tags,
+		Created:   time.Now().UTC(),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previous, err := l.info(ctx, id)
+	if err != nil && !errors.Is(err, ErrAssetNotFound) {
+		return nil, err
+	}
+
+	// Identical images share one blob
+	if previous == nil || previous.SHA256 != info.SHA256 {
+		if err := l.retainBlob(ctx, info.SHA256, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := l.putInfo(ctx, info); err != nil {
+		return nil, err
+	}
+	if previous != nil && previous.SHA256 != info.SHA256 {
+		if err := l.releaseBlob(ctx, previous.SHA256); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// Get returns the image data of asset id
+func (l *AssetLibrary) Get(ctx context.Context, id string) ([]byte, error) {
+	info, err := l.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := l.storage.Get(ctx, assetBlobKey(info.SHA256))
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrAssetNotFound, id)
+	}
+	return data, err
+}
+
+// Info returns the description of asset id
+func (l *AssetLibrary) Info(ctx context.Context, id string) (*AssetInfo, error) {
+	if !storageIDRegex.MatchString(id) {
+		return nil, fmt.Errorf("%w: invalid asset ID %q", ErrAssetNotFound, id)
+	}
+	return l.info(ctx, id)
+}
+
+func (l *AssetLibrary) info(ctx context.Context, id string) (*AssetInfo, error) {
+	data, err := l.storage.Get(ctx, assetMetaKey(id))
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrAssetNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var info AssetInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("decode asset %s: %w", id, err)
+	}
+	return &info, nil
+}
+
+func (l *AssetLibrary) putInfo(ctx context.Context, info *AssetInfo) error {
+	meta, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("encode asset info: %w", err)
+	}
+	if err := l.storage.Put(ctx, assetMetaKey(info.ID), meta); err != nil {
+		return fmt.Errorf("store asset info: %w", err)
+	}
+	return nil
+}
+
+// List returns the assets carrying tag, or all assets if tag is empty,
+// sorted by ID
+func (l *AssetLibrary) List(ctx context.Context, tag string) ([]AssetInfo, error) {
+	keys, err := l.storage.List(ctx, assetMetaPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]AssetInfo, 0, len(keys))
+	for _, key := range keys {
+		id := strings.TrimSuffix(strings.TrimPrefix(key, assetMetaPrefix), ".json")
+		info, err := l.info(ctx, id)
+		if errors.Is(err, ErrAssetNotFound) {
+			continue // deleted concurrently
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tag == "" || slices.Contains(info.Tags, tag) {
+			infos = append(infos, *info)
+		}
+	}
+	return infos, nil
+}
+
+// SetTags replaces the tags of asset id
+func (l *AssetLibrary) SetTags(ctx context.Context, id string, tags []string) (*AssetInfo, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, err := l.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	info.Tags = tags
+	if err := l.putInfo(ctx, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Delete removes asset id, and its image data unless other assets share it
+func (l *AssetLibrary) Delete(ctx context.Context, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, err := l.Info(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := l.storage.Delete(ctx, assetMetaKey(id)); err != nil {
+		return err
+	}
+	return l.releaseBlob(ctx, info.SHA256)
+}
+
+// refs returns the number of assets referring to the image data of sum.
+// Callers hold l.mu.
+func (l *AssetLibrary) refs(ctx context.Context, sum string) (int, error) {
+	data, err := l.storage.Get(ctx, assetRefKey(sum))
+	if errors.Is(err, ErrObjectNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("decode reference count of %s: %w", sum, err)
+	}
+	return n, nil
+}
+
+// retainBlob adds a reference to the image data of sum, storing data if
+// it is the first. Callers hold l.mu.
+func (l *AssetLibrary) retainBlob(ctx context.Context, sum string, data []byte) error {
+	n, err := l.refs(ctx, sum)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if err := l.storage.Put(ctx, assetBlobKey(sum), data); err != nil {
+			return fmt.Errorf("store asset: %w", err)
+		}
+	}
+	return l.storage.Put(ctx, assetRefKey(sum), []byte(strconv.Itoa(n+1)))
+}
+
+// releaseBlob removes a reference to the image data of sum, deleting it
+// with the last one. Callers hold l.mu.
+func (l *AssetLibrary) releaseBlob(ctx context.Context, sum string) error {
+	n, err := l.refs(ctx, sum)
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return l.storage.Put(ctx, assetRefKey(sum), []byte(strconv.Itoa(n-1)))
+	}
+	if err := l.storage.Delete(ctx, assetBlobKey(sum)); err != nil {
+		return err
+	}
+	return l.storage.Delete(ctx, assetRefKey(sum))
+}
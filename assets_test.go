@@ -0,0 +1,180 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAssetLibrary(t *testing.T) {
+	fileStorage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storages := map[string]Storage{
+		"memory": NewMemoryStorage(),
+		"file":   fileStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			library := NewAssetLibrary(storage)
+			logo := []byte(testPNG)
+
+			info, err := library.Upload(ctx, "acme-logo", logo, []string{"logo", " acme ", "logo"})
+			if err != nil {
+				t.Fatalf("Upload() error = %v", err)
+			}
+			if info.MediaType != "image/png" || info.Size != len(logo) || !reflect.DeepEqual(info.Tags, []string{"acme", "logo"}) {
+				t.Errorf("Upload() = %+v", info)
+			}
+
+			// The same image under another ID shares the stored data
+			if _, err := library.Upload(ctx, "header-logo", logo, nil); err != nil {
+				t.Fatal(err)
+			}
+			if blobs, _ := storage.List(ctx, assetBlobPrefix); len(blobs) != 1 {
+				t.Errorf("stored blobs = %v, want one", blobs)
+			}
+
+			if got, err := library.Get(ctx, "header-logo"); err != nil || string(got) != testPNG {
+				t.Errorf("Get() = %q, %v", got, err)
+			}
+			if list, err := library.List(ctx, "acme"); err != nil || len(list) != 1 || list[0].ID != "acme-logo" {
+				t.Errorf("List(acme) = %+v, %v", list, err)
+			}
+			if list, err := library.List(ctx, ""); err != nil || len(list) != 2 {
+				t.Errorf("List() = %+v, %v", list, err)
+			}
+
+			if info, err := library.SetTags(ctx, "header-logo", []string{"header"}); err != nil || !reflect.DeepEqual(info.Tags, []string{"header"}) {
+				t.Errorf("SetTags() = %+v, %v", info, err)
+			}
+
+			// Uploading the same image again under an ID keeps one reference
+			if _, err := library.Upload(ctx, "header-logo", logo, nil); err != nil {
+				t.Fatal(err)
+			}
+			if refs, err := library.refs(ctx, info.SHA256); err != nil || refs != 2 {
+				t.Errorf("refs() = %d, %v; want 2", refs, err)
+			}
+
+			// Shared data is kept until no asset refers to it
+			if err := library.Delete(ctx, "acme-logo"); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := library.Get(ctx, "header-logo"); err != nil || string(got) != testPNG {
+				t.Errorf("Get() after deleting a duplicate = %q, %v", got, err)
+			}
+			if _, err := library.Upload(ctx, "header-logo", []byte(testPNG+"v2"), nil); err != nil {
+				t.Fatal(err)
+			}
+			if blobs, _ := storage.List(ctx, assetBlobPrefix); len(blobs) != 1 {
+				t.Errorf("stored blobs after replacing = %v, want one", blobs)
+			}
+
+			if err := library.Delete(ctx, "header-logo"); err != nil {
+				t.Fatal(err)
+			}
+			if blobs, _ := storage.List(ctx, assetBlobPrefix); len(blobs) != 0 {
+				t.Errorf("stored blobs after deleting all = %v", blobs)
+			}
+			if refs, _ := storage.List(ctx, assetRefPrefix); len(refs) != 0 {
+				t.Errorf("reference counts after deleting all = %v", refs)
+			}
+			if _, err := library.Get(ctx, "header-logo"); !errors.Is(err, ErrAssetNotFound) {
+				t.Errorf("Get() after Delete() error = %v, want ErrAssetNotFound", err)
+			}
+		})
+	}
+}
+
+func TestAssetLibrary_UploadInvalid(t *testing.T) {
+	library := NewAssetLibrary(NewMemoryStorage())
+
+	tests := []struct {
+		name    string
+		id      string
+		data    []byte
+		tags    []string
+		wantErr error
+	}{
+		{"traversal ID", "../logo", []byte(testPNG), nil, ErrInvalidPath},
+		{"not an image", "logo", []byte("<html>"), nil, ErrUnexpectedContent},
+		{"long tag", "logo", []byte(testPNG), []string{strings.Repeat("x", 65)}, ErrInvalidTag},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := library.Upload(context.Background(), tt.id, tt.data, tt.tags); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Upload() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetImageData_Asset(t *testing.T) {
+	library := NewAssetLibrary(NewMemoryStorage())
+	if _, err := library.Upload(context.Background(), "signature", []byte(testPNG), nil); err != nil {
+		t.Fatal(err)
+	}
+	RegisterResolver("asset", AssetResolver{Store: library})
+	defer RegisterResolver("asset", nil)
+
+	got, err := getImageData(context.Background(), ImageSource{Asset: "signature"}, nil, DefaultFetchOptions)
+	if err != nil || string(got) != testPNG {
+		t.Errorf("getImageData() = %q, %v", got, err)
+	}
+	if _, err := getImageData(context.Background(), ImageSource{Asset: "missing"}, nil, DefaultFetchOptions); !errors.Is(err, ErrAssetNotFound) {
+		t.Errorf("getImageData(missing) error = %v, want ErrAssetNotFound", err)
+	}
+}
+
+func TestAssetEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(l *AssetLibrary) { DefaultAssets = l }(DefaultAssets)
+	DefaultAssets = NewAssetLibrary(NewMemoryStorage())
+	router := SetupRouter()
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	if w := serve(http.MethodPost, "/api/assets/logo?tag=logo", testPNG); w.Code != http.StatusCreated {
+		t.Fatalf("upload status = %d: %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodPost, "/api/assets/page", "<html>"); w.Code != http.StatusBadRequest {
+		t.Errorf("upload of non-image status = %d, want 400", w.Code)
+	}
+
+	w := serve(http.MethodGet, "/api/assets/logo", "")
+	if w.Code != http.StatusOK || w.Body.String() != testPNG || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("download = %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	if w := serve(http.MethodPut, "/api/assets/logo/tags", `{"tags":["brand"]}`); w.Code != http.StatusOK {
+		t.Errorf("tag status = %d: %s", w.Code, w.Body)
+	}
+	var list AssetResponse
+	w = serve(http.MethodGet, "/api/assets?tag=brand", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Assets) != 1 {
+		t.Errorf("list by tag = %s, %v", w.Body, err)
+	}
+
+	if w := serve(http.MethodDelete, "/api/assets/logo", ""); w.Code != http.StatusOK {
+		t.Errorf("delete status = %d", w.Code)
+	}
+	if w := serve(http.MethodGet, "/api/assets/logo", ""); w.Code != http.StatusNotFound {
+		t.Errorf("download after delete status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,312 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BatchDocument is one document of a batch request
+type BatchDocument struct {
+	// Name is the file name of the output in the ZIP, with or without .odt
+	Name string                 `json:"name"`
+	Data map[string]ImageSource `json:"data"`
+}
+
+// BatchRequest renders many documents from one template. Meta,
+// RefreshMeta, Thumbnail and Signatures apply to every document as in a
+// ReplaceRequest.
+type BatchRequest struct {
+	Template  TemplateSource  `json:"template"`
+	Documents []BatchDocument `json:"documents"`
+	Meta      *DocumentMeta   `json:"meta,omitempty"`
+	// Thumbnail is "keep" (default), "regenerate" or "remove"
+	Thumbnail ThumbnailMode `json:"thumbnail,omitempty"`
+	// Signatures is "warn" (default), "refuse" or "strip"
+	Signatures SignaturePolicy `json:"signatures,omitempty"`
+	// RefreshMeta updates dates, generator and statistics in meta.xml
+	RefreshMeta bool `json:"refresh_meta,omitempty"`
+	// Strict fails a document instead of saving it if any of its tags fails
+	Strict bool `json:"strict,omitempty"`
+}
+
+// BatchResult reports the outcome of one document of a batch
+type BatchResult struct {
+	Name string `json:"name"`
+	// File is the entry of the document in the ZIP; empty if it failed
+	File         string            `json:"file,omitempty"`
+	Success      bool              `json:"success"`
+	ReplacedTags []string          `json:"replaced_tags,omitempty"`
+	FailedTags   map[string]string `json:"failed_tags,omitempty"`
+	// InvalidatedSignatures lists signatures broken or stripped in the document
+	InvalidatedSignatures []Signature `json:"invalidated_signatures,omitempty"`
+	Error                 string      `json:"error,omitempty"`
+}
+
+// BatchReport summarizes a batch request. It is the report.json entry of
+// the ZIP, and the JSON response if the batch could not start.
+type BatchReport struct {
+	Success   bool          `json:"success"`
+	Total     int           `json:"total"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Documents []BatchResult `json:"documents,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	// ErrorCode classifies download errors, e.g. "blocked_address"
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// BatchOptions limits batch requests
+type BatchOptions struct {
+	// Workers is the number of documents rendered at the same time
+	Workers int
+	// MaxDocuments is the largest number of documents in one request
+	MaxDocuments int
+}
+
+// DefaultBatchOptions are used by ProcessBatchRequestContext
+var DefaultBatchOptions = BatchOptions{
+	Workers:      runtime.NumCPU(),
+	MaxDocuments: 1000,
+}
+
+// batchReportName is the ZIP entry of the report
+const batchReportName = "report.json"
+
+// batchFileName returns the ZIP entry of a document name
+func batchFileName(name string) (string, error) {
+	base := strings.TrimSuffix(name, ".odt")
+	if !storageIDRegex.MatchString(base) {
+		return "", fmt.Errorf("%w: invalid document name %q", ErrInvalidPath, name)
+	}
+	return base + ".odt", nil
+}
+
+// ProcessBatchRequestContext renders every document of req from one
+// parsed template and writes a ZIP of the outputs to w, followed by
+// report.json. Documents are rendered in parallel and written as they
+// complete. Invalid requests and template failures return an error before
+// anything is written.
+func ProcessBatchRequestContext(ctx context.Context, req BatchRequest, client HTTPClient, w io.Writer) (*BatchReport, error) {
+	opts := DefaultBatchOptions
+	fail := func(err error) (*BatchReport, error) {
+		return &BatchReport{
+			Success:   false,
+			Total:     len(req.Documents),
+			Error:     err.Error(),
+			ErrorCode: fetchErrorCode(err),
+		}, err
+	}
+
+	// Validate request
+	if len(req.Documents) == 0 {
+		return fail(fmt.Errorf("no documents provided"))
+	}
+	if opts.MaxDocuments > 0 && len(req.Documents) > opts.MaxDocuments {
+		return fail(fmt.Errorf("too many documents: %d (max: %d)", len(req.Documents), opts.MaxDocuments))
+	}
+	files := make(map[string]bool, len(req.Documents))
+	for _, doc := range req.Documents {
+		file, err := batchFileName(doc.Name)
+		if err != nil {
+			return fail(err)
+		}
+		if files[file] {
+			return fail(fmt.Errorf("duplicate document name %q", doc.Name))
+		}
+		files[file] = true
+	}
+	switch req.Thumbnail {
+	case "", ThumbnailKeep, ThumbnailRegenerate, ThumbnailRemove:
+	default:
+		return fail(fmt.Errorf("invalid thumbnail mode '%s' (use keep, regenerate or remove)", req.Thumbnail))
+	}
+	switch req.Signatures {
+	case "", SignatureWarn, SignatureRefuse, SignatureStrip:
+	default:
+		return fail(fmt.Errorf("invalid signature policy '%s' (use warn, refuse or strip)", req.Signatures))
+	}
+
+	// The template is fetched and parsed once for all documents
+	templateData, err := getTemplateData(ctx, req.Template, client)
+	if err != nil {
+		return fail(fmt.Errorf("failed to get template: %w", err))
+	}
+	tmpl, err := NewTemplateWithOptions(templateData, TemplateOptions{
+		Password:    req.Template.Password,
+		Meta:        req.Meta,
+		RefreshMeta: req.RefreshMeta,
+		Thumbnail:   req.Thumbnail,
+		Converter:   DefaultConverter,
+		Signatures:  req.Signatures,
+	})
+	if err != nil {
+		return fail(fmt.Errorf("failed to parse template: %w", err))
+	}
+
+	type rendered struct {
+		index  int
+		result BatchResult
+		data   []byte
+	}
+	jobs := make(chan int)
+	done := make(chan rendered)
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(opts.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				result, data := renderBatchDocument(ctx, tmpl, req.Documents[index], req.Strict, client)
+				select {
+				case done <- rendered{index, result, data}:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for index := range req.Documents {
+			select {
+			case jobs <- index:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Outputs are written in completion order; the report keeps request order
+	report := &BatchReport{Total: len(req.Documents), Documents: make([]BatchResult, len(req.Documents))}
+	archive := zip.NewWriter(w)
+	var writeErr error
+	for r := range done {
+		if r.result.Success && writeErr == nil {
+			if writeErr = writeBatchEntry(archive, r.result.File, r.data); writeErr != nil {
+				r.result.Success, r.result.File, r.result.Error = false, "", writeErr.Error()
+			}
+		}
+		if r.result.Success {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+		report.Documents[r.index] = r.result
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fail(fmt.Errorf("request cancelled: %w", err))
+	}
+	if writeErr != nil {
+		return fail(fmt.Errorf("write archive: %w", writeErr))
+	}
+
+	report.Success = report.Failed == 0
+	encoded, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fail(fmt.Errorf("encode report: %w", err))
+	}
+	if err := writeBatchEntry(archive, batchReportName, encoded); err != nil {
+		return fail(fmt.Errorf("write archive: %w", err))
+	}
+	if err := archive.Close(); err != nil {
+		return fail(fmt.Errorf("write archive: %w", err))
+	}
+	return report, nil
+}
+
+// writeBatchEntry adds a file to the batch ZIP. ODT files are already
+// compressed and are stored as they are.
+func writeBatchEntry(archive *zip.Writer, name string, data []byte) error {
+	method := zip.Store
+	if name == batchReportName {
+		method = zip.Deflate
+	}
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// renderBatchDocument fetches the images of doc and renders it from tmpl
+func renderBatchDocument(ctx context.Context, tmpl *Template, doc BatchDocument, strict bool, client HTTPClient) (BatchResult, []byte) {
+	result := BatchResult{Name: doc.Name, FailedTags: make(map[string]string)}
+	if len(doc.Data) == 0 {
+		result.Error = "no image data provided"
+		return result, nil
+	}
+
+	known := make(map[string]bool)
+	for _, tag := range tmpl.Tags() {
+		known[tag] = true
+	}
+
+	images := make(map[string][]byte, len(doc.Data))
+	var lastErr error
+	results := fetchImages(ctx, doc.Data, client, DefaultFetchOptions, nil)
+	tags := make([]string, 0, len(results))
+	for tag := range results {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	// Tags are checked in order so that the reported error is stable
+	for _, tag := range tags {
+		fetched := results[tag]
+		switch {
+		case fetched.err != nil:
+			lastErr = fmt.Errorf("get image for tag '%s': %w", tag, fetched.err)
+		case !known[tag]:
+			lastErr = fmt.Errorf("replace image for tag '%s': %w: name:%s", tag, ErrImageNotFound, tag)
+		default:
+			images[tag] = fetched.data
+			continue
+		}
+		result.FailedTags[tag] = lastErr.Error()
+	}
+	if len(result.FailedTags) == 0 {
+		result.FailedTags = nil
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		result.Error = fmt.Sprintf("request cancelled: %v", ctx.Err())
+		return result, nil
+	case strict && lastErr != nil:
+		result.Error = fmt.Sprintf("strict mode: %d of %d image(s) failed: %v", len(result.FailedTags), len(doc.Data), lastErr)
+		return result, nil
+	case len(images) == 0:
+		result.Error = fmt.Sprintf("failed to replace any images: %v", lastErr)
+		return result, nil
+	}
+
+	data, err := tmpl.Render(images)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to render: %v", err)
+		return result, nil
+	}
+
+	for _, tag := range tags {
+		if images[tag] != nil {
+			result.ReplacedTags = append(result.ReplacedTags, tag)
+		}
+	}
+	result.File, _ = batchFileName(doc.Name)
+	result.InvalidatedSignatures = tmpl.InvalidatedSignatures()
+	result.Success = true
+	return result, data
+}
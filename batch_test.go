@@ -0,0 +1,220 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func batchTestRequest(t *testing.T, names ...string) BatchRequest {
+	t.Helper()
+	req := BatchRequest{Template: TemplateSource{Base64: base64.StdEncoding.EncodeToString(templateTestData(t))}}
+	for _, name := range names {
+		req.Documents = append(req.Documents, BatchDocument{
+			Name: name,
+			Data: map[string]ImageSource{"image1": {Base64: base64.StdEncoding.EncodeToString([]byte(testPNG + name))}},
+		})
+	}
+	return req
+}
+
+// readBatch returns the entries of a batch ZIP and its report
+func readBatch(t *testing.T, data []byte) (map[string][]byte, BatchReport) {
+	t.Helper()
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("output is not a ZIP: %v", err)
+	}
+
+	entries := make(map[string][]byte)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+
+	var report BatchReport
+	if err := json.Unmarshal(entries[batchReportName], &report); err != nil {
+		t.Fatalf("report.json: %v", err)
+	}
+	return entries, report
+}
+
+func TestProcessBatchRequest(t *testing.T) {
+	req := batchTestRequest(t, "alice", "bob.odt", "carol")
+	// A document whose only tag is not in the template fails on its own
+	req.Documents = append(req.Documents, BatchDocument{
+		Name: "dave",
+		Data: map[string]ImageSource{"nosuchtag": {Base64: base64.StdEncoding.EncodeToString([]byte(testPNG))}},
+	})
+
+	var buf bytes.Buffer
+	report, err := ProcessBatchRequestContext(context.Background(), req, nil, &buf)
+	if err != nil {
+		t.Fatalf("ProcessBatchRequestContext() error = %v", err)
+	}
+	if report.Success || report.Total != 4 || report.Succeeded != 3 || report.Failed != 1 {
+		t.Errorf("report = %+v", report)
+	}
+
+	entries, zipped := readBatch(t, buf.Bytes())
+	if len(entries) != 4 {
+		t.Errorf("entries = %d, want 3 documents and the report", len(entries))
+	}
+	for i, name := range []string{"alice", "bob", "carol"} {
+		data, ok := entries[name+".odt"]
+		if !ok {
+			t.Fatalf("%s.odt missing", name)
+		}
+		doc, err := NewODTDocumentFromBytes(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image, err := doc.readFile("Pictures/image1.png"); err != nil || !strings.HasSuffix(string(image), req.Documents[i].Name) {
+			t.Errorf("%s.odt image = %q, %v", name, image, err)
+		}
+	}
+
+	// The report keeps request order
+	if zipped.Documents[3].Name != "dave" || zipped.Documents[3].Success || zipped.Documents[3].File != "" {
+		t.Errorf("report of failed document = %+v", zipped.Documents[3])
+	}
+	if zipped.Documents[1].File != "bob.odt" || zipped.Documents[1].ReplacedTags[0] != "image1" {
+		t.Errorf("report of bob = %+v", zipped.Documents[1])
+	}
+}
+
+func TestProcessBatchRequest_Invalid(t *testing.T) {
+	noTemplate := batchTestRequest(t, "a")
+	noTemplate.Template = TemplateSource{}
+
+	tests := []struct {
+		name    string
+		req     BatchRequest
+		wantErr error
+	}{
+		{"no documents", batchTestRequest(t), nil},
+		{"duplicate names", batchTestRequest(t, "a", "a.odt"), nil},
+		{"traversal name", batchTestRequest(t, "../a"), ErrInvalidPath},
+		{"no template", noTemplate, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			report, err := ProcessBatchRequestContext(context.Background(), tt.req, nil, &buf)
+			if err == nil || report.Success || report.Error == "" {
+				t.Fatalf("ProcessBatchRequestContext() = %+v, %v; want error", report, err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("%d bytes written before failing", buf.Len())
+			}
+		})
+	}
+}
+
+func TestProcessBatchRequest_TemplateOptions(t *testing.T) {
+	encode := func(data []byte) TemplateSource {
+		return TemplateSource{Base64: base64.StdEncoding.EncodeToString(data)}
+	}
+
+	t.Run("signed", func(t *testing.T) {
+		req := batchTestRequest(t, "a", "b")
+		req.Template = encode(signedTestData(t))
+		req.Signatures = SignatureStrip
+		req.Meta = &DocumentMeta{Title: "Batch"}
+
+		var buf bytes.Buffer
+		report, err := ProcessBatchRequestContext(context.Background(), req, nil, &buf)
+		if err != nil || !report.Success {
+			t.Fatalf("ProcessBatchRequestContext() = %+v, %v", report, err)
+		}
+		entries, _ := readBatch(t, buf.Bytes())
+		for _, result := range report.Documents {
+			if len(result.InvalidatedSignatures) != 1 {
+				t.Errorf("%s: InvalidatedSignatures = %+v", result.Name, result.InvalidatedSignatures)
+			}
+			doc, err := NewODTDocumentFromBytes(entries[result.File])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.pkg.exists(documentSignaturesPath) {
+				t.Errorf("%s: broken signature not stripped", result.File)
+			}
+			if meta, err := doc.Meta(); err != nil || meta.Title != "Batch" {
+				t.Errorf("%s: Meta() = %+v, %v", result.File, meta, err)
+			}
+		}
+
+		req.Signatures = SignatureRefuse
+		buf.Reset()
+		if _, err := ProcessBatchRequestContext(context.Background(), req, nil, &buf); !errors.Is(err, ErrSignedPart) || buf.Len() != 0 {
+			t.Errorf("refuse: error = %v, %d bytes written", err, buf.Len())
+		}
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		req := batchTestRequest(t, "a")
+		req.Template = encode(encryptedTestDoc(t, "secret"))
+		req.Template.Password = "secret"
+
+		var buf bytes.Buffer
+		report, err := ProcessBatchRequestContext(context.Background(), req, nil, &buf)
+		if err != nil || !report.Success {
+			t.Fatalf("ProcessBatchRequestContext() = %+v, %v", report, err)
+		}
+		entries, _ := readBatch(t, buf.Bytes())
+		if _, err := NewODTDocumentFromBytesWithPassword(entries["a.odt"], "secret"); err != nil {
+			t.Errorf("output does not open with the template password: %v", err)
+		}
+	})
+
+	t.Run("invalid thumbnail mode", func(t *testing.T) {
+		req := batchTestRequest(t, "a")
+		req.Thumbnail = "shrink"
+		if _, err := ProcessBatchRequestContext(context.Background(), req, nil, io.Discard); err == nil {
+			t.Error("ProcessBatchRequestContext() accepted an invalid thumbnail mode")
+		}
+	})
+}
+
+func TestHandleBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := SetupRouter()
+
+	serve := func(req BatchRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/batch", bytes.NewReader(body)))
+		return w
+	}
+
+	w := serve(batchTestRequest(t, "alice", "bob"))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("batch = %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	if _, report := readBatch(t, w.Body.Bytes()); !report.Success || report.Succeeded != 2 {
+		t.Errorf("report = %+v", report)
+	}
+
+	w = serve(batchTestRequest(t))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "no documents") {
+		t.Errorf("empty batch = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,487 @@
+package odtimagereplacer
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheOptions configures a Cache
+type CacheOptions struct {
+	// MaxMemory bounds the bytes of downloads kept in memory
+	MaxMemory int64
+
+	// Dir, if set, keeps downloads on disk as well, so that they survive
+	// restarts and entries evicted from memory can still be served
+	Dir string
+
+	// MaxDisk bounds the bytes of downloads kept in Dir
+	MaxDisk int64
+}
+
+// CacheStats reports the use of a Cache
+type CacheStats struct {
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Revalidated int64 `json:"revalidated"`
+	Entries     int   `json:"entries"`
+	MemoryBytes int64 `json:"memory_bytes"`
+	DiskBytes   int64 `json:"disk_bytes"`
+}
+
+// Cache stores downloaded templates and images by URL, following the
+// Cache-Control, Expires, ETag and Last-Modified headers of the responses.
+// Stale entries are revalidated with conditional requests. Entries are
+// also keyed by the request headers, so downloads made with credentials
+// are never served to requests without them. A Cache is safe for
+// concurrent use.
+type Cache struct {
+	opts CacheOptions
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	memory  *list.List // entries holding data, most recently used first
+	disk    *list.List // entries stored in Dir, most recently used first
+	stats   CacheStats
+}
+
+// cacheEntry is a cached download. The exported fields are stored next to
+// the body in Dir.
+type cacheEntry struct {
+	Key          string    `json:"key"`
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	Expires      time.Time `json:"expires"`
+	NoCache      bool      `json:"no_cache,omitempty"`
+	Size         int64     `json:"size"`
+
+	data []byte
+	mem  *list.Element
+	file *list.Element
+}
+
+// fresh reports whether the entry may be used without revalidation
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return !e.NoCache && now.Before(e.Expires)
+}
+
+// NewCache creates a cache, loading the entries already stored in
+// opts.Dir
+func NewCache(opts CacheOptions) (*Cache, error) {
+	c := &Cache{
+		opts:    opts,
+		entries: make(map[string]*cacheEntry),
+		memory:  list.New(),
+		disk:    list.New(),
+	}
+
+	if opts.Dir != "" {
+		if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+			return nil, fmt.Errorf("create cache directory: %w", err)
+		}
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// load indexes the entries stored in Dir, most recently stored first
+func (c *Cache) load() error {
+	// Temporary files of interrupted writes are never complete
+	if tmps, err := filepath.Glob(filepath.Join(c.opts.Dir, ".tmp-*")); err == nil {
+		for _, tmp := range tmps {
+			os.Remove(tmp)
+		}
+	}
+
+	metas, err := filepath.Glob(filepath.Join(c.opts.Dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("list cache directory: %w", err)
+	}
+
+	type stored struct {
+		entry   *cacheEntry
+		modTime time.Time
+	}
+	var found []stored
+	for _, meta := range metas {
+		data, err := os.ReadFile(meta)
+		if err != nil {
+			continue
+		}
+		var entry cacheEntry
+		info, statErr := os.Stat(c.bodyPath(strings.TrimSuffix(filepath.Base(meta), ".json")))
+		if json.Unmarshal(data, &entry) != nil || statErr != nil || info.Size() != entry.Size ||
+			filepath.Base(meta) != entry.Key+".json" {
+			// Incomplete or foreign entries are discarded
+			c.removeFiles(strings.TrimSuffix(filepath.Base(meta), ".json"))
+			continue
+		}
+		found = append(found, stored{&entry, info.ModTime()})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].modTime.After(found[j].modTime) })
+
+	for _, s := range found {
+		s.entry.file = c.disk.PushBack(s.entry)
+		c.entries[s.entry.Key] = s.entry
+		c.stats.DiskBytes += s.entry.Size
+	}
+	c.evict()
+	return nil
+}
+
+// cacheKey identifies a download by URL and request headers. Header values
+// only enter the key as part of a hash.
+func cacheKey(url string, header http.Header) string {
+	h := sha256.New()
+	h.Write([]byte(url))
+
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(h, "\n%s: %s", key, strings.Join(header[key], ", "))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get returns a copy of the entry for key and its data
+func (c *Cache) get(key string) (*cacheEntry, []byte, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, nil, false
+	}
+	meta := *entry
+	data := entry.data
+	if entry.file != nil {
+		c.disk.MoveToFront(entry.file)
+	}
+	if entry.mem != nil {
+		c.memory.MoveToFront(entry.mem)
+	}
+	c.mu.Unlock()
+
+	if data == nil {
+		var err error
+		data, err = os.ReadFile(c.bodyPath(key))
+		if err != nil || int64(len(data)) != meta.Size {
+			c.remove(key)
+			return nil, nil, false
+		}
+
+		// Entries read from disk are kept in memory again
+		c.mu.Lock()
+		if current := c.entries[key]; current == entry && entry.data == nil {
+			c.keepInMemory(entry, data)
+			c.evict()
+		}
+		c.mu.Unlock()
+	}
+
+	return &meta, data, true
+}
+
+// store caches a response if its headers permit
+func (c *Cache) store(key, url string, data []byte, header http.Header) {
+	entry, ok := newCacheEntry(key, url, header, time.Now())
+	if !ok {
+		c.remove(key)
+		return
+	}
+	entry.Size = int64(len(data))
+
+	onDisk := c.opts.Dir != "" && entry.Size <= c.opts.MaxDisk && c.writeFiles(entry, data) == nil
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeLocked(key, onDisk)
+	if onDisk {
+		entry.file = c.disk.PushFront(entry)
+		c.stats.DiskBytes += entry.Size
+	}
+	c.keepInMemory(entry, data)
+	if entry.mem != nil || entry.file != nil {
+		c.entries[key] = entry
+	}
+	c.evict()
+}
+
+// refresh updates an entry after a 304 Not Modified response
+func (c *Cache) refresh(key string, header http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	updated, ok := newCacheEntry(key, entry.URL, mergeValidators(header, entry), time.Now())
+	if !ok {
+		c.removeLocked(key, false)
+		return
+	}
+	entry.ETag, entry.LastModified = updated.ETag, updated.LastModified
+	entry.Expires, entry.NoCache = updated.Expires, updated.NoCache
+	c.stats.Revalidated++
+
+	if entry.file != nil {
+		if meta, err := json.Marshal(entry); err == nil {
+			writeCacheFile(c.metaPath(key), meta)
+		}
+	}
+}
+
+// mergeValidators completes the headers of a 304 response with the
+// validators of the cached entry, since servers may omit them
+func mergeValidators(header http.Header, entry *cacheEntry) http.Header {
+	merged := header.Clone()
+	if merged == nil {
+		merged = make(http.Header)
+	}
+	if merged.Get("ETag") == "" && entry.ETag != "" {
+		merged.Set("ETag", entry.ETag)
+	}
+	if merged.Get("Last-Modified") == "" && entry.LastModified != "" {
+		merged.Set("Last-Modified", entry.LastModified)
+	}
+	return merged
+}
+
+// newCacheEntry reads the caching headers of a response. It reports false
+// for responses that must not be stored: no-store, or neither a freshness
+// lifetime nor a validator to revalidate with.
+func newCacheEntry(key, url string, header http.Header, now time.Time) (*cacheEntry, bool) {
+	entry := &cacheEntry{
+		Key:          key,
+		URL:          url,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		ContentType:  header.Get("Content-Type"),
+	}
+
+	maxAge, sharedMaxAge := -1, -1
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store":
+			return nil, false
+		case "no-cache":
+			entry.NoCache = true
+		case "max-age":
+			if n, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				maxAge = n
+			}
+		case "s-maxage":
+			if n, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				sharedMaxAge = n
+			}
+		}
+	}
+
+	switch {
+	case sharedMaxAge >= 0:
+		entry.Expires = now.Add(time.Duration(sharedMaxAge) * time.Second)
+	case maxAge >= 0:
+		entry.Expires = now.Add(time.Duration(maxAge) * time.Second)
+	default:
+		if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+			entry.Expires = expires
+		}
+	}
+
+	if !entry.fresh(now) && entry.ETag == "" && entry.LastModified == "" {
+		return nil, false
+	}
+	return entry, true
+}
+
+// conditionalHeader returns the request headers for revalidating entry
+func conditionalHeader(header http.Header, entry *cacheEntry) http.Header {
+	conditional := header.Clone()
+	if conditional == nil {
+		conditional = make(http.Header)
+	}
+	if entry.ETag != "" {
+		conditional.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		conditional.Set("If-Modified-Since", entry.LastModified)
+	}
+	return conditional
+}
+
+// keepInMemory adds data to the memory tier if it fits
+func (c *Cache) keepInMemory(entry *cacheEntry, data []byte) {
+	if int64(len(data)) > c.opts.MaxMemory {
+		return
+	}
+	entry.data = data
+	entry.mem = c.memory.PushFront(entry)
+	c.stats.MemoryBytes += entry.Size
+}
+
+// evict drops the least recently used entries until both tiers fit
+func (c *Cache) evict() {
+	for c.stats.MemoryBytes > c.opts.MaxMemory {
+		entry := c.memory.Back().Value.(*cacheEntry)
+		c.memory.Remove(entry.mem)
+		entry.mem, entry.data = nil, nil
+		c.stats.MemoryBytes -= entry.Size
+		if entry.file == nil {
+			delete(c.entries, entry.Key)
+		}
+	}
+	for c.stats.DiskBytes > c.opts.MaxDisk {
+		entry := c.disk.Back().Value.(*cacheEntry)
+		c.disk.Remove(entry.file)
+		entry.file = nil
+		c.stats.DiskBytes -= entry.Size
+		c.removeFiles(entry.Key)
+		if entry.mem == nil {
+			delete(c.entries, entry.Key)
+		}
+	}
+}
+
+// remove drops an entry and its files
+func (c *Cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key, false)
+}
+
+// removeLocked drops an entry; keepFiles leaves freshly written files of
+// a replacement in place
+func (c *Cache) removeLocked(key string, keepFiles bool) {
+	entry, ok := c.entries[key]
+	if !ok {
+		if !keepFiles && c.opts.Dir != "" {
+			c.removeFiles(key)
+		}
+		return
+	}
+	delete(c.entries, key)
+	if entry.mem != nil {
+		c.memory.Remove(entry.mem)
+		c.stats.MemoryBytes -= entry.Size
+	}
+	if entry.file != nil {
+		c.disk.Remove(entry.file)
+		c.stats.DiskBytes -= entry.Size
+		if !keepFiles {
+			c.removeFiles(key)
+		}
+	}
+}
+
+// Purge removes every entry downloaded from url and returns their number
+func (c *Cache) Purge(url string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for key, entry := range c.entries {
+		if entry.URL == url {
+			c.removeLocked(key, false)
+			purged++
+		}
+	}
+	return purged
+}
+
+// PurgeAll removes every entry and returns their number
+func (c *Cache) PurgeAll() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := len(c.entries)
+	for key := range c.entries {
+		c.removeLocked(key, false)
+	}
+	return purged
+}
+
+// Stats returns the cache's counters and current size
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+// count records a hit or a miss
+func (c *Cache) count(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+}
+
+func (c *Cache) bodyPath(key string) string {
+	return filepath.Join(c.opts.Dir, key+".body")
+}
+
+func (c *Cache) metaPath(key string) string {
+	return filepath.Join(c.opts.Dir, key+".json")
+}
+
+// writeFiles stores an entry's body, then its metadata, so that loading
+// never finds metadata without a complete body
+func (c *Cache) writeFiles(entry *cacheEntry, data []byte) error {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := writeCacheFile(c.bodyPath(entry.Key), data); err != nil {
+		return err
+	}
+	return writeCacheFile(c.metaPath(entry.Key), meta)
+}
+
+func (c *Cache) removeFiles(key string) {
+	os.Remove(c.metaPath(key))
+	os.Remove(c.bodyPath(key))
+}
+
+// writeCacheFile replaces path atomically
+func writeCacheFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,187 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFetchRemote_Cache(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		etag         string
+		wantRequests int64
+		wantStats    CacheStats
+	}{
+		{"fresh entries are served", "max-age=60", "", 1, CacheStats{Hits: 2, Misses: 1, Entries: 1}},
+		{"stale entries are revalidated", "no-cache", `"v1"`, 3, CacheStats{Misses: 1, Revalidated: 2, Entries: 1}},
+		{"no-store is not cached", "no-store", `"v1"`, 3, CacheStats{Misses: 3}},
+		{"responses without freshness or validators are not cached", "", "", 3, CacheStats{Misses: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if tt.etag != "" {
+					w.Header().Set("ETag", tt.etag)
+					if r.Header.Get("If-None-Match") == tt.etag {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
+				if tt.cacheControl != "" {
+					w.Header().Set("Cache-Control", tt.cacheControl)
+				}
+				w.Header().Set("Content-Type", "image/png")
+				w.Write([]byte(testPNG))
+			}))
+			defer server.Close()
+
+			cache, err := NewCache(CacheOptions{MaxMemory: 1 << 20})
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := FetchOptions{Cache: cache}
+
+			for i := 0; i < 3; i++ {
+				data, err := fetchRemote(context.Background(), server.URL, nil, server.Client(), imageContent, opts)
+				if err != nil || string(data) != testPNG {
+					t.Fatalf("fetchRemote() #%d = %q, %v", i, data, err)
+				}
+			}
+
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("server received %d requests, want %d", got, tt.wantRequests)
+			}
+			stats := cache.Stats()
+			stats.MemoryBytes, stats.DiskBytes = 0, 0
+			if stats != tt.wantStats {
+				t.Errorf("Stats() = %+v, want %+v", stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestCache_KeyedByHeaders(t *testing.T) {
+	cache, err := NewCache(CacheOptions{MaxMemory: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Cache-Control": {"max-age=60"}}
+	authorized := http.Header{"Authorization": {"Bearer secret"}}
+
+	cache.store(cacheKey("https://a.test/x.png", authorized), "https://a.test/x.png", []byte(testPNG), header)
+	if _, _, ok := cache.get(cacheKey("https://a.test/x.png", nil)); ok {
+		t.Error("download made with credentials served without them")
+	}
+	if _, _, ok := cache.get(cacheKey("https://a.test/x.png", authorized)); !ok {
+		t.Error("download not served with the same credentials")
+	}
+}
+
+func TestCache_Eviction(t *testing.T) {
+	cache, err := NewCache(CacheOptions{MaxMemory: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Cache-Control": {"max-age=60"}}
+	body := make([]byte, 16)
+
+	cache.store("a", "https://a.test/a", body, header)
+	cache.store("b", "https://a.test/b", body, header)
+	cache.get("a") // a is now the most recently used
+	cache.store("c", "https://a.test/c", body, header)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, _, ok := cache.get(key); ok != want {
+			t.Errorf("entry %s cached = %v, want %v", key, ok, want)
+		}
+	}
+	if stats := cache.Stats(); stats.MemoryBytes != 32 || stats.Entries != 2 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestCache_Disk(t *testing.T) {
+	dir := t.TempDir()
+	opts := CacheOptions{MaxMemory: 1 << 20, Dir: dir, MaxDisk: 1 << 20}
+	header := http.Header{"Cache-Control": {"max-age=60"}, "Content-Type": {"image/png"}}
+
+	cache, err := NewCache(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.store("a", "https://a.test/a.png", []byte(testPNG), header)
+	cache.store("b", "https://a.test/b.png", []byte(testPNG), header)
+
+	// A new cache on the same directory serves the stored downloads
+	reloaded, err := NewCache(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, data, ok := reloaded.get("a")
+	if !ok || string(data) != testPNG || entry.ContentType != "image/png" || entry.URL != "https://a.test/a.png" {
+		t.Fatalf("get() after reload = %+v, %q, %v", entry, data, ok)
+	}
+
+	if n := reloaded.Purge("https://a.test/a.png"); n != 1 {
+		t.Errorf("Purge() = %d, want 1", n)
+	}
+	if n := reloaded.PurgeAll(); n != 1 {
+		t.Errorf("PurgeAll() = %d, want 1", n)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("cache directory holds %d files after PurgeAll()", len(files))
+	}
+}
+
+func TestHandlePurgeCache(t *testing.T) {
+	cache, err := NewCache(CacheOptions{MaxMemory: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.store("a", "https://a.test/a.png", []byte(testPNG), http.Header{"Cache-Control": {"max-age=60"}})
+
+	defer func(opts FetchOptions, token string) { DefaultFetchOptions, AdminToken = opts, token }(DefaultFetchOptions, AdminToken)
+	DefaultFetchOptions.Cache = cache
+	AdminToken = "admin-secret"
+	gin.SetMode(gin.TestMode)
+	router := SetupRouter()
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+		wantPurged bool
+	}{
+		{"without token", "", http.StatusUnauthorized, false},
+		{"wrong token", "guess", http.StatusUnauthorized, false},
+		{"admin token", "admin-secret", http.StatusOK, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/admin/cache?url=https://a.test/a.png", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if purged := cache.Stats().Entries == 0; purged != tt.wantPurged {
+				t.Errorf("entry purged = %v, want %v", purged, tt.wantPurged)
+			}
+		})
+	}
+}
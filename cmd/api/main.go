@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/suttapak/odtimagereplacer"
@@ -15,8 +17,140 @@ func main() {
 	port := flag.String("port", "8080", "Server port")
 	host := flag.String("host", "0.0.0.0", "Server host")
 	mode := flag.String("mode", "release", "Gin mode: debug, release, or test")
+	soffice := flag.String("soffice", "", "Path to LibreOffice soffice for rendering thumbnails (optional)")
+	fetchWorkers := flag.Int("fetch-workers", odtimagereplacer.DefaultFetchOptions.Workers, "Maximum concurrent image downloads per request")
+	fetchPerHost := flag.Int("fetch-per-host", odtimagereplacer.DefaultFetchOptions.PerHost, "Maximum concurrent downloads from one host per request")
+	fetchTimeout := flag.Duration("fetch-timeout", odtimagereplacer.DefaultFetchOptions.Timeout, "Time limit for downloading all images of a request (0 for none)")
+	fetchRetries := flag.Int("fetch-retries", odtimagereplacer.DefaultFetchOptions.Retries, "Retries of a download after a network error or a 408, 429 or 5xx response")
+	fetchBackoff := flag.Duration("fetch-backoff", odtimagereplacer.DefaultFetchOptions.Backoff, "Delay before the first retry; doubles for each further retry")
+	fetchMaxBackoff := flag.Duration("fetch-max-backoff", odtimagereplacer.DefaultFetchOptions.MaxBackoff, "Longest delay between retries, including Retry-After")
+	cacheMemory := flag.Int64("cache-memory", 0, "Bytes of downloaded templates and images cached in memory (0 disables the cache)")
+	cacheDir := flag.String("cache-dir", "", "Directory that also caches downloads on disk")
+	cacheDisk := flag.Int64("cache-disk", 1<<30, "Bytes of downloads cached in -cache-dir")
+	adminToken := flag.String("admin-token", os.Getenv("ODT_ADMIN_TOKEN"), "Bearer token enabling the /admin endpoints (default $ODT_ADMIN_TOKEN)")
+	fileRoot := flag.String("file-root", "", "Directory image sources may read with \"file:<path>\" (disabled if empty)")
+	assetDir := flag.String("asset-dir", "", "Directory of the asset library referenced with \"asset:<id>\" (enables /api/assets)")
+	templateDir := flag.String("template-dir", "", "Directory of the template registry (enables /api/templates)")
+	templateMemory := flag.Bool("template-memory", false, "Keep the template registry in memory instead of -template-dir")
+	batchWorkers := flag.Int("batch-workers", odtimagereplacer.DefaultBatchOptions.Workers, "Documents of a batch rendered at the same time")
+	batchMax := flag.Int("batch-max", odtimagereplacer.DefaultBatchOptions.MaxDocuments, "Maximum documents in one batch request")
+	jobDir := flag.String("job-dir", "", "Directory of the job store; enables /api/jobs and resumes queued jobs after a restart")
+	jobMemory := flag.Bool("job-memory", false, "Keep jobs in memory instead of -job-dir")
+	jobWorkers := flag.Int("job-workers", odtimagereplacer.DefaultJobOptions.Workers, "Jobs processed at the same time")
+	jobQueue := flag.Int("job-queue", odtimagereplacer.DefaultJobOptions.QueueSize, "Maximum jobs waiting for a worker")
+	jobTimeout := flag.Duration("job-timeout", odtimagereplacer.DefaultJobOptions.Timeout, "Time limit for processing one job (0 for none)")
+	jobRetention := flag.Duration("job-retention", odtimagereplacer.DefaultJobOptions.Retention, "How long finished jobs and their outputs are kept (0 for ever)")
+	webhookSecret := flag.String("webhook-secret", os.Getenv("ODT_WEBHOOK_SECRET"), "Secret signing job webhooks with HMAC-SHA256 (default $ODT_WEBHOOK_SECRET)")
+	allowSchemes := flag.String("allow-schemes", "http,https", "Comma-separated URL schemes templates and images may be downloaded with")
+	allowHosts := flag.String("allow-hosts", "", "Comma-separated hosts downloads are restricted to; *.example.com matches subdomains (default: any)")
+	denyHosts := flag.String("deny-hosts", "", "Comma-separated hosts downloads may never use")
+	allowPrivate := flag.Bool("allow-private", false, "Allow downloads from loopback, private and link-local addresses")
+	credentials := flag.String("credentials", "", "JSON file of credentials for authenticated template and image hosts")
+	maxRedirects := flag.Int("max-redirects", odtimagereplacer.DefaultURLPolicy.MaxRedirects, "Maximum redirects followed per download")
 	flag.Parse()
 
+	odtimagereplacer.DefaultFetchOptions = odtimagereplacer.FetchOptions{
+		Workers:    *fetchWorkers,
+		PerHost:    *fetchPerHost,
+		Timeout:    *fetchTimeout,
+		Retries:    *fetchRetries,
+		Backoff:    *fetchBackoff,
+		MaxBackoff: *fetchMaxBackoff,
+	}
+
+	// Cache downloads when a memory or disk budget is given
+	if *cacheMemory > 0 || *cacheDir != "" {
+		cache, err := odtimagereplacer.NewCache(odtimagereplacer.CacheOptions{
+			MaxMemory: *cacheMemory,
+			Dir:       *cacheDir,
+			MaxDisk:   *cacheDisk,
+		})
+		if err != nil {
+			log.Fatalf("Failed to open download cache: %v", err)
+		}
+		odtimagereplacer.DefaultFetchOptions.Cache = cache
+	}
+	odtimagereplacer.AdminToken = *adminToken
+	odtimagereplacer.DefaultBatchOptions = odtimagereplacer.BatchOptions{
+		Workers:      *batchWorkers,
+		MaxDocuments: *batchMax,
+	}
+
+	// Template registry, when a store is given
+	switch {
+	case *templateMemory:
+		odtimagereplacer.DefaultTemplates = odtimagereplacer.NewTemplateRegistry(odtimagereplacer.NewMemoryStorage())
+	case *templateDir != "":
+		storage, err := odtimagereplacer.NewFileStorage(*templateDir)
+		if err != nil {
+			log.Fatalf("Failed to open template registry: %v", err)
+		}
+		odtimagereplacer.DefaultTemplates = odtimagereplacer.NewTemplateRegistry(storage)
+	}
+
+	// Local image sources are only available when configured
+	if *fileRoot != "" {
+		odtimagereplacer.RegisterResolver("file", odtimagereplacer.FileResolver{Root: *fileRoot})
+	}
+	if *assetDir != "" {
+		storage, err := odtimagereplacer.NewFileStorage(*assetDir)
+		if err != nil {
+			log.Fatalf("Failed to open asset library: %v", err)
+		}
+		odtimagereplacer.DefaultAssets = odtimagereplacer.NewAssetLibrary(storage)
+		odtimagereplacer.RegisterResolver("asset", odtimagereplacer.AssetResolver{Store: odtimagereplacer.DefaultAssets})
+	}
+
+	// Restrict where templates and images may be downloaded from
+	odtimagereplacer.DefaultURLPolicy = odtimagereplacer.URLPolicy{
+		AllowedSchemes: splitList(*allowSchemes),
+		AllowedHosts:   splitList(*allowHosts),
+		DeniedHosts:    splitList(*denyHosts),
+		AllowPrivate:   *allowPrivate,
+		MaxRedirects:   *maxRedirects,
+	}
+	odtimagereplacer.DefaultHTTPClient = odtimagereplacer.NewHTTPClient(odtimagereplacer.DefaultURLPolicy, 30*time.Second)
+
+	// Job queue, when a store is given; it uses the client configured above
+	var jobStorage odtimagereplacer.Storage
+	switch {
+	case *jobMemory:
+		jobStorage = odtimagereplacer.NewMemoryStorage()
+	case *jobDir != "":
+		storage, err := odtimagereplacer.NewFileStorage(*jobDir)
+		if err != nil {
+			log.Fatalf("Failed to open job store: %v", err)
+		}
+		jobStorage = storage
+	}
+	if jobStorage != nil {
+		opts := odtimagereplacer.DefaultJobOptions
+		opts.Workers = *jobWorkers
+		opts.QueueSize = *jobQueue
+		opts.Timeout = *jobTimeout
+		opts.Retention = *jobRetention
+		opts.WebhookSecret = *webhookSecret
+		queue, err := odtimagereplacer.NewJobQueue(jobStorage, opts)
+		if err != nil {
+			log.Fatalf("Failed to start job queue: %v", err)
+		}
+		odtimagereplacer.DefaultJobs = queue
+	}
+
+	// Credentials are loaded once; only their names and hosts are printed
+	if *credentials != "" {
+		creds, err := odtimagereplacer.LoadCredentials(*credentials)
+		if err != nil {
+			log.Fatalf("Failed to load credentials: %v", err)
+		}
+		odtimagereplacer.DefaultCredentials = creds
+	}
+
+	// Render thumbnails with LibreOffice when available
+	if *soffice != "" {
+		odtimagereplacer.DefaultConverter = &odtimagereplacer.LibreOfficeConverter{Binary: *soffice}
+	}
+
 	// Set Gin mode
 	switch *mode {
 	case "debug":
@@ -39,9 +173,31 @@ func main() {
 	fmt.Println("╚══════════════════════════════════════════════════════════╝")
 	fmt.Printf("  Mode:    %s\n", *mode)
 	fmt.Printf("  Address: http://%s\n", addr)
+	for _, cred := range odtimagereplacer.DefaultCredentials {
+		fmt.Printf("  Auth:    %s\n", cred)
+	}
 	fmt.Println("\n  Endpoints:")
 	fmt.Println("    POST /api/replace          - Replace images (JSON response)")
 	fmt.Println("    POST /api/replace/download - Replace images (file download)")
+	fmt.Println("    POST /api/extract          - Extract text or Markdown")
+	fmt.Println("    POST /api/validate         - Validate an ODT package")
+	fmt.Println("    POST /api/batch            - Render many documents as a ZIP")
+	if odtimagereplacer.DefaultTemplates != nil {
+		fmt.Println("    GET  /api/templates        - List registered templates")
+		fmt.Println("    POST /api/templates/:id    - Upload a template version")
+	}
+	if odtimagereplacer.DefaultAssets != nil {
+		fmt.Println("    GET  /api/assets           - List image assets")
+		fmt.Println("    POST /api/assets/:id       - Upload an image asset")
+	}
+	if odtimagereplacer.DefaultJobs != nil {
+		fmt.Println("    POST /api/jobs             - Queue a replace request")
+		fmt.Println("    GET  /api/jobs/:id         - Job status and result")
+	}
+	if *adminToken != "" {
+		fmt.Println("    GET  /admin/cache          - Download cache statistics")
+		fmt.Println("    DELETE /admin/cache        - Purge the download cache")
+	}
 	fmt.Println("    GET  /health               - Health check")
 	fmt.Println("    GET  /info                 - Service information")
 	fmt.Println("\n  Example request:")
@@ -56,3 +212,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,15 +1,30 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 
 	"github.com/suttapak/odtimagereplacer"
 )
 
 func main() {
+	// Subcommands take precedence over the flag-based interface
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			runValidate(os.Args[2:])
+			return
+		case "repair":
+			runRepair(os.Args[2:])
+			return
+		}
+	}
+
 	// Define command-line flags
 	odtPath := flag.String("odt", "", "Path to ODT file")
 	imageTag := flag.String("tag", "", "Image tag (draw:name) to replace")
@@ -17,6 +32,11 @@ func main() {
 	newImageName := flag.String("name", "", "New image name in ODT (e.g., Pictures/image1.png)")
 	output := flag.String("output", "", "Output ODT file path (defaults to overwriting input)")
 	listTags := flag.Bool("list", false, "List all image tags in the ODT")
+	extractText := flag.Bool("text", false, "Print the document text as plain text")
+	extractMarkdown := flag.Bool("markdown", false, "Print the document text as Markdown")
+	password := flag.String("password", os.Getenv("ODT_PASSWORD"), "Password for encrypted documents (defaults to $ODT_PASSWORD)")
+	signatures := flag.String("signatures", "warn", "Handling of signed documents: warn, refuse or strip")
+	backup := flag.Bool("backup", false, "Keep the previous output file as <output>.bak")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
@@ -25,8 +45,16 @@ func main() {
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  # List all image tags in an ODT:\n")
 		fmt.Fprintf(os.Stderr, "  %s -odt=report.odt -list\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Extract the document text as Markdown:\n")
+		fmt.Fprintf(os.Stderr, "  %s -odt=report.odt -markdown\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  # Replace an image by tag:\n")
 		fmt.Fprintf(os.Stderr, "  %s -odt=report.odt -tag=image1 -image=photo.png -name=Pictures/photo.png -output=result.odt\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Replace an image in a password-protected document:\n")
+		fmt.Fprintf(os.Stderr, "  ODT_PASSWORD=secret %s -odt=report.odt -tag=image1 -image=photo.png -name=Pictures/photo.png\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Validate the package structure:\n")
+		fmt.Fprintf(os.Stderr, "  %s validate [-json] report.odt\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Add missing manifest entries and fix media types:\n")
+		fmt.Fprintf(os.Stderr, "  %s repair [-output=fixed.odt] report.odt\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  # Run legacy test (for backward compatibility):\n")
 		fmt.Fprintf(os.Stderr, "  %s (no flags - runs legacy Test function)\n\n", os.Args[0])
 	}
@@ -47,8 +75,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Determine output path
+	outputPath := *output
+	if outputPath == "" {
+		outputPath = *odtPath
+	}
+
+	// Keep other runs from modifying the files until we are done
+	unlock := lockFiles(*odtPath, outputPath)
+	defer unlock()
+
 	// Open ODT document
-	doc, err := odtimagereplacer.NewODTDocument(*odtPath)
+	doc, err := odtimagereplacer.NewODTDocumentWithPassword(*odtPath, *password)
 	if err != nil {
 		log.Fatalf("Error opening ODT: %v", err)
 	}
@@ -67,6 +105,22 @@ func main() {
 		return
 	}
 
+	// Text extraction mode
+	if *extractText || *extractMarkdown {
+		var text string
+		if *extractMarkdown {
+			text, err = doc.ExtractMarkdown()
+		} else {
+			text, err = doc.ExtractText()
+		}
+		if err != nil {
+			log.Fatalf("Error extracting text: %v", err)
+		}
+
+		fmt.Println(text)
+		return
+	}
+
 	// Replace image mode
 	if *imageTag == "" || *imagePath == "" || *newImageName == "" {
 		fmt.Fprintf(os.Stderr, "Error: -tag, -image, and -name flags are required for image replacement\n\n")
@@ -81,20 +135,152 @@ func main() {
 	}
 
 	// Replace image
+	policy := odtimagereplacer.SignaturePolicy(*signatures)
+	switch policy {
+	case odtimagereplacer.SignatureWarn, odtimagereplacer.SignatureRefuse, odtimagereplacer.SignatureStrip:
+	default:
+		log.Fatalf("Invalid -signatures value %q (use warn, refuse or strip)", *signatures)
+	}
+	doc.SetSignaturePolicy(policy)
 	if err := doc.ReplaceImageByTag(*imageTag, *newImageName, imageData); err != nil {
 		log.Fatalf("Error replacing image: %v", err)
 	}
 
+	// Save document
+	if err := doc.SaveWithOptions(outputPath, odtimagereplacer.SaveOptions{Backup: *backup}); err != nil {
+		log.Fatalf("Error saving ODT: %v", err)
+	}
+
+	for _, s := range doc.InvalidatedSignatures() {
+		if policy == odtimagereplacer.SignatureStrip {
+			fmt.Fprintf(os.Stderr, "Removed signature by %q from %s\n", s.Signer, s.File)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: signature by %q in %s is no longer valid\n", s.Signer, s.File)
+		}
+	}
+
+	fmt.Printf("Successfully replaced image '%s' in %s\n", *imageTag, outputPath)
+}
+
+// runValidate implements the validate subcommand
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print findings as JSON")
+	password := fs.String("password", os.Getenv("ODT_PASSWORD"), "Password for encrypted documents (defaults to $ODT_PASSWORD)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s validate [-json] <file.odt>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	odtPath := fs.Arg(0)
+
+	doc, err := odtimagereplacer.NewODTDocumentWithPassword(odtPath, *password)
+	if err != nil {
+		log.Fatalf("Error opening ODT: %v", err)
+	}
+
+	report := doc.Validate()
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalf("Error encoding report: %v", err)
+		}
+	} else {
+		for _, f := range report.Findings {
+			fmt.Printf("%-7s %-22s %s: %s\n", f.Severity, f.Code, f.Part, f.Message)
+		}
+		if report.Valid {
+			fmt.Printf("%s: valid (%d finding(s))\n", odtPath, len(report.Findings))
+		} else {
+			fmt.Printf("%s: invalid (%d finding(s))\n", odtPath, len(report.Findings))
+		}
+	}
+
+	if !report.Valid {
+		os.Exit(1)
+	}
+}
+
+// runRepair implements the repair subcommand
+func runRepair(args []string) {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	output := fs.String("output", "", "Output ODT file path (defaults to overwriting input)")
+	password := fs.String("password", os.Getenv("ODT_PASSWORD"), "Password for encrypted documents (defaults to $ODT_PASSWORD)")
+	backup := fs.Bool("backup", false, "Keep the previous output file as <output>.bak")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s repair [-output=file.odt] [-backup] <file.odt>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	odtPath := fs.Arg(0)
+
 	// Determine output path
 	outputPath := *output
 	if outputPath == "" {
-		outputPath = *odtPath
+		outputPath = odtPath
 	}
 
-	// Save document
-	if err := doc.Save(outputPath); err != nil {
+	unlock := lockFiles(odtPath, outputPath)
+	defer unlock()
+
+	doc, err := odtimagereplacer.NewODTDocumentWithPassword(odtPath, *password)
+	if err != nil {
+		log.Fatalf("Error opening ODT: %v", err)
+	}
+
+	changes, err := doc.RepairManifest()
+	if err != nil {
+		log.Fatalf("Error repairing manifest: %v", err)
+	}
+
+	for _, change := range changes {
+		fmt.Printf("  %-10s %s %s\n", change.Action, change.Path, change.MediaType)
+	}
+
+	if err := doc.SaveWithOptions(outputPath, odtimagereplacer.SaveOptions{Backup: *backup}); err != nil {
 		log.Fatalf("Error saving ODT: %v", err)
 	}
 
-	fmt.Printf("Successfully replaced image '%s' in %s\n", *imageTag, outputPath)
+	fmt.Printf("Made %d manifest change(s) in %s\n", len(changes), outputPath)
+}
+
+// lockFiles takes advisory locks on the input and output files so that
+// concurrent runs cannot interleave their reads and writes. Locks are
+// taken in sorted order so runs with swapped paths cannot deadlock.
+func lockFiles(paths ...string) func() {
+	for i, path := range paths {
+		paths[i] = filepath.Clean(path)
+	}
+	sort.Strings(paths)
+
+	var locks []*odtimagereplacer.FileLock
+	for i, path := range paths {
+		if i > 0 && path == paths[i-1] {
+			continue
+		}
+
+		lock, err := odtimagereplacer.LockFile(path)
+		if err != nil {
+			log.Fatalf("Error locking %s: %v", path, err)
+		}
+		locks = append(locks, lock)
+	}
+
+	return func() {
+		for _, lock := range locks {
+			lock.Unlock()
+		}
+	}
 }
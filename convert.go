@@ -0,0 +1,89 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Converter converts an ODT package to another format, such as "png" for
+// a rendering of the first page or "pdf"
+type Converter interface {
+	Convert(odt []byte, format string) ([]byte, error)
+}
+
+// ContextConverter is a Converter that can be cancelled. Converters that
+// do not implement it run to completion even if the request is cancelled.
+type ContextConverter interface {
+	Converter
+	ConvertContext(ctx context.Context, odt []byte, format string) ([]byte, error)
+}
+
+// convertContext converts with c, passing ctx if c supports it
+func convertContext(ctx context.Context, c Converter, odt []byte, format string) ([]byte, error) {
+	if cc, ok := c.(ContextConverter); ok {
+		return cc.ConvertContext(ctx, odt, format)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Convert(odt, format)
+}
+
+// LibreOfficeConverter converts documents by running LibreOffice headless
+type LibreOfficeConverter struct {
+	// Binary is the soffice executable; defaults to "soffice"
+	Binary string
+
+	// Timeout limits a single conversion; defaults to one minute
+	Timeout time.Duration
+}
+
+// Convert runs soffice --convert-to on a temporary copy of the document
+func (c *LibreOfficeConverter) Convert(odt []byte, format string) ([]byte, error) {
+	return c.ConvertContext(context.Background(), odt, format)
+}
+
+// ConvertContext is Convert with cancellation; soffice is killed when ctx
+// is done
+func (c *LibreOfficeConverter) ConvertContext(ctx context.Context, odt []byte, format string) ([]byte, error) {
+	binary := c.Binary
+	if binary == "" {
+		binary = "soffice"
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+
+	dir, err := os.MkdirTemp("", "odt-convert-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "document.odt")
+	if err := os.WriteFile(input, odt, 0600); err != nil {
+		return nil, fmt.Errorf("write temp file: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// A private profile directory lets conversions run concurrently
+	cmd := exec.CommandContext(ctx, binary,
+		"-env:UserInstallation=file://"+filepath.ToSlash(filepath.Join(dir, "profile")),
+		"--headless", "--convert-to", format, "--outdir", dir, input)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("run %s: %w: %s", binary, err, output)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "document."+format))
+	if err != nil {
+		return nil, fmt.Errorf("read converted file: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,184 @@
+package odtimagereplacer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Credential authenticates downloads from hosts matching Hosts. Host
+// patterns follow URLPolicy: "*.example.com" matches every subdomain.
+type Credential struct {
+	// Name lets requests select the credential explicitly
+	Name  string   `json:"name"`
+	Hosts []string `json:"hosts"`
+
+	// Bearer is sent as "Authorization: Bearer <token>"
+	Bearer string `json:"bearer,omitempty"`
+
+	// Username and Password are sent as basic authentication
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Headers are added to every request, e.g. API key headers
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// String describes the credential without its secrets, so that it can be
+// logged safely
+func (c Credential) String() string {
+	return fmt.Sprintf("credential %q for %s", c.Name, strings.Join(c.Hosts, ", "))
+}
+
+// apply adds the credential's authentication to h
+func (c Credential) apply(h http.Header) {
+	for key, value := range c.Headers {
+		h.Set(key, value)
+	}
+	switch {
+	case c.Bearer != "":
+		h.Set("Authorization", "Bearer "+c.Bearer)
+	case c.Username != "" || c.Password != "":
+		auth := base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + c.Password))
+		h.Set("Authorization", "Basic "+auth)
+	}
+}
+
+// Credentials is a set of credentials for remote sources
+type Credentials []Credential
+
+// DefaultCredentials authenticate downloads of the Process functions
+var DefaultCredentials Credentials
+
+// envRefRegex matches ${NAME} references in credential secrets
+var envRefRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnvRefs replaces ${NAME} with the environment variable NAME; any
+// other "$" is part of the secret and kept as is
+func expandEnvRefs(s string) string {
+	return envRefRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+}
+
+// LoadCredentials reads credentials from a JSON array. Secret values may
+// reference environment variables as ${NAME}, keeping secrets out of the
+// file; other "$" characters are taken literally.
+func LoadCredentials(path string) (Credentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read credentials: %w", err)
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("parse credentials: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for i := range creds {
+		c := &creds[i]
+		if len(c.Hosts) == 0 {
+			return nil, fmt.Errorf("credential %d (%q) has no hosts", i, c.Name)
+		}
+		if c.Name != "" {
+			if names[c.Name] {
+				return nil, fmt.Errorf("duplicate credential name %q", c.Name)
+			}
+			names[c.Name] = true
+		}
+
+		c.Bearer = expandEnvRefs(c.Bearer)
+		c.Username = expandEnvRefs(c.Username)
+		c.Password = expandEnvRefs(c.Password)
+		for key, value := range c.Headers {
+			c.Headers[key] = expandEnvRefs(value)
+		}
+	}
+
+	return creds, nil
+}
+
+// lookup returns the credential for a download from host. A named
+// credential must exist and cover the host, so that requests cannot send
+// it elsewhere; without a name, the first credential matching the host is
+// used, if any.
+func (cs Credentials) lookup(name, host string) (*Credential, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if name != "" {
+		for i := range cs {
+			if cs[i].Name != name {
+				continue
+			}
+			if !matchHost(cs[i].Hosts, host) {
+				return nil, fmt.Errorf("%w: credential %q is not valid for host %s", ErrURLNotAllowed, name, host)
+			}
+			return &cs[i], nil
+		}
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCredential, name)
+	}
+
+	for i := range cs {
+		if matchHost(cs[i].Hosts, host) {
+			return &cs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// sourceHeader builds the request headers for downloading rawURL: the
+// source's own headers, overridden by the authentication of its credential
+func sourceHeader(rawURL string, headers map[string]string, credential string, creds Credentials) (http.Header, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	h := make(http.Header)
+	for key, value := range headers {
+		h.Set(key, value)
+	}
+
+	cred, err := creds.lookup(credential, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	if cred != nil {
+		cred.apply(h)
+	}
+
+	if len(h) == 0 {
+		return nil, nil
+	}
+	return h, nil
+}
+
+// withoutCrossHostHeaders returns a copy of client that drops header from
+// redirects to other hosts, so that secrets only reach the host they were
+// meant for
+func withoutCrossHostHeaders(client *http.Client, host string, header http.Header) *http.Client {
+	c := *client
+	checkRedirect := client.CheckRedirect
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !strings.EqualFold(req.URL.Host, host) {
+			for key := range header {
+				req.Header.Del(key)
+			}
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &c
+}
@@ -0,0 +1,138 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCredentials_Lookup(t *testing.T) {
+	creds := Credentials{
+		{Name: "assets", Hosts: []string{"*.assets.test"}, Bearer: "token"},
+		{Name: "cdn", Hosts: []string{"cdn.test"}, Username: "u", Password: "p"},
+	}
+
+	tests := []struct {
+		name, credential, host string
+		want                   string
+		wantErr                error
+	}{
+		{"named", "assets", "img.assets.test", "assets", nil},
+		{"matched by host", "", "CDN.test", "cdn", nil},
+		{"no match", "", "other.test", "", nil},
+		{"named for another host", "assets", "evil.test", "", ErrURLNotAllowed},
+		{"unknown", "missing", "cdn.test", "", ErrUnknownCredential},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred, err := creds.lookup(tt.credential, tt.host)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("lookup() error = %v, want %v", err, tt.wantErr)
+			}
+			got := ""
+			if cred != nil {
+				got = cred.Name
+			}
+			if got != tt.want {
+				t.Errorf("lookup() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchImageFromURL_Credentials(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/echo":
+			w.Write([]byte(r.Header.Get("Authorization") + "|" + r.Header.Get("X-Api-Key")))
+		case "/same-host":
+			http.Redirect(w, r, "/echo", http.StatusFound)
+		case "/other-host":
+			http.Redirect(w, r, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/echo", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	creds := Credentials{{
+		Name:    "assets",
+		Hosts:   []string{"127.0.0.1"},
+		Bearer:  "secret",
+		Headers: map[string]string{"X-Api-Key": "key"},
+	}}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/echo", "Bearer secret|key"},
+		{"/same-host", "Bearer secret|key"},
+		{"/other-host", "|"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			header, err := sourceHeader(server.URL+tt.path, nil, "", creds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _, err := fetchURL(context.Background(), server.URL+tt.path, header, server.Client())
+			if err != nil {
+				t.Fatalf("fetchURL() error = %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("server received %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	t.Setenv("ASSET_TOKEN", "from-env")
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	config := `[{"name": "assets", "hosts": ["assets.test"], "bearer": "${ASSET_TOKEN}"}]`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := LoadCredentials(path)
+	if err != nil {
+		t.Fatalf("LoadCredentials() error = %v", err)
+	}
+	if len(creds) != 1 || creds[0].Bearer != "from-env" {
+		t.Fatalf("LoadCredentials() = %+v", creds)
+	}
+	if s := creds[0].String(); strings.Contains(s, "from-env") {
+		t.Errorf("String() = %q reveals the token", s)
+	}
+}
+
+func TestLoadCredentials_LiteralDollar(t *testing.T) {
+	t.Setenv("ODT_TEST_TOKEN", "from-env")
+	t.Setenv("abc", "expanded")
+
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	data := `[{"name": "a", "hosts": ["a.test"], "username": "u$abc", "password": "pa$$word",
+		"bearer": "${ODT_TEST_TOKEN}", "headers": {"X-Key": "k$ey-${ODT_TEST_TOKEN}-$"}}]`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := LoadCredentials(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := creds[0]
+	if c.Username != "u$abc" || c.Password != "pa$$word" {
+		t.Errorf("literal secrets = %q, %q; want them unchanged", c.Username, c.Password)
+	}
+	if c.Bearer != "from-env" || c.Headers["X-Key"] != "k$ey-from-env-$" {
+		t.Errorf("expanded secrets = %q, %q", c.Bearer, c.Headers["X-Key"])
+	}
+}
@@ -0,0 +1,713 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// nsLoext is the LibreOffice extension namespace used for Argon2 parameters
+const nsLoext = "urn:org:documentfoundation:names:experimental:office:xmlns:loext:1.0"
+
+// encryptedPackagePath holds the inner package of wholesome-encrypted
+// documents (ODF 1.3 extended, written by recent LibreOffice versions)
+const encryptedPackagePath = "encrypted-package"
+
+// Algorithm identifiers used in manifest:encryption-data
+const (
+	algorithmAESCBC = "http://www.w3.org/2001/04/xmlenc#aes256-cbc"
+	algorithmAESGCM = "http://www.w3.org/2009/xmlenc11#aes256-gcm"
+	startKeySHA256  = "http://www.w3.org/2000/09/xmldsig#sha256"
+	startKeySHA1    = "http://www.w3.org/2000/09/xmldsig#sha1"
+	checksumSHA256  = "urn:oasis:names:tc:opendocument:xmlns:manifest:1.0#sha256-1k"
+	derivationPBKDF = "PBKDF2"
+	derivationArgon = "urn:org:documentfoundation:names:experimental:office:manifest:argon2id"
+)
+
+// defaultPBKDF2Iterations matches LibreOffice's ODF 1.2 encryption
+const defaultPBKDF2Iterations = 100000
+
+// Limits on key derivation costs read from a manifest. Documents come from
+// callers, and unchecked costs can panic or exhaust CPU and memory.
+const (
+	maxPBKDF2Iterations = 10000000
+	maxArgonIterations  = 100
+	maxArgonMemory      = 1 << 20 // KiB, 1 GiB
+	maxArgonLanes       = 64
+)
+
+// encryptionData is a parsed manifest:encryption-data element
+type encryptionData struct {
+	ChecksumType string `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 checksum-type,attr"`
+	Checksum     string `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 checksum,attr"`
+	Algorithm    struct {
+		Name string `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 algorithm-name,attr"`
+		IV   string `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 initialisation-vector,attr"`
+	} `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 algorithm"`
+	StartKey *struct {
+		Name    string `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 start-key-generation-name,attr"`
+		KeySize int    `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 key-size,attr"`
+	} `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 start-key-generation"`
+	KeyDerivation struct {
+		Name            string `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 key-derivation-name,attr"`
+		KeySize         int    `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 key-size,attr"`
+		IterationCount  int    `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 iteration-count,attr"`
+		Salt            string `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 salt,attr"`
+		ArgonIterations uint32 `xml:"urn:org:documentfoundation:names:experimental:office:xmlns:loext:1.0 argon2-iterations,attr"`
+		ArgonMemory     uint32 `xml:"urn:org:documentfoundation:names:experimental:office:xmlns:loext:1.0 argon2-memory,attr"`
+		ArgonLanes      uint8  `xml:"urn:org:documentfoundation:names:experimental:office:xmlns:loext:1.0 argon2-lanes,attr"`
+	} `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 key-derivation"`
+}
+
+// defaultEncryption returns the parameters used when encrypting a plain
+// document: AES-256-CBC with PBKDF2, as written by LibreOffice for ODF 1.2
+func defaultEncryption() *encryptionData {
+	enc := &encryptionData{ChecksumType: checksumSHA256}
+	enc.Algorithm.Name = algorithmAESCBC
+	enc.StartKey = &struct {
+		Name    string `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 start-key-generation-name,attr"`
+		KeySize int    `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 key-size,attr"`
+	}{Name: startKeySHA256, KeySize: 32}
+	enc.KeyDerivation.Name = derivationPBKDF
+	enc.KeyDerivation.KeySize = 32
+	enc.KeyDerivation.IterationCount = defaultPBKDF2Iterations
+	return enc
+}
+
+// parseEncryptionData extracts encryption data from a manifest entry, or
+// returns nil if the entry is not encrypted
+func (m *Manifest) parseEncryptionData(entry *ManifestEntry) (*encryptionData, error) {
+	if !strings.Contains(entry.inner, "encryption-data") {
+		return nil, nil
+	}
+
+	// Re-declare the root namespaces so the raw child XML can be resolved
+	var wrapper bytes.Buffer
+	wrapper.WriteString("<wrapper")
+	for _, a := range m.rootAttrs {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			writeAttrs(&wrapper, []xml.Attr{a})
+		}
+	}
+	wrapper.WriteString(">" + entry.inner + "</wrapper>")
+
+	var parsed struct {
+		Data *encryptionData `xml:"urn:oasis:names:tc:opendocument:xmlns:manifest:1.0 encryption-data"`
+	}
+	if err := xml.Unmarshal(wrapper.Bytes(), &parsed); err != nil {
+		return nil, fmt.Errorf("parse encryption data for %s: %w", entry.FullPath, err)
+	}
+	return parsed.Data, nil
+}
+
+// xml serializes the encryption data using the manifest's prefixes
+func (enc *encryptionData) xml(prefix, loextPrefix string) string {
+	var buf bytes.Buffer
+	name := func(local string) string {
+		if prefix == "" {
+			return local
+		}
+		return prefix + ":" + local
+	}
+
+	buf.WriteString("<" + name("encryption-data"))
+	if enc.ChecksumType != "" {
+		writeAttr(&buf, name("checksum-type"), enc.ChecksumType)
+		writeAttr(&buf, name("checksum"), enc.Checksum)
+	}
+	buf.WriteString("><" + name("algorithm"))
+	writeAttr(&buf, name("algorithm-name"), enc.Algorithm.Name)
+	writeAttr(&buf, name("initialisation-vector"), enc.Algorithm.IV)
+	buf.WriteString("/>")
+
+	if enc.StartKey != nil {
+		buf.WriteString("<" + name("start-key-generation"))
+		writeAttr(&buf, name("start-key-generation-name"), enc.StartKey.Name)
+		writeAttr(&buf, name("key-size"), strconv.Itoa(enc.StartKey.KeySize))
+		buf.WriteString("/>")
+	}
+
+	kd := enc.KeyDerivation
+	buf.WriteString("<" + name("key-derivation"))
+	writeAttr(&buf, name("key-derivation-name"), kd.Name)
+	writeAttr(&buf, name("key-size"), strconv.Itoa(kd.KeySize))
+	if kd.Name == derivationArgon {
+		writeAttr(&buf, name("salt"), kd.Salt)
+		writeAttr(&buf, loextPrefix+":argon2-iterations", strconv.FormatUint(uint64(kd.ArgonIterations), 10))
+		writeAttr(&buf, loextPrefix+":argon2-memory", strconv.FormatUint(uint64(kd.ArgonMemory), 10))
+		writeAttr(&buf, loextPrefix+":argon2-lanes", strconv.FormatUint(uint64(kd.ArgonLanes), 10))
+	} else {
+		writeAttr(&buf, name("iteration-count"), strconv.Itoa(kd.IterationCount))
+		writeAttr(&buf, name("salt"), kd.Salt)
+	}
+	buf.WriteString("/></" + name("encryption-data") + ">")
+
+	return buf.String()
+}
+
+// deriveKey computes the AES key from the password
+func (enc *encryptionData) deriveKey(password string) ([]byte, error) {
+	var startHash func() hash.Hash = sha1.New
+	if enc.StartKey != nil {
+		switch enc.StartKey.Name {
+		case startKeySHA256, "SHA256":
+			startHash = sha256.New
+		case startKeySHA1, "SHA1":
+		default:
+			return nil, fmt.Errorf("%w: start key generation %s", ErrUnsupportedEncryption, enc.StartKey.Name)
+		}
+	}
+	h := startHash()
+	h.Write([]byte(password))
+	startKey := h.Sum(nil)
+
+	salt, err := base64.StdEncoding.DecodeString(enc.KeyDerivation.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid salt", ErrInvalidODT)
+	}
+
+	keySize := enc.KeyDerivation.KeySize
+	if keySize == 0 {
+		keySize = 16
+	}
+
+	if keySize != 16 && keySize != 24 && keySize != 32 {
+		return nil, fmt.Errorf("%w: key size %d", ErrUnsupportedEncryption, keySize)
+	}
+
+	kd := enc.KeyDerivation
+	switch kd.Name {
+	case derivationPBKDF:
+		if kd.IterationCount < 1 || kd.IterationCount > maxPBKDF2Iterations {
+			return nil, fmt.Errorf("%w: PBKDF2 iteration count %d", ErrUnsupportedEncryption, kd.IterationCount)
+		}
+		return pbkdf2.Key(sha1.New, string(startKey), salt, kd.IterationCount, keySize)
+	case derivationArgon:
+		if kd.ArgonIterations < 1 || kd.ArgonIterations > maxArgonIterations ||
+			kd.ArgonMemory > maxArgonMemory || kd.ArgonLanes < 1 || kd.ArgonLanes > maxArgonLanes {
+			return nil, fmt.Errorf("%w: Argon2 iterations %d, memory %d KiB, lanes %d",
+				ErrUnsupportedEncryption, kd.ArgonIterations, kd.ArgonMemory, kd.ArgonLanes)
+		}
+		return argon2.IDKey(startKey, salt, kd.ArgonIterations, kd.ArgonMemory, kd.ArgonLanes, uint32(keySize)), nil
+	default:
+		return nil, fmt.Errorf("%w: key derivation %s", ErrUnsupportedEncryption, kd.Name)
+	}
+}
+
+// checksum computes the checksum of the first kilobyte of compressed data
+func (enc *encryptionData) checksum(compressed []byte) ([]byte, error) {
+	head := compressed[:min(len(compressed), 1024)]
+	switch enc.ChecksumType {
+	case checksumSHA256, "SHA256/1K":
+		sum := sha256.Sum256(head)
+		return sum[:], nil
+	case "SHA1/1K", "urn:oasis:names:tc:opendocument:xmlns:manifest:1.0#sha1-1k":
+		sum := sha1.Sum(head)
+		return sum[:], nil
+	default:
+		return nil, fmt.Errorf("%w: checksum type %s", ErrUnsupportedEncryption, enc.ChecksumType)
+	}
+}
+
+// decrypt decrypts and inflates an encrypted part
+func (enc *encryptionData) decrypt(data []byte, password string, limit int64) ([]byte, error) {
+	key, err := enc.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := base64.StdEncoding.DecodeString(enc.Algorithm.IV)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid initialisation vector", ErrInvalidODT)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var compressed []byte
+	switch {
+	case enc.Algorithm.Name == algorithmAESGCM:
+		gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+		if err != nil {
+			return nil, err
+		}
+		compressed, err = gcm.Open(nil, iv, data, nil)
+		if err != nil {
+			return nil, ErrWrongPassword
+		}
+	case strings.HasSuffix(enc.Algorithm.Name, "-cbc"):
+		if len(data) == 0 || len(data)%aes.BlockSize != 0 || len(iv) != aes.BlockSize {
+			return nil, fmt.Errorf("%w: invalid ciphertext length", ErrInvalidODT)
+		}
+		compressed = make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(compressed, data)
+
+		// W3C padding: the last byte holds the padding length
+		padding := int(compressed[len(compressed)-1])
+		if padding == 0 || padding > aes.BlockSize {
+			return nil, ErrWrongPassword
+		}
+		compressed = compressed[:len(compressed)-padding]
+	default:
+		return nil, fmt.Errorf("%w: algorithm %s", ErrUnsupportedEncryption, enc.Algorithm.Name)
+	}
+
+	if enc.ChecksumType != "" {
+		expected, err := base64.StdEncoding.DecodeString(enc.Checksum)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid checksum", ErrInvalidODT)
+		}
+		actual, err := enc.checksum(compressed)
+		if err != nil {
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare(expected, actual) != 1 {
+			return nil, ErrWrongPassword
+		}
+	}
+
+	data, err = io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: inflate encrypted part: %v", ErrWrongPassword, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: encrypted part exceeds limit after decompression", ErrFileTooLarge)
+	}
+	return data, nil
+}
+
+// partEncrypter encrypts parts during a single save. All parts share one
+// salt and derived key; every part gets its own initialisation vector.
+type partEncrypter struct {
+	params   *encryptionData
+	password string
+	salt     string
+	key      []byte
+}
+
+// newPartEncrypter derives a key with a fresh salt for the given parameters
+func newPartEncrypter(params *encryptionData, password string) (*partEncrypter, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	e := &partEncrypter{params: params, password: password, salt: base64.StdEncoding.EncodeToString(salt)}
+	keyParams := *params
+	keyParams.KeyDerivation.Salt = e.salt
+	key, err := keyParams.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	e.key = key
+	return e, nil
+}
+
+// encrypt compresses and encrypts data, returning the ciphertext and the
+// encryption data to store in the manifest
+func (e *partEncrypter) encrypt(data []byte) ([]byte, *encryptionData, error) {
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	out := *e.params
+	out.KeyDerivation.Salt = e.salt
+
+	block, err := aes.NewCipher(e.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if out.Algorithm.Name == algorithmAESGCM {
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(iv); err != nil {
+			return nil, nil, err
+		}
+		out.Algorithm.IV = base64.StdEncoding.EncodeToString(iv)
+		// GCM authenticates the data itself; no checksum is written
+		out.ChecksumType, out.Checksum = "", ""
+		return gcm.Seal(nil, iv, compressed.Bytes(), nil), &out, nil
+	}
+
+	out.Algorithm.Name = algorithmAESCBC
+	if out.ChecksumType == "" {
+		out.ChecksumType = checksumSHA256
+	}
+	sum, err := out.checksum(compressed.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	out.Checksum = base64.StdEncoding.EncodeToString(sum)
+
+	// W3C padding: random filler with the padding length in the last byte
+	padding := aes.BlockSize - compressed.Len()%aes.BlockSize
+	filler := make([]byte, padding)
+	if _, err := rand.Read(filler); err != nil {
+		return nil, nil, err
+	}
+	filler[padding-1] = byte(padding)
+	plain := append(compressed.Bytes(), filler...)
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, err
+	}
+	out.Algorithm.IV = base64.StdEncoding.EncodeToString(iv)
+	ciphertext := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plain)
+
+	return ciphertext, &out, nil
+}
+
+// outerPackage is the unencrypted wrapper of a wholesome-encrypted document
+type outerPackage struct {
+	reader     *zip.Reader
+	manifest   *Manifest
+	encryption *encryptionData
+}
+
+// initEncryption detects encrypted parts from the manifest. It returns
+// ErrEncrypted if the package is encrypted and no password was given, and
+// ErrWrongPassword if the password does not decrypt it.
+func (doc *ODTDocument) initEncryption(password string) error {
+	data, err := doc.originalFile(manifestPath)
+	if err != nil {
+		// Packages without a manifest cannot be encrypted
+		return nil
+	}
+	m, err := parseManifest(data)
+	if err != nil {
+		// Reported by Validate; the package is treated as unencrypted
+		return nil
+	}
+
+	// Later reads use the parsed manifest instead of parsing it again
+	doc.cache.files[manifestPath] = data
+	doc.cache.manifest = m
+
+	encryption := make(map[string]*encryptionData)
+	for i := range m.Entries {
+		enc, err := m.parseEncryptionData(&m.Entries[i])
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidODT, err)
+		}
+		if enc != nil {
+			encryption[m.Entries[i].FullPath] = enc
+		}
+	}
+	if len(encryption) == 0 {
+		return nil
+	}
+	if password == "" {
+		return ErrEncrypted
+	}
+
+	doc.password = password
+	doc.readPassword = password
+
+	// Wholesome encryption stores the whole inner package in one part
+	if enc := encryption[encryptedPackagePath]; enc != nil {
+		return doc.openEncryptedPackage(m, enc)
+	}
+
+	doc.encryption = encryption
+	doc.plainParts = make(map[string]bool)
+	for _, f := range doc.pkg.reader.File {
+		if encryption[f.Name] == nil {
+			doc.plainParts[f.Name] = true
+		}
+	}
+
+	// Verify the password on content.xml, or any other encrypted part
+	name := "content.xml"
+	if encryption[name] == nil {
+		name = doc.firstEncryptedPart()
+	}
+	_, err = doc.originalFile(name)
+	return err
+}
+
+// openEncryptedPackage decrypts the inner package of a wholesome-encrypted
+// document and makes it the package being edited
+func (doc *ODTDocument) openEncryptedPackage(m *Manifest, enc *encryptionData) error {
+	var raw []byte
+	for _, f := range doc.pkg.reader.File {
+		if f.Name != encryptedPackagePath {
+			continue
+		}
+		if f.UncompressedSize64 > MaxFileSize {
+			return fmt.Errorf("%w: %s is %d bytes (max: %d)", ErrFileTooLarge, f.Name, f.UncompressedSize64, MaxFileSize)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("open file %s: %w", f.Name, err)
+		}
+		raw, err = io.ReadAll(io.LimitReader(rc, MaxFileSize+1))
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("read file %s: %w", f.Name, err)
+		}
+	}
+	if raw == nil {
+		return fmt.Errorf("%w: %s not found in archive", ErrInvalidODT, encryptedPackagePath)
+	}
+
+	inner, err := enc.decrypt(raw, doc.readPassword, MaxFileSize)
+	if err != nil {
+		return fmt.Errorf("decrypt %s: %w", encryptedPackagePath, err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(inner), int64(len(inner)))
+	if err != nil {
+		return fmt.Errorf("%w: encrypted package: %v", ErrInvalidODT, err)
+	}
+	if len(reader.File) > MaxFilesInArchive {
+		return fmt.Errorf("%w: %d files (max: %d)", ErrTooManyFiles, len(reader.File), MaxFilesInArchive)
+	}
+
+	doc.outer = &outerPackage{reader: doc.pkg.reader, manifest: m, encryption: enc}
+	doc.pkg = newZipPackage(reader)
+	doc.cache = &partCache{files: make(map[string][]byte)}
+	return nil
+}
+
+// firstEncryptedPart returns the first encrypted part in archive order
+func (doc *ODTDocument) firstEncryptedPart() string {
+	for _, f := range doc.pkg.reader.File {
+		if doc.encryption[f.Name] != nil {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+// decryptFile decrypts an original part the manifest marks as encrypted
+func (doc *ODTDocument) decryptFile(name string, raw []byte) ([]byte, error) {
+	data, err := doc.encryption[name].decrypt(raw, doc.readPassword, MaxIndividualFileSize)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// Encrypted reports whether the document is saved with a password
+func (doc *ODTDocument) Encrypted() bool {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	return doc.password != ""
+}
+
+// SetPassword sets the password used to encrypt the document on save.
+// Plain documents are encrypted with AES-256-CBC and PBKDF2 as LibreOffice
+// does for ODF 1.2; encrypted documents keep their algorithms. An empty
+// password saves the document unencrypted.
+func (doc *ODTDocument) SetPassword(password string) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	if password == doc.password {
+		return
+	}
+	if doc.password == "" {
+		// Encrypt every part of a previously plain document
+		doc.plainParts = nil
+	}
+	if password == "" {
+		doc.outer = nil
+	}
+	doc.password = password
+	doc.rekey = true
+}
+
+// shouldEncrypt reports whether a part is encrypted when saving. Parts of
+// wholesome-encrypted documents are encrypted together with the package.
+func (doc *ODTDocument) shouldEncrypt(name string) bool {
+	if doc.password == "" || doc.outer != nil {
+		return false
+	}
+	if name == "mimetype" || strings.HasPrefix(name, "META-INF/") {
+		return false
+	}
+	return !doc.plainParts[name]
+}
+
+// encryptionParams returns the parameters for parts encrypted on save,
+// taken from the template's own encryption where possible
+func (doc *ODTDocument) encryptionParams() *encryptionData {
+	if enc := doc.encryption["content.xml"]; enc != nil {
+		return enc
+	}
+	if name := doc.firstEncryptedPart(); name != "" {
+		return doc.encryption[name]
+	}
+	return defaultEncryption()
+}
+
+// encryptPart encrypts a part for writing and records its encryption data
+// in the manifest
+func encryptPart(m *Manifest, e *partEncrypter, name string, data []byte) ([]byte, error) {
+	ciphertext, enc, err := e.encrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt %s: %w", name, err)
+	}
+
+	entry := m.Entry(name)
+	if entry == nil {
+		m.Set(name, mediaTypeFor(name, data))
+		entry = m.Entry(name)
+	}
+	entry.setAttr(m.prefix, "size", strconv.Itoa(len(data)))
+	entry.inner = enc.xml(m.prefix, m.loextPrefix(enc))
+
+	return ciphertext, nil
+}
+
+// clearEncryption removes encryption data from a manifest entry, reporting
+// whether the entry was encrypted
+func (e *ManifestEntry) clearEncryption(prefix string) bool {
+	if !strings.Contains(e.inner, "encryption-data") {
+		return false
+	}
+	e.inner = ""
+	e.removeAttr(prefix, "size")
+	return true
+}
+
+// loextPrefix returns the prefix of the LibreOffice extension namespace,
+// declaring it on the root element if the encryption data needs it
+func (m *Manifest) loextPrefix(enc *encryptionData) string {
+	if prefix := namespacePrefix(m.rootAttrs, nsLoext); prefix != "" {
+		return prefix
+	}
+	if enc.KeyDerivation.Name != derivationArgon {
+		return ""
+	}
+	m.rootAttrs = append(m.rootAttrs, xml.Attr{Name: xml.Name{Space: "xmlns", Local: "loext"}, Value: nsLoext})
+	return "loext"
+}
+
+// setAttr sets a prefixed attribute, replacing an existing value
+func (e *ManifestEntry) setAttr(prefix, local, value string) {
+	for i := range e.attrs {
+		if e.attrs[i].Name.Space == prefix && e.attrs[i].Name.Local == local {
+			e.attrs[i].Value = value
+			return
+		}
+	}
+	e.attrs = append(e.attrs, xml.Attr{Name: xml.Name{Space: prefix, Local: local}, Value: value})
+}
+
+// removeAttr removes a prefixed attribute
+func (e *ManifestEntry) removeAttr(prefix, local string) {
+	e.attrs = slices.DeleteFunc(e.attrs, func(a xml.Attr) bool {
+		return a.Name.Space == prefix && a.Name.Local == local
+	})
+}
+
+// wrapEncryptedPackage encrypts a serialized inner package into the outer
+// wrapper of a wholesome-encrypted document
+func (doc *ODTDocument) wrapEncryptedPackage(inner []byte) ([]byte, error) {
+	outer := doc.outer
+	e, err := newPartEncrypter(outer.encryption, doc.password)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %w", err)
+	}
+
+	m := outer.manifest.clone()
+	ciphertext, err := encryptPart(m, e, encryptedPackagePath, inner)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	writer := zip.NewWriter(buf)
+	for _, f := range outer.reader.File {
+		var err error
+		switch f.Name {
+		case encryptedPackagePath:
+			err = writeZipEntry(writer, f.Name, zip.Store, ciphertext)
+		case manifestPath:
+			err = writeZipEntry(writer, f.Name, zip.Deflate, m.Bytes())
+		default:
+			err = copyZipEntry(writer, f)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("close zip writer: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeZipEntry writes a single ZIP entry
+func writeZipEntry(writer *zip.Writer, name string, method uint16, data []byte) error {
+	fw, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+	if err != nil {
+		return fmt.Errorf("create zip entry %s: %w", name, err)
+	}
+	if _, err := fw.Write(data); err != nil {
+		return fmt.Errorf("write zip entry %s: %w", name, err)
+	}
+	return nil
+}
+
+// copyBuffers holds buffers for copying raw ZIP entries, which saves an
+// allocation per entry when packages with many parts are written
+var copyBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 32*1024)
+		return &buf
+	},
+}
+
+// copyZipEntry copies an entry verbatim without decompressing it
+func copyZipEntry(writer *zip.Writer, f *zip.File) error {
+	header := f.FileHeader
+	fw, err := writer.CreateRaw(&header)
+	if err != nil {
+		return fmt.Errorf("create zip entry %s: %w", f.Name, err)
+	}
+	rc, err := f.OpenRaw()
+	if err != nil {
+		return fmt.Errorf("open file %s: %w", f.Name, err)
+	}
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	if _, err := io.CopyBuffer(fw, rc, *buf); err != nil {
+		return fmt.Errorf("copy zip entry %s: %w", f.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// encryptedTestDoc returns a plain package saved with a password
+func encryptedTestDoc(t *testing.T, password string) []byte {
+	t.Helper()
+
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+	doc.SetPassword(password)
+
+	encrypted, err := doc.SaveToBytes()
+	if err != nil {
+		t.Fatalf("SaveToBytes() error = %v", err)
+	}
+	return encrypted
+}
+
+func TestODTDocument_Encrypted_OpenErrors(t *testing.T) {
+	data := encryptedTestDoc(t, "secret")
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  error
+	}{
+		{"no password", "", ErrEncrypted},
+		{"wrong password", "wrong", ErrWrongPassword},
+		{"correct password", "secret", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewODTDocumentFromBytesWithPassword(data, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewODTDocumentFromBytesWithPassword() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestODTDocument_Encrypted_ReplaceAndSave(t *testing.T) {
+	data := encryptedTestDoc(t, "secret")
+
+	// Parts are encrypted; mimetype and the manifest stay readable
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.File[0].Name != "mimetype" || reader.File[0].Method != zip.Store {
+		t.Errorf("first entry = %s (method %d), want stored mimetype", reader.File[0].Name, reader.File[0].Method)
+	}
+
+	doc, err := NewODTDocumentFromBytesWithPassword(data, "secret")
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytesWithPassword() error = %v", err)
+	}
+	if !doc.Encrypted() {
+		t.Error("Encrypted() = false for a password-protected document")
+	}
+	manifest, _ := doc.getManifestXML()
+	if strings.Count(manifest, "encryption-data") != 4 {
+		t.Errorf("manifest does not list encryption data for both parts:\n%s", manifest)
+	}
+
+	if err := doc.ReplaceImageByTag("image1", "Pictures/new.png", []byte(testPNG+"new")); err != nil {
+		t.Fatalf("ReplaceImageByTag() error = %v", err)
+	}
+	saved, err := doc.SaveToBytes()
+	if err != nil {
+		t.Fatalf("SaveToBytes() error = %v", err)
+	}
+
+	if _, err := NewODTDocumentFromBytes(saved); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("saved document opened without password: %v", err)
+	}
+
+	reopened, err := NewODTDocumentFromBytesWithPassword(saved, "secret")
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	image, err := reopened.readFile("Pictures/new.png")
+	if err != nil || string(image) != testPNG+"new" {
+		t.Errorf("replaced image = %q, %v", image, err)
+	}
+	content, err := reopened.getContentXML()
+	if err != nil || !strings.Contains(content, `xlink:href="Pictures/new.png"`) {
+		t.Errorf("content.xml does not reference the new image: %v", err)
+	}
+	if report := reopened.Validate(); !report.Valid {
+		t.Errorf("Validate() findings = %+v", report.Findings)
+	}
+}
+
+func TestODTDocument_SetPassword_Remove(t *testing.T) {
+	doc, err := NewODTDocumentFromBytesWithPassword(encryptedTestDoc(t, "secret"), "secret")
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytesWithPassword() error = %v", err)
+	}
+
+	doc.SetPassword("")
+	data, err := doc.SaveToBytes()
+	if err != nil {
+		t.Fatalf("SaveToBytes() error = %v", err)
+	}
+
+	plain, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+	manifest, _ := plain.getManifestXML()
+	if strings.Contains(manifest, "encryption-data") || strings.Contains(manifest, "manifest:size") {
+		t.Errorf("manifest still lists encryption data:\n%s", manifest)
+	}
+	if content, err := plain.getContentXML(); err != nil || !strings.Contains(content, "image1") {
+		t.Errorf("content.xml not readable after removing the password: %v", err)
+	}
+}
+
+// gcmArgonParams returns AES-256-GCM parameters with Argon2id key derivation
+func gcmArgonParams() *encryptionData {
+	enc := defaultEncryption()
+	enc.ChecksumType = ""
+	enc.Algorithm.Name = algorithmAESGCM
+	enc.KeyDerivation.Name = derivationArgon
+	enc.KeyDerivation.ArgonIterations = 1
+	enc.KeyDerivation.ArgonMemory = 64
+	enc.KeyDerivation.ArgonLanes = 1
+	return enc
+}
+
+func TestEncryptionData_RoundTrip(t *testing.T) {
+	sha1Start := defaultEncryption()
+	sha1Start.StartKey = nil
+	sha1Start.KeyDerivation.IterationCount = 1024
+
+	tests := []struct {
+		name   string
+		params *encryptionData
+	}{
+		{"aes-cbc pbkdf2", defaultEncryption()},
+		{"aes-cbc sha1 start key", sha1Start},
+		{"aes-gcm argon2id", gcmArgonParams()},
+	}
+
+	plain := []byte(strings.Repeat("<text:p>Hello</text:p>", 100))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := newPartEncrypter(tt.params, "secret")
+			if err != nil {
+				t.Fatalf("newPartEncrypter() error = %v", err)
+			}
+
+			m := newManifest("application/vnd.oasis.opendocument.text")
+			ciphertext, err := encryptPart(m, e, "content.xml", plain)
+			if err != nil {
+				t.Fatalf("encryptPart() error = %v", err)
+			}
+
+			// Parse the encryption data back from the serialized manifest
+			parsed, err := parseManifest(m.Bytes())
+			if err != nil {
+				t.Fatalf("parseManifest() error = %v", err)
+			}
+			enc, err := parsed.parseEncryptionData(parsed.Entry("content.xml"))
+			if err != nil || enc == nil {
+				t.Fatalf("parseEncryptionData() = %v, %v", enc, err)
+			}
+
+			got, err := enc.decrypt(ciphertext, "secret", MaxIndividualFileSize)
+			if err != nil {
+				t.Fatalf("decrypt() error = %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Error("decrypted data does not match")
+			}
+
+			if _, err := enc.decrypt(ciphertext, "wrong", MaxIndividualFileSize); !errors.Is(err, ErrWrongPassword) {
+				t.Errorf("decrypt() with wrong password error = %v, want ErrWrongPassword", err)
+			}
+		})
+	}
+}
+
+func TestODTDocument_EncryptedPackage(t *testing.T) {
+	inner := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	e, err := newPartEncrypter(gcmArgonParams(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newManifest("application/vnd.oasis.opendocument.text")
+	m.Set(encryptedPackagePath, "application/vnd.oasis.opendocument.text")
+	ciphertext, err := encryptPart(m, e, encryptedPackagePath, inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{encryptedPackagePath, string(ciphertext), zip.Store},
+		{"META-INF/manifest.xml", string(m.Bytes()), zip.Deflate},
+	})
+
+	if _, err := NewODTDocumentFromBytes(data); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("open without password error = %v, want ErrEncrypted", err)
+	}
+
+	doc, err := NewODTDocumentFromBytesWithPassword(data, "secret")
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytesWithPassword() error = %v", err)
+	}
+	if err := doc.ReplaceImageByTag("image1", "Pictures/new.png", []byte(testPNG+"new")); err != nil {
+		t.Fatalf("ReplaceImageByTag() error = %v", err)
+	}
+	saved, err := doc.SaveToBytes()
+	if err != nil {
+		t.Fatalf("SaveToBytes() error = %v", err)
+	}
+
+	reopened, err := NewODTDocumentFromBytesWithPassword(saved, "secret")
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	if reopened.outer == nil {
+		t.Fatal("saved document is not wrapped in an encrypted package")
+	}
+	if image, err := reopened.readFile("Pictures/new.png"); err != nil || string(image) != testPNG+"new" {
+		t.Errorf("replaced image = %q, %v", image, err)
+	}
+}
+
+func TestODTDocument_Encrypted_Fixtures(t *testing.T) {
+	// Written by testdata/genencrypted.go in the layouts LibreOffice saves
+	const password = "Fixture-Passw0rd"
+
+	fixtures := []string{
+		"encrypted-cbc-pbkdf2.odt",   // AES-256-CBC, PBKDF2 with HMAC-SHA1
+		"encrypted-gcm-argon2id.odt", // AES-256-GCM, Argon2id, wholesome
+	}
+
+	for _, name := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := NewODTDocumentFromBytes(data); !errors.Is(err, ErrEncrypted) {
+				t.Errorf("open without password error = %v, want ErrEncrypted", err)
+			}
+			if _, err := NewODTDocumentFromBytesWithPassword(data, "wrong"); !errors.Is(err, ErrWrongPassword) {
+				t.Errorf("open with wrong password error = %v, want ErrWrongPassword", err)
+			}
+
+			doc, err := NewODTDocumentFromBytesWithPassword(data, password)
+			if err != nil {
+				t.Fatalf("NewODTDocumentFromBytesWithPassword() error = %v", err)
+			}
+			if content, err := doc.getContentXML(); err != nil || !strings.Contains(content, `draw:name="image1"`) {
+				t.Errorf("content.xml not readable: %v", err)
+			}
+			if image, err := doc.readFile("Pictures/img1.png"); err != nil || !bytes.HasPrefix(image, []byte("\x89PNG")) {
+				t.Errorf("Pictures/img1.png = %q, %v", image, err)
+			}
+			if meta, err := doc.Meta(); err != nil || meta.Title != "Encrypted fixture" {
+				t.Errorf("Meta() = %+v, %v", meta, err)
+			}
+		})
+	}
+}
+
+func TestODTDocument_Encrypted_KeyDerivationLimits(t *testing.T) {
+	const argon = `<manifest:key-derivation manifest:key-derivation-name="urn:org:documentfoundation:names:experimental:office:manifest:argon2id" manifest:key-size="32" manifest:salt="AAAAAAAAAAAAAAAAAAAAAA==" %s/>`
+	const pbkdf = `<manifest:key-derivation manifest:key-derivation-name="PBKDF2" manifest:key-size="32" manifest:salt="AAAAAAAAAAAAAAAAAAAAAA==" %s/>`
+
+	tests := []struct {
+		name       string
+		derivation string
+	}{
+		{"argon2 zero lanes", fmt.Sprintf(argon, `loext:argon2-iterations="3" loext:argon2-memory="65536" loext:argon2-lanes="0"`)},
+		{"argon2 missing lanes", fmt.Sprintf(argon, `loext:argon2-iterations="3" loext:argon2-memory="65536"`)},
+		{"argon2 zero iterations", fmt.Sprintf(argon, `loext:argon2-iterations="0" loext:argon2-memory="65536" loext:argon2-lanes="4"`)},
+		{"argon2 huge memory", fmt.Sprintf(argon, `loext:argon2-iterations="3" loext:argon2-memory="4294967295" loext:argon2-lanes="4"`)},
+		{"pbkdf2 huge iteration count", fmt.Sprintf(pbkdf, `manifest:iteration-count="2000000000"`)},
+		{"pbkdf2 missing iteration count", fmt.Sprintf(pbkdf, ``)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := `<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" xmlns:loext="urn:org:documentfoundation:names:experimental:office:xmlns:loext:1.0">
+ <manifest:file-entry manifest:full-path="/" manifest:media-type="application/vnd.oasis.opendocument.text"/>
+ <manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml">
+  <manifest:encryption-data>
+   <manifest:algorithm manifest:algorithm-name="http://www.w3.org/2009/xmlenc11#aes256-gcm" manifest:initialisation-vector="AAAAAAAAAAAAAAAA"/>
+   <manifest:start-key-generation manifest:start-key-generation-name="http://www.w3.org/2000/09/xmldsig#sha256" manifest:key-size="32"/>
+   ` + tt.derivation + `
+  </manifest:encryption-data>
+ </manifest:file-entry>
+</manifest:manifest>`
+
+			data := buildODT(t, []testEntry{
+				{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+				{"content.xml", strings.Repeat("\x00", 64), zip.Store},
+				{"META-INF/manifest.xml", manifest, zip.Deflate},
+			})
+
+			_, err := NewODTDocumentFromBytesWithPassword(data, "secret")
+			if !errors.Is(err, ErrUnsupportedEncryption) {
+				t.Errorf("NewODTDocumentFromBytesWithPassword() error = %v, want ErrUnsupportedEncryption", err)
+			}
+		})
+	}
+}
@@ -26,4 +26,61 @@ var (
 
 	// ErrTooManyFiles indicates too many files in ZIP archive
 	ErrTooManyFiles = errors.New("too many files in archive")
+
+	// ErrEncrypted indicates the document is encrypted and no password was given
+	ErrEncrypted = errors.New("document is password-protected")
+
+	// ErrWrongPassword indicates the password does not decrypt the document
+	ErrWrongPassword = errors.New("wrong password for encrypted document")
+
+	// ErrUnsupportedEncryption indicates an encryption algorithm that cannot be read
+	ErrUnsupportedEncryption = errors.New("unsupported encryption algorithm")
+
+	// ErrSignedPart indicates a modification of a digitally signed part was refused
+	ErrSignedPart = errors.New("part is covered by a digital signature")
+
+	// ErrTransactionActive indicates a transaction is already open
+	ErrTransactionActive = errors.New("transaction in progress")
+
+	// ErrNoTransaction indicates Commit or Rollback without Begin
+	ErrNoTransaction = errors.New("no transaction in progress")
+
+	// ErrURLNotAllowed indicates a download URL with a forbidden scheme or host
+	ErrURLNotAllowed = errors.New("URL not allowed")
+
+	// ErrBlockedAddress indicates a host resolving to a private or reserved address
+	ErrBlockedAddress = errors.New("address blocked")
+
+	// ErrTooManyRedirects indicates a download exceeded the redirect limit
+	ErrTooManyRedirects = errors.New("too many redirects")
+
+	// ErrUnexpectedContent indicates a download that is not the expected image or document
+	ErrUnexpectedContent = errors.New("unexpected content")
+
+	// ErrUnknownSource indicates an image source scheme without a registered resolver
+	ErrUnknownSource = errors.New("unknown image source")
+
+	// ErrAssetNotFound indicates a stored asset ID that does not exist
+	ErrAssetNotFound = errors.New("asset not found")
+
+	// ErrInvalidTag indicates an asset tag that is too long
+	ErrInvalidTag = errors.New("invalid tag")
+
+	// ErrObjectNotFound indicates a storage key without an object
+	ErrObjectNotFound = errors.New("object not found")
+
+	// ErrTemplateNotFound indicates a template ID or version that is not registered
+	ErrTemplateNotFound = errors.New("template not found")
+
+	// ErrJobNotFound indicates a job ID that does not exist or has expired
+	ErrJobNotFound = errors.New("job not found")
+
+	// ErrJobNotFinished indicates a job whose output is not available yet
+	ErrJobNotFinished = errors.New("job not finished")
+
+	// ErrQueueFull indicates a job queue that accepts no more jobs
+	ErrQueueFull = errors.New("job queue full")
+
+	// ErrUnknownCredential indicates a source naming a credential that is not configured
+	ErrUnknownCredential = errors.New("unknown credential")
 )
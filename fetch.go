@@ -0,0 +1,332 @@
+package odtimagereplacer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FetchOptions controls how the image sources of a request are resolved
+type FetchOptions struct {
+	// Workers limits the number of sources resolved at the same time
+	Workers int
+
+	// PerHost limits concurrent downloads from a single host
+	PerHost int
+
+	// Timeout limits resolving all sources of a request; zero means no
+	// limit beyond the request's own context
+	Timeout time.Duration
+
+	// Retries is the number of further attempts after a transient failure:
+	// a network error or a 408, 429, 500, 502, 503 or 504 response
+	Retries int
+
+	// Backoff is the delay before the first retry. It doubles for each
+	// further retry, with random jitter, up to MaxBackoff.
+	Backoff time.Duration
+
+	// MaxBackoff caps retry delays. A Retry-After header is honored up to
+	// this limit; a longer requested wait ends the retries.
+	MaxBackoff time.Duration
+
+	// Cache, if not nil, stores downloads for later requests
+	Cache *Cache
+}
+
+// DefaultFetchOptions are used by the Process functions
+var DefaultFetchOptions = FetchOptions{
+	Workers:    8,
+	PerHost:    4,
+	Timeout:    2 * time.Minute,
+	Retries:    2,
+	Backoff:    500 * time.Millisecond,
+	MaxBackoff: 10 * time.Second,
+}
+
+// contentKind is the kind of content a download must contain
+type contentKind int
+
+const (
+	imageContent contentKind = iota
+	templateContent
+)
+
+// statusError is an unsuccessful HTTP response
+type statusError struct {
+	code       int
+	status     string
+	retryAfter time.Duration
+	header     http.Header
+}
+
+func (e *statusError) Error() string {
+	return "HTTP error: " + e.status
+}
+
+// retryableStatus lists the responses that may succeed when repeated
+var retryableStatus = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// fetchResult is a resolved image source
+type fetchResult struct {
+	data []byte
+	err  error
+}
+
+// fetchImages resolves image sources concurrently within the limits of
+// opts. Results are keyed by tag; the order of completion does not matter.
+// fetched, if not nil, is called as each source completes, one at a time.
+func fetchImages(ctx context.Context, sources map[string]ImageSource, client HTTPClient, opts FetchOptions, fetched func(tag string, err error)) map[string]fetchResult {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	tags := make([]string, 0, len(sources))
+	for tag := range sources {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	workers := make(chan struct{}, max(opts.Workers, 1))
+	hosts := make(map[string]chan struct{})
+	for _, tag := range tags {
+		if host := sourceHost(sources[tag]); host != "" && hosts[host] == nil {
+			hosts[host] = make(chan struct{}, max(opts.PerHost, 1))
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]fetchResult, len(tags))
+
+	for _, tag := range tags {
+		wg.Add(1)
+		go func(tag string) {
+			defer wg.Done()
+
+			source := sources[tag]
+			data, err := fetchLimited(ctx, source, client, opts, workers, hosts[sourceHost(source)])
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[tag] = fetchResult{data, err}
+			if fetched != nil {
+				fetched(tag, err)
+			}
+		}(tag)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// fetchLimited resolves a source once a worker slot and, for downloads,
+// a slot of its host are free
+func fetchLimited(ctx context.Context, source ImageSource, client HTTPClient, opts FetchOptions, workers, hostSlots chan struct{}) ([]byte, error) {
+	if hostSlots != nil {
+		if !acquire(ctx, hostSlots) {
+			return nil, ctx.Err()
+		}
+		defer func() { <-hostSlots }()
+	}
+	if !acquire(ctx, workers) {
+		return nil, ctx.Err()
+	}
+	defer func() { <-workers }()
+
+	return getImageData(ctx, source, client, opts)
+}
+
+// acquire takes a slot of sem, giving up when ctx is done
+func acquire(ctx context.Context, sem chan struct{}) bool {
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sourceHost returns the host an image is downloaded from, or "" for
+// sources that need no download
+func sourceHost(source ImageSource) string {
+	if source.Source != "" || source.Asset != "" || source.URL == "" || source.URL == "null" || isDataURI(source.URL) {
+		return ""
+	}
+	u, err := url.Parse(source.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// fetchRemote downloads url, retrying transient failures as configured in
+// opts, and checks that the response contains the expected kind of content.
+// With a cache, fresh entries are served without a request and stale ones
+// are revalidated.
+func fetchRemote(ctx context.Context, url string, header http.Header, client HTTPClient, kind contentKind, opts FetchOptions) ([]byte, error) {
+	var key string
+	var cached *cacheEntry
+	var cachedData []byte
+	if opts.Cache != nil {
+		key = cacheKey(url, header)
+		if entry, data, ok := opts.Cache.get(key); ok {
+			if entry.fresh(time.Now()) {
+				opts.Cache.count(true)
+				if err := checkContent(kind, entry.ContentType, data); err != nil {
+					return nil, err
+				}
+				return data, nil
+			}
+			cached, cachedData = entry, data
+			header = conditionalHeader(header, entry)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		data, respHeader, err := fetchURL(ctx, url, header, client)
+		if err == nil {
+			if err := checkContent(kind, respHeader.Get("Content-Type"), data); err != nil {
+				return nil, err
+			}
+			if opts.Cache != nil {
+				opts.Cache.count(false)
+				opts.Cache.store(key, url, data, respHeader)
+			}
+			return data, nil
+		}
+
+		var statusErr *statusError
+		if cached != nil && errors.As(err, &statusErr) && statusErr.code == http.StatusNotModified {
+			opts.Cache.refresh(key, statusErr.header)
+			if err := checkContent(kind, cached.ContentType, cachedData); err != nil {
+				return nil, err
+			}
+			return cachedData, nil
+		}
+
+		if attempt >= opts.Retries || ctx.Err() != nil || !retryable(err) {
+			return nil, err
+		}
+		delay, ok := retryDelay(err, attempt, opts)
+		if !ok {
+			return nil, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// retryable reports whether a failed download may succeed when repeated.
+// Refusals by the URL policy, oversized bodies and client errors are final.
+func retryable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus[statusErr.code]
+	}
+	if errors.Is(err, ErrURLNotAllowed) || errors.Is(err, ErrBlockedAddress) ||
+		errors.Is(err, ErrTooManyRedirects) || errors.Is(err, ErrFileTooLarge) {
+		return false
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryDelay returns how long to wait before retry attempt+1, or false if
+// the server asked for a longer wait than opts allow
+func retryDelay(err error, attempt int, opts FetchOptions) (time.Duration, bool) {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.retryAfter > 0 {
+		if opts.MaxBackoff > 0 && statusErr.retryAfter > opts.MaxBackoff {
+			return 0, false
+		}
+		return statusErr.retryAfter, true
+	}
+
+	delay := opts.Backoff << min(attempt, 30)
+	if delay <= 0 || (opts.MaxBackoff > 0 && delay > opts.MaxBackoff) {
+		delay = opts.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0, true
+	}
+
+	// Jitter spreads retries of concurrent downloads over [delay/2, delay]
+	return delay/2 + rand.N(delay/2+1), true
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an
+// HTTP date; it returns zero if the header is missing or invalid
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// checkContent verifies that a download is an image or an ODF package,
+// by its declared Content-Type and its leading bytes. A missing or generic
+// binary Content-Type is accepted if the bytes match.
+func checkContent(kind contentKind, contentType string, data []byte) error {
+	mediaType := ""
+	if contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return fmt.Errorf("%w: invalid Content-Type %q", ErrUnexpectedContent, contentType)
+		}
+		mediaType = parsed
+	}
+	generic := mediaType == "" || mediaType == "application/octet-stream" || mediaType == "binary/octet-stream"
+
+	switch kind {
+	case templateContent:
+		if !generic && !strings.HasPrefix(mediaType, "application/vnd.oasis.opendocument.") &&
+			mediaType != "application/zip" && mediaType != "application/x-zip-compressed" {
+			return fmt.Errorf("%w: %s is not an ODF document", ErrUnexpectedContent, mediaType)
+		}
+		if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+			return fmt.Errorf("%w: response is not a ZIP package", ErrUnexpectedContent)
+		}
+	default:
+		if !generic && !strings.HasPrefix(mediaType, "image/") {
+			return fmt.Errorf("%w: %s is not an image", ErrUnexpectedContent, mediaType)
+		}
+		if detectImageFormat(data) == "" {
+			return fmt.Errorf("%w: response is not a supported image format", ErrUnexpectedContent)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyServer serves its path as the body after a delay and records
+// the highest number of requests in flight
+type concurrencyServer struct {
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+	delay    time.Duration
+}
+
+func (s *concurrencyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.inFlight++
+	s.peak = max(s.peak, s.inFlight)
+	s.mu.Unlock()
+
+	select {
+	case <-time.After(s.delay):
+	case <-r.Context().Done():
+	}
+
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+	w.Write([]byte(testPNG + r.URL.Path))
+}
+
+func TestFetchImages_Limits(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     FetchOptions
+		wantPeak int
+	}{
+		{"workers", FetchOptions{Workers: 3, PerHost: 10}, 3},
+		{"per host", FetchOptions{Workers: 10, PerHost: 2}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &concurrencyServer{delay: 20 * time.Millisecond}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			sources := make(map[string]ImageSource)
+			for i := 0; i < 10; i++ {
+				sources[fmt.Sprintf("tag%d", i)] = ImageSource{URL: fmt.Sprintf("%s/img%d", server.URL, i)}
+			}
+			sources["inline"] = ImageSource{Base64: "iVBORw0KGgo="}
+
+			results := fetchImages(context.Background(), sources, server.Client(), tt.opts, nil)
+			for i := 0; i < 10; i++ {
+				r := results[fmt.Sprintf("tag%d", i)]
+				if want := testPNG + fmt.Sprintf("/img%d", i); r.err != nil || string(r.data) != want {
+					t.Errorf("tag%d = %q, %v; want %q", i, r.data, r.err, want)
+				}
+			}
+			if r := results["inline"]; r.err != nil || len(r.data) == 0 {
+				t.Errorf("inline = %q, %v", r.data, r.err)
+			}
+			if handler.peak > tt.wantPeak {
+				t.Errorf("peak concurrency = %d, want at most %d", handler.peak, tt.wantPeak)
+			}
+			if handler.peak < 2 {
+				t.Errorf("peak concurrency = %d; sources were not fetched in parallel", handler.peak)
+			}
+		})
+	}
+}
+
+func TestFetchImages_Timeout(t *testing.T) {
+	handler := &concurrencyServer{delay: time.Minute}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	sources := map[string]ImageSource{
+		"slow1": {URL: server.URL + "/a"},
+		"slow2": {URL: server.URL + "/b"},
+	}
+	opts := FetchOptions{Workers: 1, PerHost: 1, Timeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	results := fetchImages(context.Background(), sources, server.Client(), opts, nil)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("fetchImages() returned after %v", elapsed)
+	}
+	for tag, r := range results {
+		if !errors.Is(r.err, context.DeadlineExceeded) {
+			t.Errorf("%s error = %v, want DeadlineExceeded", tag, r.err)
+		}
+	}
+}
+
+func TestFetchRemote_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		status       int
+		retryAfter   string
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"recovers from 503", 2, http.StatusServiceUnavailable, "", 3, false},
+		{"gives up after retries", 5, http.StatusBadGateway, "", 3, true},
+		{"honors short Retry-After", 1, http.StatusTooManyRequests, "1", 2, false},
+		{"long Retry-After ends retries", 1, http.StatusServiceUnavailable, "120", 1, true},
+		{"client errors are final", 1, http.StatusNotFound, "", 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts++
+				n := attempts
+				mu.Unlock()
+				if n <= tt.failures {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Header().Set("Content-Type", "image/png")
+				w.Write([]byte(testPNG))
+			}))
+			defer server.Close()
+
+			opts := FetchOptions{Retries: 2, Backoff: time.Millisecond, MaxBackoff: 2 * time.Second}
+			data, err := fetchRemote(context.Background(), server.URL, nil, server.Client(), imageContent, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchRemote() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(data) != testPNG {
+				t.Errorf("fetchRemote() = %q", data)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestCheckContent(t *testing.T) {
+	odt := "PK\x03\x04\x14\x00"
+
+	tests := []struct {
+		name        string
+		kind        contentKind
+		contentType string
+		data        string
+		wantErr     bool
+	}{
+		{"png", imageContent, "image/png", testPNG, false},
+		{"image without type", imageContent, "", testPNG, false},
+		{"image as octet-stream", imageContent, "application/octet-stream", testPNG, false},
+		{"html error page", imageContent, "text/html; charset=utf-8", "<html>", true},
+		{"image type with text body", imageContent, "image/png", "not an image", true},
+		{"odt", templateContent, "application/vnd.oasis.opendocument.text", odt, false},
+		{"odt as zip", templateContent, "application/zip", odt, false},
+		{"template as image", templateContent, "image/png", testPNG, true},
+		{"template type with html body", templateContent, "application/vnd.oasis.opendocument.text", "<html>", true},
+		{"invalid type", imageContent, "image/png; =", testPNG, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkContent(tt.kind, tt.contentType, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkContent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrUnexpectedContent) {
+				t.Errorf("checkContent() error = %v, want ErrUnexpectedContent", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,610 @@
+package odtimagereplacer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobStatus is the state of an asynchronous job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// finished reports whether a job in status s will not change any more
+func (s JobStatus) finished() bool {
+	return s == JobSucceeded || s == JobFailed
+}
+
+// JobRequest is a replace request processed in the background
+type JobRequest struct {
+	ReplaceRequest
+	// Webhook receives the finished job as a signed JSON POST
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// Job describes an asynchronous replace request and its outcome
+type Job struct {
+	ID      string    `json:"id"`
+	Status  JobStatus `json:"status"`
+	Webhook string    `json:"webhook,omitempty"`
+	// Result is the replace response, without the output document
+	Result   *ReplaceResponse `json:"result,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	Created  time.Time        `json:"created"`
+	Started  *time.Time       `json:"started,omitempty"`
+	Finished *time.Time       `json:"finished,omitempty"`
+	// WebhookError is the last failure delivering the webhook
+	WebhookError string `json:"webhook_error,omitempty"`
+	// WebhookErrorCode classifies WebhookError, e.g. "blocked_address"
+	WebhookErrorCode string `json:"webhook_error_code,omitempty"`
+	WebhookDelivered bool   `json:"webhook_delivered,omitempty"`
+}
+
+// JobOptions configures a JobQueue
+type JobOptions struct {
+	// Workers is the number of jobs processed at the same time
+	Workers int
+	// QueueSize limits the jobs waiting for a worker; further submissions
+	// fail with ErrQueueFull
+	QueueSize int
+	// Timeout limits the processing of one job (0 for none)
+	Timeout time.Duration
+	// Retention is how long finished jobs and their outputs are kept
+	// (0 keeps them until deleted)
+	Retention time.Duration
+	// WebhookSecret signs webhook payloads with HMAC-SHA256; unsigned if empty
+	WebhookSecret string
+	// WebhookRetries is the number of further attempts after a failed delivery
+	WebhookRetries int
+	// Client downloads templates and images and delivers webhooks;
+	// DefaultHTTPClient if nil. Webhook URLs are checked against its
+	// URLPolicy on submit if it was made by NewHTTPClient.
+	Client HTTPClient
+}
+
+// DefaultJobOptions are the options of the job queue of the API server
+var DefaultJobOptions = JobOptions{
+	Workers:        4,
+	QueueSize:      100,
+	Timeout:        10 * time.Minute,
+	Retention:      24 * time.Hour,
+	WebhookRetries: 3,
+}
+
+// DefaultJobs processes the jobs of the /api/jobs endpoints. It is nil
+// unless configured.
+var DefaultJobs *JobQueue
+
+// JobQueue processes replace requests with a bounded pool of workers. Jobs
+// and their outputs are kept in a Storage; with a FileStorage, jobs that
+// were queued or running when the process stopped are resumed by the next
+// NewJobQueue. Passwords and headers of a request are only kept in memory,
+// so a resumed job that needs them fails. A JobQueue is safe for
+// concurrent use.
+type JobQueue struct {
+	storage Storage
+	opts    JobOptions
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []string
+	secrets map[string]jobSecrets
+	closed  bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func jobKey(id, ext string) string {
+	return "jobs/" + id + "." + ext
+}
+
+// storedJobRequest is a job request as stored, without its secrets
+type storedJobRequest struct {
+	JobRequest
+	// Secrets reports that the request had secrets kept in memory
+	Secrets bool `json:"secrets,omitempty"`
+}
+
+// jobSecrets are the parts of a job request that are never stored: the
+// template password and the headers, which may carry tokens
+type jobSecrets struct {
+	password        string
+	templateHeaders map[string]string
+	imageHeaders    map[string]map[string]string
+}
+
+// splitSecrets returns req without its secrets, and the secrets
+func splitSecrets(req JobRequest) (JobRequest, jobSecrets) {
+	secrets := jobSecrets{
+		password:        req.Template.Password,
+		templateHeaders: req.Template.Headers,
+		imageHeaders:    make(map[string]map[string]string),
+	}
+	req.Template.Password, req.Template.Headers = "", nil
+
+	data := make(map[string]ImageSource, len(req.Data))
+	for tag, source := range req.Data {
+		if source.Headers != nil {
+			secrets.imageHeaders[tag] = source.Headers
+			source.Headers = nil
+		}
+		data[tag] = source
+	}
+	req.Data = data
+	return req, secrets
+}
+
+func (s jobSecrets) empty() bool {
+	return s.password == "" && len(s.templateHeaders) == 0 && len(s.imageHeaders) == 0
+}
+
+// restore puts the secrets back into req
+func (s jobSecrets) restore(req *JobRequest) {
+	req.Template.Password, req.Template.Headers = s.password, s.templateHeaders
+	for tag, headers := range s.imageHeaders {
+		source := req.Data[tag]
+		source.Headers = headers
+		req.Data[tag] = source
+	}
+}
+
+// NewJobQueue starts a queue keeping jobs in storage and resumes the
+// unfinished jobs found there
+func NewJobQueue(storage Storage, opts JobOptions) (*JobQueue, error) {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.Client == nil {
+		opts.Client = DefaultHTTPClient
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &JobQueue{storage: storage, opts: opts, secrets: make(map[string]jobSecrets), ctx: ctx, cancel: cancel}
+	q.cond = sync.NewCond(&q.mu)
+
+	if err := q.resume(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	if opts.Retention > 0 {
+		q.wg.Add(1)
+		go q.expire()
+	}
+	return q, nil
+}
+
+// resume queues the stored jobs that have not finished, oldest first
+func (q *JobQueue) resume() error {
+	jobs, err := q.list(q.ctx)
+	if err != nil {
+		return fmt.Errorf("load jobs: %w", err)
+	}
+
+	for _, job := range jobs {
+		if job.Status.finished() {
+			continue
+		}
+		// Jobs interrupted while running start over
+		if job.Status == JobRunning {
+			job.Status = JobQueued
+			job.Started = nil
+			if err := q.put(q.ctx, &job); err != nil {
+				return err
+			}
+		}
+		q.pending = append(q.pending, job.ID)
+	}
+	return nil
+}
+
+// list returns all stored jobs ordered by creation
+func (q *JobQueue) list(ctx context.Context) ([]Job, error) {
+	keys, err := q.storage.List(ctx, "jobs/")
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []Job
+	for _, key := range keys {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(key, "jobs/"), ".json")
+		if !ok {
+			continue
+		}
+		job, err := q.Get(ctx, id)
+		if errors.Is(err, ErrJobNotFound) {
+			continue // expired concurrently
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	return jobs, nil
+}
+
+// Submit stores req as a new queued job. The template password and the
+// headers are kept in memory only.
+func (q *JobQueue) Submit(ctx context.Context, req JobRequest) (*Job, error) {
+	if len(req.Data) == 0 {
+		return nil, fmt.Errorf("no image data provided")
+	}
+	if req.Webhook != "" {
+		u, err := url.Parse(req.Webhook)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid webhook URL: %v", ErrURLNotAllowed, err)
+		}
+		// Clients without a policy of their own enforce it, if at all, on delivery
+		if policy, ok := clientURLPolicy(q.opts.Client); ok {
+			if err := policy.checkURL(u.Scheme, u.Hostname()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, fmt.Errorf("%w: queue is closed", ErrQueueFull)
+	}
+	if q.opts.QueueSize > 0 && len(q.pending) >= q.opts.QueueSize {
+		return nil, fmt.Errorf("%w: %d jobs waiting", ErrQueueFull, len(q.pending))
+	}
+
+	job := &Job{
+		ID:      rand.Text(),
+		Status:  JobQueued,
+		Webhook: req.Webhook,
+		Created: time.Now().UTC(),
+	}
+	stored, secrets := splitSecrets(req)
+	body, err := json.Marshal(storedJobRequest{JobRequest: stored, Secrets: !secrets.empty()})
+	if err != nil {
+		return nil, fmt.Errorf("encode job request: %w", err)
+	}
+
+	// The job is written last; a request without it is never processed
+	if err := q.storage.Put(ctx, jobKey(job.ID, "request"), body); err != nil {
+		return nil, fmt.Errorf("store job request: %w", err)
+	}
+	if err := q.put(ctx, job); err != nil {
+		q.storage.Delete(ctx, jobKey(job.ID, "request"))
+		return nil, err
+	}
+
+	if !secrets.empty() {
+		q.secrets[job.ID] = secrets
+	}
+	q.pending = append(q.pending, job.ID)
+	q.cond.Signal()
+	return job, nil
+}
+
+// Get returns job id
+func (q *JobQueue) Get(ctx context.Context, id string) (*Job, error) {
+	if !storageIDRegex.MatchString(id) {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	data, err := q.storage.Get(ctx, jobKey(id, "json"))
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("decode job %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+// Output returns the document produced by a succeeded job
+func (q *JobQueue) Output(ctx context.Context, id string) ([]byte, error) {
+	job, err := q.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != JobSucceeded {
+		return nil, fmt.Errorf("%w: job %s is %s", ErrJobNotFinished, id, job.Status)
+	}
+
+	data, err := q.storage.Get(ctx, jobKey(id, "odt"))
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, fmt.Errorf("%w: output of %s", ErrJobNotFound, id)
+	}
+	return data, err
+}
+
+// Delete removes a finished job and its output
+func (q *JobQueue) Delete(ctx context.Context, id string) error {
+	job, err := q.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !job.Status.finished() {
+		return fmt.Errorf("%w: job %s is %s", ErrJobNotFinished, id, job.Status)
+	}
+	return q.remove(ctx, id)
+}
+
+func (q *JobQueue) remove(ctx context.Context, id string) error {
+	q.mu.Lock()
+	delete(q.secrets, id)
+	q.mu.Unlock()
+
+	for _, ext := range []string{"json", "request", "odt"} {
+		if err := q.storage.Delete(ctx, jobKey(id, ext)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *JobQueue) put(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("encode job: %w", err)
+	}
+	if err := q.storage.Put(ctx, jobKey(job.ID, "json"), data); err != nil {
+		return fmt.Errorf("store job: %w", err)
+	}
+	return nil
+}
+
+// Close stops the workers and waits for them. Jobs that were running are
+// queued again, so a queue on the same storage resumes them.
+func (q *JobQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	q.cancel()
+	q.wg.Wait()
+}
+
+// next waits for a queued job; it returns false once the queue is closed
+func (q *JobQueue) next() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return "", false
+	}
+	id := q.pending[0]
+	q.pending = q.pending[1:]
+	return id, true
+}
+
+func (q *JobQueue) work() {
+	defer q.wg.Done()
+	for {
+		id, ok := q.next()
+		if !ok {
+			return
+		}
+		q.run(id)
+	}
+}
+
+// run processes job id and delivers its webhook
+func (q *JobQueue) run(id string) {
+	job, err := q.Get(q.ctx, id)
+	if err != nil {
+		return
+	}
+	started := time.Now().UTC()
+	job.Status, job.Started = JobRunning, &started
+	if err := q.put(q.ctx, job); err != nil {
+		return
+	}
+
+	var response *ReplaceResponse
+	var output []byte
+	req, err := q.request(id)
+	if err == nil {
+		ctx, cancel := q.ctx, context.CancelFunc(func() {})
+		if q.opts.Timeout > 0 {
+			ctx, cancel = context.WithTimeout(q.ctx, q.opts.Timeout)
+		}
+		response, output, err = ProcessReplaceRequestWithClientContext(ctx, req.ReplaceRequest, q.opts.Client)
+		cancel()
+	}
+
+	// Interrupted by Close: leave the job for the next queue
+	if q.ctx.Err() != nil {
+		job.Status, job.Started = JobQueued, nil
+		q.put(context.Background(), job)
+		return
+	}
+
+	job.Result = response
+	switch {
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+		if response != nil && response.Error != "" {
+			job.Error = response.Error
+		}
+	default:
+		if err := q.storage.Put(q.ctx, jobKey(id, "odt"), output); err != nil {
+			job.Status, job.Error = JobFailed, fmt.Sprintf("store output: %v", err)
+		} else {
+			job.Status = JobSucceeded
+		}
+	}
+	finished := time.Now().UTC()
+	job.Finished = &finished
+	if err := q.put(q.ctx, job); err != nil {
+		return
+	}
+
+	// The request is not needed once the job has finished
+	q.mu.Lock()
+	delete(q.secrets, id)
+	q.mu.Unlock()
+	q.storage.Delete(q.ctx, jobKey(id, "request"))
+
+	if job.Webhook != "" {
+		if err := q.deliver(job); err != nil {
+			job.WebhookError = err.Error()
+			job.WebhookErrorCode = fetchErrorCode(err)
+		} else {
+			job.WebhookDelivered = true
+		}
+		q.put(q.ctx, job)
+	}
+}
+
+// request loads the request of job id and restores its secrets
+func (q *JobQueue) request(id string) (JobRequest, error) {
+	body, err := q.storage.Get(q.ctx, jobKey(id, "request"))
+	if err != nil {
+		return JobRequest{}, fmt.Errorf("load job request: %w", err)
+	}
+	var stored storedJobRequest
+	if err := json.Unmarshal(body, &stored); err != nil {
+		return JobRequest{}, fmt.Errorf("decode job request: %w", err)
+	}
+
+	q.mu.Lock()
+	secrets, ok := q.secrets[id]
+	q.mu.Unlock()
+	if stored.Secrets && !ok {
+		return JobRequest{}, fmt.Errorf("the password and headers of the job were lost when the server restarted; submit it again")
+	}
+	secrets.restore(&stored.JobRequest)
+	return stored.JobRequest, nil
+}
+
+// SignWebhook returns the signature of a webhook payload sent at
+// timestamp (Unix seconds): hex HMAC-SHA256 of "<timestamp>.<payload>"
+func SignWebhook(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts job to its webhook, retrying failures with backoff
+func (q *JobQueue) deliver(job *Job) error {
+	client, ok := q.opts.Client.(requestDoer)
+	if !ok {
+		return fmt.Errorf("HTTP client does not support webhooks")
+	}
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("encode webhook payload: %w", err)
+	}
+
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		err = q.post(client, job, payload)
+		if err == nil || attempt >= q.opts.WebhookRetries {
+			return err
+		}
+		// Policy refusals do not change on retry
+		if errors.Is(err, ErrURLNotAllowed) || errors.Is(err, ErrBlockedAddress) {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-q.ctx.Done():
+			timer.Stop()
+			return q.ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (q *JobQueue) post(client requestDoer, job *Job, payload []byte) error {
+	ctx, cancel := context.WithTimeout(q.ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.Webhook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Job-ID", job.ID)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	if q.opts.WebhookSecret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhook(q.opts.WebhookSecret, timestamp, payload))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("deliver webhook: HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// expire removes finished jobs older than Retention
+func (q *JobQueue) expire() {
+	defer q.wg.Done()
+
+	interval := min(q.opts.Retention/10, time.Hour)
+	ticker := time.NewTicker(max(interval, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.ctx.Done():
+			return
+		}
+
+		jobs, err := q.list(q.ctx)
+		if err != nil {
+			continue
+		}
+		cutoff := time.Now().Add(-q.opts.Retention)
+		for _, job := range jobs {
+			if job.Finished != nil && job.Finished.Before(cutoff) {
+				q.remove(q.ctx, job.ID)
+			}
+		}
+	}
+}
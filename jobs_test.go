@@ -0,0 +1,332 @@
+package odtimagereplacer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func jobTestRequest(t *testing.T) JobRequest {
+	t.Helper()
+	return JobRequest{ReplaceRequest: ReplaceRequest{
+		Template: TemplateSource{Base64: base64.StdEncoding.EncodeToString(templateTestData(t))},
+		Data:     map[string]ImageSource{"image1": {Base64: base64.StdEncoding.EncodeToString([]byte(testPNG))}},
+	}}
+}
+
+// waitJob polls until job id has finished
+func waitJob(t *testing.T, q *JobQueue, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := q.Get(context.Background(), id)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if job.Status.finished() && (job.Webhook == "" || job.WebhookDelivered || job.WebhookError != "") {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return nil
+}
+
+func TestJobQueue(t *testing.T) {
+	storage := NewMemoryStorage()
+	q, err := NewJobQueue(storage, JobOptions{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	ctx := context.Background()
+
+	job, err := q.Submit(ctx, jobTestRequest(t))
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if job.Status != JobQueued || !storageIDRegex.MatchString(job.ID) {
+		t.Errorf("Submit() = %+v", job)
+	}
+	if _, err := q.Output(ctx, job.ID); err != nil && !errors.Is(err, ErrJobNotFinished) {
+		t.Errorf("Output() before finishing error = %v", err)
+	}
+
+	job = waitJob(t, q, job.ID)
+	if job.Status != JobSucceeded || job.Result == nil || len(job.Result.ReplacedTags) != 1 {
+		t.Fatalf("finished job = %+v", job)
+	}
+	output, err := q.Output(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewODTDocumentFromBytes(output); err != nil {
+		t.Errorf("output is not an ODT: %v", err)
+	}
+	if _, err := storage.Get(ctx, jobKey(job.ID, "request")); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("request of finished job: error = %v, want ErrObjectNotFound", err)
+	}
+
+	// Failed jobs keep the reason
+	req := jobTestRequest(t)
+	req.Template.Base64 = base64.StdEncoding.EncodeToString([]byte("not a zip"))
+	failed, err := q.Submit(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed = waitJob(t, q, failed.ID); failed.Status != JobFailed || failed.Error == "" {
+		t.Errorf("failed job = %+v", failed)
+	}
+	if _, err := q.Output(ctx, failed.ID); !errors.Is(err, ErrJobNotFinished) {
+		t.Errorf("Output() of failed job error = %v, want ErrJobNotFinished", err)
+	}
+
+	if err := q.Delete(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Get(ctx, job.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestJobQueue_SubmitInvalid(t *testing.T) {
+	q, err := NewJobQueue(NewMemoryStorage(), JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	noData := jobTestRequest(t)
+	noData.Data = nil
+	badWebhook := jobTestRequest(t)
+	badWebhook.Webhook = "ftp://example.com/hook"
+
+	for name, req := range map[string]JobRequest{"no data": noData, "webhook scheme": badWebhook} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := q.Submit(context.Background(), req); err == nil {
+				t.Error("Submit() succeeded, want error")
+			}
+		})
+	}
+	if _, err := q.Submit(context.Background(), badWebhook); !errors.Is(err, ErrURLNotAllowed) {
+		t.Errorf("Submit(ftp webhook) error = %v, want ErrURLNotAllowed", err)
+	}
+}
+
+func TestJobQueue_WebhookClientPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// The webhook host is allowed by DefaultURLPolicy but not by the client
+	policy := DefaultURLPolicy
+	policy.AllowedHosts = []string{"127.0.0.1", "hooks.example.com"}
+	q, err := NewJobQueue(NewMemoryStorage(), JobOptions{Client: NewHTTPClient(policy, 5*time.Second), WebhookRetries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	req := jobTestRequest(t)
+	req.Webhook = "https://example.com/hook"
+	if _, err := q.Submit(context.Background(), req); !errors.Is(err, ErrURLNotAllowed) {
+		t.Errorf("Submit(example.com webhook) error = %v, want ErrURLNotAllowed", err)
+	}
+
+	// Loopback passes the host check and is blocked when connecting
+	req.Webhook = server.URL + "/done"
+	job, err := q.Submit(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if job := waitJob(t, q, job.ID); job.WebhookDelivered || job.WebhookErrorCode != CodeBlockedAddress {
+		t.Errorf("job = %+v, want webhook_error_code %s", job, CodeBlockedAddress)
+	}
+}
+
+// putRecorder records the objects put into a Storage
+type putRecorder struct {
+	Storage
+	mu   sync.Mutex
+	puts map[string][]byte
+}
+
+func (r *putRecorder) Put(ctx context.Context, key string, data []byte) error {
+	r.mu.Lock()
+	r.puts[key] = data
+	r.mu.Unlock()
+	return r.Storage.Put(ctx, key, data)
+}
+
+func TestJobQueue_SecretsNotStored(t *testing.T) {
+	storage := &putRecorder{Storage: NewMemoryStorage(), puts: make(map[string][]byte)}
+	q, err := NewJobQueue(storage, JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	req := jobTestRequest(t)
+	req.Template.Headers = map[string]string{"Authorization": "Bearer template-token"}
+	image := req.Data["image1"]
+	image.Headers = map[string]string{"X-Api-Key": "image-token"}
+	req.Data["image1"] = image
+
+	job, err := q.Submit(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job = waitJob(t, q, job.ID); job.Status != JobSucceeded {
+		t.Errorf("job with headers = %+v", job)
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	for key, data := range storage.puts {
+		for _, secret := range []string{"template-token", "image-token"} {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("%s contains %q", key, secret)
+			}
+		}
+	}
+	if req.Data["image1"].Headers == nil {
+		t.Error("Submit() modified the request")
+	}
+}
+
+func TestJobQueue_Webhook(t *testing.T) {
+	const secret = "s3cret"
+	received := make(chan *http.Request, 1)
+	var payload []byte
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first delivery fails and is retried
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		payload, _ = io.ReadAll(r.Body)
+		received <- r
+	}))
+	defer server.Close()
+
+	q, err := NewJobQueue(NewMemoryStorage(), JobOptions{
+		WebhookSecret:  secret,
+		WebhookRetries: 1,
+		Client:         server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	req := jobTestRequest(t)
+	req.Webhook = server.URL + "/done"
+	job, err := q.Submit(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r *http.Request
+	select {
+	case r = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get("X-Webhook-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.Header.Get("X-Webhook-Signature"), "sha256="+SignWebhook(secret, timestamp, payload); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	var delivered Job
+	if err := json.Unmarshal(payload, &delivered); err != nil || delivered.ID != job.ID || delivered.Status != JobSucceeded {
+		t.Errorf("payload = %s, %v", payload, err)
+	}
+	if job := waitJob(t, q, job.ID); !job.WebhookDelivered {
+		t.Errorf("job after delivery = %+v", job)
+	}
+}
+
+func TestJobQueue_Resume(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// A job that was running when the previous process stopped
+	body, _ := json.Marshal(jobTestRequest(t))
+	started := time.Now()
+	job, _ := json.Marshal(Job{ID: "INTERRUPTED", Status: JobRunning, Created: started, Started: &started})
+	storage.Put(ctx, jobKey("INTERRUPTED", "request"), body)
+	storage.Put(ctx, jobKey("INTERRUPTED", "json"), job)
+
+	// A job whose secrets were only in the memory of that process
+	body, _ = json.Marshal(storedJobRequest{JobRequest: jobTestRequest(t), Secrets: true})
+	job, _ = json.Marshal(Job{ID: "SECRETS", Status: JobQueued, Created: started})
+	storage.Put(ctx, jobKey("SECRETS", "request"), body)
+	storage.Put(ctx, jobKey("SECRETS", "json"), job)
+
+	q, err := NewJobQueue(storage, JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if resumed := waitJob(t, q, "INTERRUPTED"); resumed.Status != JobSucceeded {
+		t.Errorf("resumed job = %+v", resumed)
+	}
+	if lost := waitJob(t, q, "SECRETS"); lost.Status != JobFailed || !strings.Contains(lost.Error, "submit it again") {
+		t.Errorf("resumed job without its secrets = %+v", lost)
+	}
+}
+
+func TestJobEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(q *JobQueue) { DefaultJobs = q }(DefaultJobs)
+	q, err := NewJobQueue(NewMemoryStorage(), JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	DefaultJobs = q
+	router := SetupRouter()
+
+	body, _ := json.Marshal(jobTestRequest(t))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/jobs", bytes.NewReader(body)))
+	var submitted JobResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &submitted); w.Code != http.StatusAccepted || err != nil || submitted.Job == nil {
+		t.Fatalf("submit = %d %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Location"); got != "/api/jobs/"+submitted.Job.ID {
+		t.Errorf("Location = %q", got)
+	}
+
+	waitJob(t, q, submitted.Job.ID)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/jobs/"+submitted.Job.ID+"/output", nil))
+	if w.Code != http.StatusOK || !bytes.HasPrefix(w.Body.Bytes(), []byte("PK")) {
+		t.Errorf("output = %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/jobs/UNKNOWN", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown job status = %d, want 404", w.Code)
+	}
+}
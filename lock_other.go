@@ -0,0 +1,15 @@
+//go:build !unix
+
+package odtimagereplacer
+
+import "os"
+
+// lockFile is a no-op on platforms without flock
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile is a no-op on platforms without flock
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,531 @@
+package odtimagereplacer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// nsManifest is the OpenDocument manifest namespace
+const nsManifest = "urn:oasis:names:tc:opendocument:xmlns:manifest:1.0"
+
+// manifestPath is the location of the manifest inside the package
+const manifestPath = "META-INF/manifest.xml"
+
+// ManifestEntry is a single manifest:file-entry
+type ManifestEntry struct {
+	FullPath  string `json:"full_path"`
+	MediaType string `json:"media_type"`
+	Version   string `json:"version,omitempty"`
+
+	attrs []xml.Attr // other attributes, with raw prefixes
+	inner string     // raw child XML such as manifest:encryption-data
+}
+
+// ManifestChange describes a modification made while repairing a manifest
+type ManifestChange struct {
+	Path      string `json:"path"`
+	Action    string `json:"action"`
+	MediaType string `json:"media_type,omitempty"`
+}
+
+// Manifest repair actions
+const (
+	ManifestAdded     = "added"
+	ManifestRemoved   = "removed"
+	ManifestMediaType = "media-type"
+)
+
+// Manifest is the parsed META-INF/manifest.xml. Unknown attributes and
+// child elements are preserved so that re-serializing keeps information
+// such as encryption data intact.
+type Manifest struct {
+	Entries []ManifestEntry
+
+	prefix    string     // prefix bound to the manifest namespace
+	rootAttrs []xml.Attr // attributes of manifest:manifest, with raw prefixes
+
+	index   map[string]int // position of the first entry of each path
+	indexed int            // len(Entries) when the index was built
+}
+
+// newManifest creates an empty manifest for the given package media type
+func newManifest(mediaType string) *Manifest {
+	m := &Manifest{
+		prefix: "manifest",
+		rootAttrs: []xml.Attr{
+			{Name: xml.Name{Space: "xmlns", Local: "manifest"}, Value: nsManifest},
+			{Name: xml.Name{Space: "manifest", Local: "version"}, Value: "1.2"},
+		},
+		Entries: []ManifestEntry{
+			{FullPath: "/", MediaType: mediaType, Version: "1.2"},
+		},
+	}
+	m.reindex()
+	return m
+}
+
+// parseManifest parses META-INF/manifest.xml into a Manifest
+func parseManifest(data []byte) (*Manifest, error) {
+	// Check well-formedness first; RawToken does not match end elements
+	strict := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		if _, err := strict.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("parse manifest: %w", err)
+		}
+	}
+
+	m := &Manifest{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	found := false
+	var entry *ManifestEntry
+	var innerStart int64
+
+	for {
+		offset := decoder.InputOffset()
+		tok, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse manifest: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 1:
+				if t.Name.Local != "manifest" {
+					return nil, fmt.Errorf("parse manifest: unexpected root element %s", t.Name.Local)
+				}
+				found = true
+				m.rootAttrs = t.Attr
+				m.prefix = namespacePrefix(t.Attr, nsManifest)
+			case depth == 2 && t.Name.Space == m.prefix && t.Name.Local == "file-entry":
+				entry = &ManifestEntry{}
+				for _, a := range t.Attr {
+					if a.Name.Space != m.prefix {
+						entry.attrs = append(entry.attrs, a)
+						continue
+					}
+					switch a.Name.Local {
+					case "full-path":
+						entry.FullPath = a.Value
+					case "media-type":
+						entry.MediaType = a.Value
+					case "version":
+						entry.Version = a.Value
+					default:
+						entry.attrs = append(entry.attrs, a)
+					}
+				}
+				innerStart = decoder.InputOffset()
+			}
+		case xml.EndElement:
+			if depth == 2 && entry != nil {
+				entry.inner = strings.TrimSpace(string(data[innerStart:max(innerStart, offset)]))
+				m.Entries = append(m.Entries, *entry)
+				entry = nil
+			}
+			depth--
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("parse manifest: missing manifest:manifest element")
+	}
+	m.reindex()
+	return m, nil
+}
+
+// namespacePrefix returns the prefix bound to uri by xmlns attributes
+func namespacePrefix(attrs []xml.Attr, uri string) string {
+	for _, a := range attrs {
+		if a.Name.Space == "xmlns" && a.Value == uri {
+			return a.Name.Local
+		}
+	}
+	return ""
+}
+
+// reindex rebuilds the path index after Entries was replaced
+func (m *Manifest) reindex() {
+	m.index = make(map[string]int, len(m.Entries))
+	for i := range m.Entries {
+		if _, ok := m.index[m.Entries[i].FullPath]; !ok {
+			m.index[m.Entries[i].FullPath] = i
+		}
+	}
+	m.indexed = len(m.Entries)
+}
+
+// Entry returns the entry for path, or nil if it is not listed
+func (m *Manifest) Entry(path string) *ManifestEntry {
+	if m.index != nil && m.indexed == len(m.Entries) {
+		i, ok := m.index[path]
+		if !ok {
+			return nil
+		}
+		if m.Entries[i].FullPath == path {
+			return &m.Entries[i]
+		}
+	}
+
+	// The index is stale if Entries was changed directly
+	for i := range m.Entries {
+		if m.Entries[i].FullPath == path {
+			return &m.Entries[i]
+		}
+	}
+	return nil
+}
+
+// Set adds an entry for path or updates its media type. It reports
+// whether the manifest changed.
+func (m *Manifest) Set(path, mediaType string) bool {
+	if entry := m.Entry(path); entry != nil {
+		if entry.MediaType == mediaType {
+			return false
+		}
+		entry.MediaType = mediaType
+		return true
+	}
+
+	if m.index != nil && m.indexed == len(m.Entries) {
+		m.index[path] = len(m.Entries)
+		m.indexed++
+	}
+	m.Entries = append(m.Entries, ManifestEntry{FullPath: path, MediaType: mediaType})
+	return true
+}
+
+// Remove deletes the entry for path and reports whether it existed
+func (m *Manifest) Remove(path string) bool {
+	for i := range m.Entries {
+		if m.Entries[i].FullPath == path {
+			m.Entries = slices.Delete(m.Entries, i, i+1)
+			m.reindex()
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a deep copy of the manifest
+func (m *Manifest) clone() *Manifest {
+	c := &Manifest{
+		prefix:    m.prefix,
+		rootAttrs: slices.Clone(m.rootAttrs),
+		Entries:   make([]ManifestEntry, len(m.Entries)),
+	}
+	for i, entry := range m.Entries {
+		entry.attrs = slices.Clone(entry.attrs)
+		c.Entries[i] = entry
+	}
+	c.reindex()
+	return c
+}
+
+// Bytes serializes the manifest
+func (m *Manifest) Bytes() []byte {
+	var buf bytes.Buffer
+	name := func(local string) string {
+		if m.prefix == "" {
+			return local
+		}
+		return m.prefix + ":" + local
+	}
+
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	buf.WriteString("<" + name("manifest"))
+	writeAttrs(&buf, m.rootAttrs)
+	buf.WriteString(">\n")
+
+	for _, entry := range m.Entries {
+		buf.WriteString(" <" + name("file-entry"))
+		writeAttr(&buf, name("full-path"), entry.FullPath)
+		if entry.Version != "" {
+			writeAttr(&buf, name("version"), entry.Version)
+		}
+		writeAttr(&buf, name("media-type"), entry.MediaType)
+		writeAttrs(&buf, entry.attrs)
+
+		if entry.inner == "" {
+			buf.WriteString("/>\n")
+			continue
+		}
+		buf.WriteString(">\n  " + entry.inner + "\n </" + name("file-entry") + ">\n")
+	}
+
+	buf.WriteString("</" + name("manifest") + ">\n")
+	return buf.Bytes()
+}
+
+// writeAttrs writes raw-prefixed attributes
+func writeAttrs(buf *bytes.Buffer, attrs []xml.Attr) {
+	for _, a := range attrs {
+		attrName := a.Name.Local
+		if a.Name.Space != "" {
+			attrName = a.Name.Space + ":" + a.Name.Local
+		}
+		writeAttr(buf, attrName, a.Value)
+	}
+}
+
+// writeAttr writes a single escaped attribute
+func writeAttr(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(" " + name + `="`)
+	xml.EscapeText(buf, []byte(value))
+	buf.WriteString(`"`)
+}
+
+// mediaTypeFor returns the media type a part should be listed with, based
+// on its extension for XML parts and its content for images. It returns ""
+// when the type cannot be determined.
+func mediaTypeFor(name string, data []byte) string {
+	if mediaType := xmlMediaType(name); mediaType != "" {
+		return mediaType
+	}
+
+	if format := detectImageFormat(data); format != "" {
+		return detectMIMEType("image" + format)
+	}
+
+	if mimeType := detectMIMEType(name); mimeType != "application/octet-stream" {
+		return mimeType
+	}
+	return ""
+}
+
+// xmlMediaType returns the media type of XML parts based on their name
+func xmlMediaType(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".xml"):
+		return "text/xml"
+	case strings.HasSuffix(name, ".rdf"):
+		return "application/rdf+xml"
+	}
+	return ""
+}
+
+// partMediaType returns the media type for a stored part, reading its
+// content only when the name alone is not conclusive
+func (doc *ODTDocument) partMediaType(name string) (string, error) {
+	if mediaType := xmlMediaType(name); mediaType != "" {
+		return mediaType, nil
+	}
+
+	data, err := doc.readFile(name)
+	if err != nil {
+		return "", err
+	}
+	return mediaTypeFor(name, data), nil
+}
+
+// loadManifest returns the parsed manifest. The result is shared and must
+// be cloned before it is modified; changes are saved with storeManifest.
+func (doc *ODTDocument) loadManifest() (*Manifest, error) {
+	if doc.manifest != nil {
+		return doc.manifest, nil
+	}
+
+	doc.cache.mu.Lock()
+	m := doc.cache.manifest
+	doc.cache.mu.Unlock()
+	if m != nil {
+		return m, nil
+	}
+
+	data, err := doc.getFile(manifestPath)
+	if err != nil {
+		return nil, ErrManifestNotFound
+	}
+
+	m, err = parseManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidODT, err)
+	}
+
+	doc.cache.mu.Lock()
+	doc.cache.manifest = m
+	doc.cache.mu.Unlock()
+	return m, nil
+}
+
+// storeManifest writes the manifest model back to the package
+func (doc *ODTDocument) storeManifest(m *Manifest) {
+	doc.manifest = m
+	doc.setFile(manifestPath, m.Bytes())
+}
+
+// Manifest returns a copy of the package manifest entries
+func (doc *ODTDocument) Manifest() ([]ManifestEntry, error) {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	m, err := doc.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	return m.clone().Entries, nil
+}
+
+// updateManifestEntry adds or corrects the manifest entry for a part
+func (doc *ODTDocument) updateManifestEntry(path string, data []byte) error {
+	m, err := doc.loadManifest()
+	if err != nil {
+		return err
+	}
+
+	mediaType := mediaTypeFor(path, data)
+	if mediaType == "" {
+		mediaType = detectMIMEType(path)
+	}
+
+	if m = m.clone(); m.Set(path, mediaType) {
+		doc.storeManifest(m)
+	}
+	return nil
+}
+
+// RemoveFile removes a part from the package along with its manifest entry
+func (doc *ODTDocument) RemoveFile(path string) error {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	return doc.removeFile(path)
+}
+
+// removeFile removes a part and its manifest entry
+func (doc *ODTDocument) removeFile(path string) error {
+	if path == "mimetype" || path == manifestPath {
+		return fmt.Errorf("%w: %s cannot be removed", ErrInvalidPath, path)
+	}
+	if !doc.pkg.exists(path) {
+		return fmt.Errorf("file %s not found in archive", path)
+	}
+	if err := doc.checkSignatures(path, manifestPath); err != nil {
+		return err
+	}
+
+	if m, err := doc.loadManifest(); err == nil {
+		if m = m.clone(); m.Remove(path) {
+			doc.storeManifest(m)
+		}
+	}
+
+	doc.deleteFile(path)
+	return nil
+}
+
+// RepairManifest adds entries for unlisted parts, removes entries for
+// missing parts and corrects media types. A manifest is created if the
+// package has none. It returns the changes made.
+func (doc *ODTDocument) RepairManifest() ([]ManifestChange, error) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	m, err := doc.loadManifest()
+	changes := make([]ManifestChange, 0)
+
+	switch {
+	case errors.Is(err, ErrManifestNotFound):
+		mimetype, _ := doc.readFile("mimetype")
+		m = newManifest(string(mimetype))
+		changes = append(changes, ManifestChange{Path: manifestPath, Action: ManifestAdded})
+	case err != nil:
+		return nil, err
+	default:
+		m = m.clone()
+	}
+
+	names := doc.fileNames()
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		present[name] = true
+	}
+
+	// Drop entries for parts that no longer exist
+	kept := m.Entries[:0]
+	for _, entry := range m.Entries {
+		path := entry.FullPath
+		if path != "/" && !present[path] && !(strings.HasSuffix(path, "/") && hasPrefix(names, path)) {
+			changes = append(changes, ManifestChange{Path: path, Action: ManifestRemoved})
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	m.Entries = kept
+	m.reindex()
+
+	// Add missing entries and correct media types
+	for _, name := range names {
+		if isUnlistedPart(name) {
+			continue
+		}
+		mediaType, err := doc.partMediaType(name)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := m.Entry(name)
+		switch {
+		case entry == nil:
+			m.Set(name, mediaType)
+			changes = append(changes, ManifestChange{Path: name, Action: ManifestAdded, MediaType: mediaType})
+		case mediaType != "" && entry.MediaType != mediaType:
+			entry.MediaType = mediaType
+			changes = append(changes, ManifestChange{Path: name, Action: ManifestMediaType, MediaType: mediaType})
+		}
+	}
+
+	if len(changes) > 0 {
+		if err := doc.checkSignatures(manifestPath); err != nil {
+			return nil, err
+		}
+		doc.storeManifest(m)
+	}
+	return changes, nil
+}
+
+// savedManifest returns a copy of the manifest to write on save, with
+// media types of modified and added parts corrected, and reports whether
+// it differs from the stored manifest. Unchanged parts are not read, so
+// they can be copied without inflating or decrypting them. It returns nil
+// for packages without a parseable manifest, which are written as they
+// are.
+func (doc *ODTDocument) savedManifest() (*Manifest, bool) {
+	m, err := doc.loadManifest()
+	if err != nil {
+		return nil, false
+	}
+
+	fixed := m.clone()
+	changed := false
+	for i := range fixed.Entries {
+		entry := &fixed.Entries[i]
+		data, ok := doc.pkg.changed(entry.FullPath)
+		if !ok || isUnlistedPart(entry.FullPath) {
+			continue
+		}
+
+		mediaType := xmlMediaType(entry.FullPath)
+		if mediaType == "" {
+			mediaType = mediaTypeFor(entry.FullPath, data)
+		}
+		if mediaType != "" && mediaType != entry.MediaType {
+			entry.MediaType = mediaType
+			changed = true
+		}
+	}
+
+	return fixed, changed
+}
@@ -0,0 +1,211 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"strings"
+	"testing"
+)
+
+const encryptedManifestXML = `<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.3">
+ <manifest:file-entry manifest:full-path="/" manifest:version="1.3" manifest:media-type="application/vnd.oasis.opendocument.text"/>
+ <manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml" manifest:size="1234">
+  <manifest:encryption-data manifest:checksum-type="SHA1/1K" manifest:checksum="abc="><manifest:algorithm manifest:algorithm-name="Blowfish CFB" manifest:initialisation-vector="iv="/></manifest:encryption-data>
+ </manifest:file-entry>
+ <manifest:file-entry manifest:full-path="Pictures/logo.png" manifest:media-type="image/jpeg"/>
+</manifest:manifest>`
+
+func TestParseManifest_RoundTrip(t *testing.T) {
+	m, err := parseManifest([]byte(encryptedManifestXML))
+	if err != nil {
+		t.Fatalf("parseManifest() error = %v", err)
+	}
+
+	if len(m.Entries) != 3 {
+		t.Fatalf("parseManifest() found %d entries, want 3", len(m.Entries))
+	}
+	if m.Entries[0].Version != "1.3" {
+		t.Errorf("root version = %q, want 1.3", m.Entries[0].Version)
+	}
+
+	reparsed, err := parseManifest(m.Bytes())
+	if err != nil {
+		t.Fatalf("parseManifest(Bytes()) error = %v", err)
+	}
+
+	content := reparsed.Entry("content.xml")
+	if content == nil {
+		t.Fatal("content.xml entry lost after serialization")
+	}
+	if !strings.Contains(content.inner, `manifest:algorithm-name="Blowfish CFB"`) {
+		t.Errorf("encryption data not preserved: %q", content.inner)
+	}
+	if len(content.attrs) != 1 || content.attrs[0].Value != "1234" {
+		t.Errorf("manifest:size not preserved: %+v", content.attrs)
+	}
+	if !strings.Contains(string(m.Bytes()), `manifest:version="1.3">`) {
+		t.Error("root manifest:version not preserved")
+	}
+}
+
+func TestParseManifest_Malformed(t *testing.T) {
+	if _, err := parseManifest([]byte(`<manifest:manifest xmlns:manifest="x"><manifest:file-entry>`)); err == nil {
+		t.Error("parseManifest() should fail on malformed XML")
+	}
+}
+
+func TestODTDocument_ReplaceFixesMediaType(t *testing.T) {
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", strings.Replace(validManifestXML, `"image/png"`, `"image/jpeg"`, 1), zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	// Replacing with the same path must correct the stale media type
+	if err := doc.ReplaceImageByTag("image1", "Pictures/img1.png", []byte(testPNG)); err != nil {
+		t.Fatalf("ReplaceImageByTag() error = %v", err)
+	}
+
+	entries, err := doc.Manifest()
+	if err != nil {
+		t.Fatalf("Manifest() error = %v", err)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if entry.FullPath == "Pictures/img1.png" {
+			count++
+			if entry.MediaType != "image/png" {
+				t.Errorf("media type = %q, want image/png", entry.MediaType)
+			}
+		}
+	}
+	if count != 1 {
+		t.Errorf("found %d entries for Pictures/img1.png, want 1", count)
+	}
+}
+
+func TestODTDocument_SaveFixesMediaType(t *testing.T) {
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", "GIF89a\x01\x00\x01\x00\x00\x00\x00", zip.Deflate},
+		{"META-INF/manifest.xml", strings.Replace(validManifestXML, `"image/png"`, `"image/gif"`, 1), zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	// Only changed parts are checked; the GIF is replaced by a PNG
+	doc.setFile("Pictures/img1.png", []byte(testPNG))
+	output, err := doc.SaveToBytes()
+	if err != nil {
+		t.Fatalf("SaveToBytes() error = %v", err)
+	}
+
+	saved, err := NewODTDocumentFromBytes(output)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	report := saved.Validate()
+	if len(report.Findings) != 0 {
+		t.Errorf("saved package has findings: %+v", report.Findings)
+	}
+}
+
+func TestODTDocument_RemoveFile(t *testing.T) {
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	if err := doc.RemoveFile("Pictures/img1.png"); err != nil {
+		t.Fatalf("RemoveFile() error = %v", err)
+	}
+	if err := doc.RemoveFile("Pictures/img1.png"); err == nil {
+		t.Error("RemoveFile() should fail for a removed file")
+	}
+	if err := doc.RemoveFile("mimetype"); err == nil {
+		t.Error("RemoveFile() should refuse to remove the mimetype")
+	}
+
+	output, err := doc.SaveToBytes()
+	if err != nil {
+		t.Fatalf("SaveToBytes() error = %v", err)
+	}
+	reader, err := zip.NewReader(strings.NewReader(string(output)), int64(len(output)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range reader.File {
+		if f.Name == "Pictures/img1.png" {
+			t.Error("removed file is still in the saved package")
+		}
+	}
+
+	entries, _ := doc.Manifest()
+	for _, entry := range entries {
+		if entry.FullPath == "Pictures/img1.png" {
+			t.Error("removed file is still listed in the manifest")
+		}
+	}
+}
+
+func TestODTDocument_RepairManifest(t *testing.T) {
+	manifest := `<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0">
+ <manifest:file-entry manifest:full-path="/" manifest:media-type="application/vnd.oasis.opendocument.text"/>
+ <manifest:file-entry manifest:full-path="Pictures/gone.png" manifest:media-type="image/png"/>
+</manifest:manifest>`
+
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", manifest, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	changes, err := doc.RepairManifest()
+	if err != nil {
+		t.Fatalf("RepairManifest() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"Pictures/gone.png": ManifestRemoved,
+		"content.xml":       ManifestAdded,
+		"Pictures/img1.png": ManifestAdded,
+	}
+	if len(changes) != len(expected) {
+		t.Errorf("RepairManifest() made %d changes, want %d: %+v", len(changes), len(expected), changes)
+	}
+	for _, change := range changes {
+		if expected[change.Path] != change.Action {
+			t.Errorf("unexpected change %+v", change)
+		}
+	}
+
+	if report := doc.Validate(); !report.Valid {
+		t.Errorf("repaired package is invalid: %+v", report.Findings)
+	}
+}
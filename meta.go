@@ -0,0 +1,312 @@
+package odtimagereplacer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Namespaces used in meta.xml
+const (
+	nsMeta = "urn:oasis:names:tc:opendocument:xmlns:meta:1.0"
+	nsDC   = "http://purl.org/dc/elements/1.1/"
+)
+
+// metaPath is the location of the document metadata inside the package
+const metaPath = "meta.xml"
+
+// Generator is written to meta:generator when metadata is refreshed on save
+const Generator = "ODT Image Replacer/2.0.0"
+
+// now returns the current time; replaced in tests
+var now = time.Now
+
+// emptyMetaXML is used when a package has no meta.xml yet
+const emptyMetaXML = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-meta xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:meta="urn:oasis:names:tc:opendocument:xmlns:meta:1.0" office:version="1.2"><office:meta></office:meta></office:document-meta>`
+
+// DocumentMeta holds the document properties stored in meta.xml
+type DocumentMeta struct {
+	Title       string            `json:"title,omitempty"`
+	Subject     string            `json:"subject,omitempty"`
+	Keywords    []string          `json:"keywords,omitempty"`
+	Author      string            `json:"author,omitempty"`
+	UserDefined map[string]string `json:"user_defined,omitempty"`
+}
+
+// Meta reads the document properties from meta.xml
+func (doc *ODTDocument) Meta() (*DocumentMeta, error) {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	meta := &DocumentMeta{UserDefined: make(map[string]string)}
+
+	data, err := doc.readFile(metaPath)
+	if err != nil {
+		// A package without meta.xml simply has no properties
+		return meta, nil
+	}
+
+	root, err := parseXMLTree(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse meta.xml: %w", err)
+	}
+
+	r := &textRenderer{}
+	walkXMLTree(root, func(n *xmlNode) {
+		switch {
+		case n.is(nsDC, "title"):
+			meta.Title = r.plain(n)
+		case n.is(nsDC, "subject"):
+			meta.Subject = r.plain(n)
+		case n.is(nsMeta, "keyword"):
+			meta.Keywords = append(meta.Keywords, r.plain(n))
+		case n.is(nsMeta, "initial-creator"):
+			meta.Author = r.plain(n)
+		case n.is(nsMeta, "user-defined"):
+			meta.UserDefined[n.attr(nsMeta, "name")] = r.plain(n)
+		}
+	})
+
+	return meta, nil
+}
+
+// SetMeta writes the non-empty properties of meta to meta.xml, creating it
+// if necessary. Author sets both the initial creator and the last editor.
+// User-defined properties are added or replaced by name.
+func (doc *ODTDocument) SetMeta(meta DocumentMeta) error {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	return doc.editMeta(func(body *xmlNode) {
+		if meta.Title != "" {
+			setMetaElement(body, nsDC, "title", meta.Title)
+		}
+		if meta.Subject != "" {
+			setMetaElement(body, nsDC, "subject", meta.Subject)
+		}
+		if meta.Author != "" {
+			setMetaElement(body, nsMeta, "initial-creator", meta.Author)
+			setMetaElement(body, nsDC, "creator", meta.Author)
+		}
+		if len(meta.Keywords) > 0 {
+			removeMetaElements(body, func(n *xmlNode) bool { return n.is(nsMeta, "keyword") })
+			for _, keyword := range meta.Keywords {
+				appendMetaElement(body, nsMeta, "keyword", keyword)
+			}
+		}
+
+		names := make([]string, 0, len(meta.UserDefined))
+		for name := range meta.UserDefined {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			removeMetaElements(body, func(n *xmlNode) bool {
+				return n.is(nsMeta, "user-defined") && n.attr(nsMeta, "name") == name
+			})
+			appendMetaElement(body, nsMeta, "user-defined", meta.UserDefined[name],
+				xml.Attr{Name: xml.Name{Space: nsMeta, Local: "name"}, Value: name},
+				xml.Attr{Name: xml.Name{Space: nsMeta, Local: "value-type"}, Value: "string"})
+		}
+	})
+}
+
+// SetMetaRefresh enables updating meta.xml when the document is saved.
+// The creation and modification dates are set to the save time, editing
+// statistics are reset, meta:generator is set to Generator and the
+// document statistics are recomputed from content.xml. Only the saved
+// output is updated; the document itself keeps its meta.xml.
+func (doc *ODTDocument) SetMetaRefresh(enabled bool) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	doc.refreshMeta = enabled
+}
+
+// applyMetaRefresh updates dates, generator and statistics in meta.xml
+func (doc *ODTDocument) applyMetaRefresh() error {
+	stats, err := doc.documentStatistics()
+	if err != nil {
+		return err
+	}
+	return doc.editMeta(metaRefresh(stats))
+}
+
+// metaRefresh returns the edit made by SetMetaRefresh, dated now
+func metaRefresh(stats map[string]int) func(body *xmlNode) {
+	timestamp := now().UTC().Format("2006-01-02T15:04:05Z")
+
+	return func(body *xmlNode) {
+		setMetaElement(body, nsMeta, "generator", Generator)
+		setMetaElement(body, nsMeta, "creation-date", timestamp)
+		setMetaElement(body, nsDC, "date", timestamp)
+		setMetaElement(body, nsMeta, "editing-cycles", "1")
+		setMetaElement(body, nsMeta, "editing-duration", "PT0S")
+		setMetaStatistics(body, stats)
+	}
+}
+
+// editMeta applies edit to the office:meta element of meta.xml
+func (doc *ODTDocument) editMeta(edit func(body *xmlNode)) error {
+	data, err := doc.readFile(metaPath)
+	created := err != nil
+	if created {
+		data = []byte(emptyMetaXML)
+	}
+
+	newMeta, err := editMetaXML(data, edit)
+	if err != nil {
+		return err
+	}
+	if err := doc.checkSignatures(metaPath, manifestPath); err != nil {
+		return err
+	}
+	doc.setFile(metaPath, newMeta)
+
+	if created {
+		return doc.updateManifestEntry(metaPath, newMeta)
+	}
+	return nil
+}
+
+// editMetaXML applies edit to the office:meta element of a meta.xml
+// document and returns the result
+func editMetaXML(data []byte, edit func(body *xmlNode)) ([]byte, error) {
+	root, err := parseXMLTree(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: meta.xml: %v", ErrInvalidODT, err)
+	}
+	var document, body *xmlNode
+	for _, child := range root.children {
+		if child.is(nsOffice, "document-meta") {
+			document = child
+		}
+	}
+	if document != nil {
+		for _, child := range document.children {
+			if child.is(nsOffice, "meta") {
+				body = child
+			}
+		}
+	}
+	if body == nil {
+		return nil, fmt.Errorf("%w: meta.xml has no office:meta element", ErrInvalidODT)
+	}
+
+	edit(body)
+
+	// Added elements may use namespaces the template does not declare
+	declareNamespace(document, nsMeta, "meta")
+	declareNamespace(document, nsDC, "dc")
+	return marshalXMLTree(root), nil
+}
+
+// removeMetaElements removes the children of body that match
+func removeMetaElements(body *xmlNode, match func(*xmlNode) bool) {
+	body.children = slices.DeleteFunc(body.children, match)
+}
+
+// appendMetaElement adds an element holding value to body
+func appendMetaElement(body *xmlNode, space, local, value string, attrs ...xml.Attr) {
+	n := &xmlNode{name: xml.Name{Space: space, Local: local}, attrs: attrs}
+	if value != "" {
+		n.children = []*xmlNode{{text: value}}
+	}
+	body.children = append(body.children, n)
+}
+
+// setMetaElement replaces all space:local elements with a single one
+// holding value
+func setMetaElement(body *xmlNode, space, local, value string) {
+	removeMetaElements(body, func(n *xmlNode) bool { return n.is(space, local) })
+	appendMetaElement(body, space, local, value)
+}
+
+// setMetaStatistics updates meta:document-statistic, keeping attributes
+// that cannot be recomputed such as the page count
+func setMetaStatistics(body *xmlNode, stats map[string]int) {
+	var statistic *xmlNode
+	for _, child := range body.children {
+		if child.is(nsMeta, "document-statistic") {
+			statistic = child
+			break
+		}
+	}
+	if statistic == nil {
+		statistic = &xmlNode{name: xml.Name{Space: nsMeta, Local: "document-statistic"}}
+		body.children = append(body.children, statistic)
+	}
+
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := strconv.Itoa(stats[name])
+		i := slices.IndexFunc(statistic.attrs, func(a xml.Attr) bool {
+			return a.Name.Space == nsMeta && a.Name.Local == name
+		})
+		if i >= 0 {
+			statistic.attrs[i].Value = value
+		} else {
+			statistic.attrs = append(statistic.attrs, xml.Attr{Name: xml.Name{Space: nsMeta, Local: name}, Value: value})
+		}
+	}
+}
+
+// documentStatistics computes the meta:document-statistic attributes, by
+// local name, from content.xml
+func (doc *ODTDocument) documentStatistics() (map[string]int, error) {
+	content, err := doc.getContentXML()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseXMLTree([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("parse content.xml: %w", err)
+	}
+
+	stats := map[string]int{
+		"table-count":     0,
+		"image-count":     0,
+		"object-count":    0,
+		"paragraph-count": 0,
+	}
+	walkXMLTree(root, func(n *xmlNode) {
+		switch {
+		case n.is(nsTable, "table"):
+			stats["table-count"]++
+		case n.is(nsDraw, "image"):
+			stats["image-count"]++
+		case n.is(nsDraw, "object"):
+			stats["object-count"]++
+		case n.is(nsText, "p"), n.is(nsText, "h"):
+			stats["paragraph-count"]++
+		}
+	})
+
+	r := &textRenderer{}
+	text := strings.Join(r.blocks(root, 0), "\n")
+	nonSpace := 0
+	for _, c := range text {
+		if !unicode.IsSpace(c) {
+			nonSpace++
+		}
+	}
+	stats["word-count"] = len(strings.Fields(text))
+	stats["character-count"] = utf8.RuneCountInString(strings.ReplaceAll(text, "\n", ""))
+	stats["non-whitespace-character-count"] = nonSpace
+
+	return stats, nil
+}
@@ -0,0 +1,187 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMetaXML = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-meta xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:meta="urn:oasis:names:tc:opendocument:xmlns:meta:1.0" office:version="1.3"><office:meta><meta:creation-date>2019-03-01T10:00:00</meta:creation-date><dc:date>2019-03-02T10:00:00</dc:date><meta:editing-cycles>57</meta:editing-cycles><meta:generator>LibreOffice/6.0</meta:generator><dc:title>Template</dc:title><meta:keyword>old</meta:keyword><meta:user-defined meta:name="Client" meta:value-type="string">ACME</meta:user-defined><meta:document-statistic meta:table-count="3" meta:image-count="9" meta:page-count="2"/></office:meta></office:document-meta>`
+
+func TestODTDocument_SetMeta(t *testing.T) {
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"meta.xml", testMetaXML, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	err = doc.SetMeta(DocumentMeta{
+		Title:       "Invoice <42>",
+		Keywords:    []string{"invoice", "2026"},
+		Author:      "Billing",
+		UserDefined: map[string]string{"Client": "Globex", "Order": "A-1"},
+	})
+	if err != nil {
+		t.Fatalf("SetMeta() error = %v", err)
+	}
+
+	meta, err := doc.Meta()
+	if err != nil {
+		t.Fatalf("Meta() error = %v", err)
+	}
+
+	if meta.Title != "Invoice <42>" {
+		t.Errorf("Title = %q", meta.Title)
+	}
+	if strings.Join(meta.Keywords, ",") != "invoice,2026" {
+		t.Errorf("Keywords = %v", meta.Keywords)
+	}
+	if meta.Author != "Billing" {
+		t.Errorf("Author = %q", meta.Author)
+	}
+	if meta.UserDefined["Client"] != "Globex" || meta.UserDefined["Order"] != "A-1" {
+		t.Errorf("UserDefined = %v", meta.UserDefined)
+	}
+}
+
+func TestODTDocument_MetaRefresh(t *testing.T) {
+	fixed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"meta.xml", testMetaXML, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	doc.SetMetaRefresh(true)
+	saved := saveAndReopen(t, doc)
+
+	// The document itself is left as it was
+	if original, _ := doc.readFile(metaPath); string(original) != testMetaXML {
+		t.Errorf("SaveToBytes() changed meta.xml of the document:\n%s", original)
+	}
+
+	meta, err := saved.readFile(metaPath)
+	if err != nil {
+		t.Fatalf("readFile(meta.xml) error = %v", err)
+	}
+
+	for _, want := range []string{
+		"<dc:date>2026-01-02T03:04:05Z</dc:date>",
+		"<meta:creation-date>2026-01-02T03:04:05Z</meta:creation-date>",
+		"<meta:editing-cycles>1</meta:editing-cycles>",
+		"<meta:generator>" + Generator + "</meta:generator>",
+		`meta:image-count="2"`,
+		`meta:table-count="0"`,
+		`meta:page-count="2"`,
+		"<dc:title>Template</dc:title>",
+	} {
+		if !strings.Contains(string(meta), want) {
+			t.Errorf("meta.xml missing %s:\n%s", want, meta)
+		}
+	}
+	if strings.Count(string(meta), "<dc:date>") != 1 {
+		t.Errorf("meta.xml has duplicate dc:date:\n%s", meta)
+	}
+}
+
+func TestODTDocument_SetMeta_CreatesMetaXML(t *testing.T) {
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	if err := doc.SetMeta(DocumentMeta{Subject: "Report"}); err != nil {
+		t.Fatalf("SetMeta() error = %v", err)
+	}
+
+	entries, err := doc.Manifest()
+	if err != nil {
+		t.Fatalf("Manifest() error = %v", err)
+	}
+
+	listed := false
+	for _, entry := range entries {
+		if entry.FullPath == metaPath && entry.MediaType == "text/xml" {
+			listed = true
+		}
+	}
+	if !listed {
+		t.Error("created meta.xml is not listed in the manifest")
+	}
+
+	meta, err := doc.Meta()
+	if err != nil || meta.Subject != "Report" {
+		t.Errorf("Meta() = %+v, %v", meta, err)
+	}
+}
+
+func TestODTDocument_SetMeta_OtherPrefixes(t *testing.T) {
+	// Prefixes are bindings, not fixed names; dc is not declared at all
+	metaXML := `<?xml version="1.0" encoding="UTF-8"?>
+<o:document-meta xmlns:o="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:m="urn:oasis:names:tc:opendocument:xmlns:meta:1.0" xmlns:x="urn:example:x" o:version="1.3">
+  <o:meta x:note="keep"><m:keyword>old</m:keyword><m:document-statistic m:page-count="2"/></o:meta>
+</o:document-meta>`
+
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"meta.xml", metaXML, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	if err := doc.SetMeta(DocumentMeta{Title: "A & B", Keywords: []string{"new"}}); err != nil {
+		t.Fatalf("SetMeta() error = %v", err)
+	}
+	doc.SetMetaRefresh(true)
+	saved := saveAndReopen(t, doc)
+
+	meta, err := saved.Meta()
+	if err != nil {
+		t.Fatalf("Meta() error = %v", err)
+	}
+	if meta.Title != "A & B" || strings.Join(meta.Keywords, ",") != "new" {
+		t.Errorf("Meta() = %+v", meta)
+	}
+
+	raw, _ := saved.readFile(metaPath)
+	for _, want := range []string{
+		`<o:meta x:note="keep">`,
+		`xmlns:dc="http://purl.org/dc/elements/1.1/"`,
+		"<dc:title>A &amp; B</dc:title>",
+		"<m:generator>" + Generator + "</m:generator>",
+		`m:page-count="2"`,
+		`m:image-count="2"`,
+	} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("meta.xml missing %s:\n%s", want, raw)
+		}
+	}
+}
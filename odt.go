@@ -3,11 +3,14 @@ package odtimagereplacer
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 )
@@ -26,7 +29,6 @@ const (
 var (
 	// Pre-compiled regex patterns for performance
 	drawFrameRegex     *regexp.Regexp
-	manifestEndRegex   *regexp.Regexp
 	regexOnce          sync.Once
 	regexCompileErrors []error
 )
@@ -40,29 +42,55 @@ func initRegex() {
 		if err != nil {
 			regexCompileErrors = append(regexCompileErrors, fmt.Errorf("draw frame regex: %w", err))
 		}
-
-		manifestEndRegex, err = regexp.Compile(`(?i)</manifest:manifest>`)
-		if err != nil {
-			regexCompileErrors = append(regexCompileErrors, fmt.Errorf("manifest end regex: %w", err))
-		}
 	})
 }
 
-// ODTDocument represents an ODT document with methods for manipulation
+// ODTDocument represents an ODT document with methods for manipulation.
+// It is safe for concurrent use: reads may run in parallel and
+// modifications are serialized.
 type ODTDocument struct {
-	path   string
-	reader *zip.Reader
-	files  map[string][]byte
+	mu sync.RWMutex
+
+	path     string
+	pkg      *zipPackage // original, modified, added and deleted parts
+	cache    *partCache  // decoded original parts, shared with clones
+	manifest *Manifest   // manifest edited since opening
+
+	refreshMeta   bool
+	thumbnailMode ThumbnailMode
+	converter     Converter
+	replaced      map[string]bool
+
+	password     string                     // password used when saving
+	readPassword string                     // password the document was opened with
+	encryption   map[string]*encryptionData // encrypted original parts
+	plainParts   map[string]bool            // unencrypted parts of an encrypted package
+	rekey        bool                       // re-encrypt unchanged parts on save
+	outer        *outerPackage              // wrapper of wholesome-encrypted documents
+
+	signaturePolicy SignaturePolicy
+	invalidated     []Signature // broken or stripped by modifications
+
+	snapshot *ODTDocument // state at Begin while a transaction is open
+}
+
+// partCache holds original parts decoded on first use. Original parts
+// never change, so the cache is shared by a document and its clones.
+type partCache struct {
+	mu       sync.Mutex
+	files    map[string][]byte
+	manifest *Manifest // parsed original manifest; treat as read-only
 }
 
-// NewODTDocument creates a new ODT document from a file path
+// NewODTDocument creates a new ODT document from a file path. Encrypted
+// documents return ErrEncrypted; use NewODTDocumentWithPassword for them.
 func NewODTDocument(path string) (*ODTDocument, error) {
-	// Initialize regex patterns
-	initRegex()
-	if len(regexCompileErrors) > 0 {
-		return nil, fmt.Errorf("regex compilation failed: %v", regexCompileErrors)
-	}
+	return NewODTDocumentWithPassword(path, "")
+}
 
+// NewODTDocumentWithPassword opens a document that may be encrypted with
+// the given password
+func NewODTDocumentWithPassword(path, password string) (*ODTDocument, error) {
 	// Validate file path
 	if err := validatePath(path); err != nil {
 		return nil, err
@@ -84,6 +112,17 @@ func NewODTDocument(path string) (*ODTDocument, error) {
 		return nil, fmt.Errorf("read file: %w", err)
 	}
 
+	return openODT(path, data, password)
+}
+
+// openODT parses an ODT package held in memory
+func openODT(path string, data []byte, password string) (*ODTDocument, error) {
+	// Initialize regex patterns
+	initRegex()
+	if len(regexCompileErrors) > 0 {
+		return nil, fmt.Errorf("regex compilation failed: %v", regexCompileErrors)
+	}
+
 	// Create ZIP reader
 	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
@@ -96,14 +135,57 @@ func NewODTDocument(path string) (*ODTDocument, error) {
 	}
 
 	doc := &ODTDocument{
-		path:   path,
-		reader: reader,
-		files:  make(map[string][]byte, len(reader.File)),
+		path:     path,
+		pkg:      newZipPackage(reader),
+		cache:    &partCache{files: make(map[string][]byte)},
+		replaced: make(map[string]bool),
+	}
+
+	// Detect encryption and check the password
+	if err := doc.initEncryption(password); err != nil {
+		return nil, err
 	}
 
 	return doc, nil
 }
 
+// Clone returns an independent copy of the document. Original parts and
+// their decoded cache are shared; only the parts modified so far are
+// copied, so cloning a loaded template for each request is cheap.
+func (doc *ODTDocument) Clone() *ODTDocument {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	return doc.clone()
+}
+
+// clone copies the document state with the lock held. Part data and
+// manifests are replaced, never modified in place, so the copies may
+// share them.
+func (doc *ODTDocument) clone() *ODTDocument {
+	return &ODTDocument{
+		path:     doc.path,
+		pkg:      doc.pkg.clone(),
+		cache:    doc.cache,
+		manifest: doc.manifest,
+
+		refreshMeta:   doc.refreshMeta,
+		thumbnailMode: doc.thumbnailMode,
+		converter:     doc.converter,
+		replaced:      maps.Clone(doc.replaced),
+
+		password:     doc.password,
+		readPassword: doc.readPassword,
+		encryption:   doc.encryption,
+		plainParts:   doc.plainParts,
+		rekey:        doc.rekey,
+		outer:        doc.outer,
+
+		signaturePolicy: doc.signaturePolicy,
+		invalidated:     slices.Clone(doc.invalidated),
+	}
+}
+
 // validatePath checks for path traversal attempts and validates the path
 func validatePath(path string) error {
 	if path == "" {
@@ -168,44 +250,86 @@ func (doc *ODTDocument) loadFile(f *zip.File) ([]byte, error) {
 		return nil, fmt.Errorf("%w: file %s exceeds limit after decompression", ErrFileTooLarge, f.Name)
 	}
 
+	// Encrypted parts are deflated before encryption
+	if doc.encryption[f.Name] != nil {
+		return doc.decryptFile(f.Name, data)
+	}
+
 	return data, nil
 }
 
-// loadAllFiles loads all files from the ZIP archive into memory
+// loadAllFiles decodes all parts of the package into the cache
 func (doc *ODTDocument) loadAllFiles() error {
-	for _, f := range doc.reader.File {
-		data, err := doc.loadFile(f)
-		if err != nil {
+	for _, name := range doc.fileNames() {
+		if _, err := doc.getFile(name); err != nil {
 			return err
 		}
-		doc.files[f.Name] = data
 	}
 	return nil
 }
 
-// getFile retrieves a file from the archive, loading it if necessary
+// getFile retrieves a file, caching original parts for later reads
 func (doc *ODTDocument) getFile(name string) ([]byte, error) {
-	// Check if already loaded
-	if data, ok := doc.files[name]; ok {
+	if data, ok := doc.pkg.changed(name); ok {
 		return data, nil
 	}
+	if !doc.pkg.exists(name) {
+		return nil, fmt.Errorf("file %s not found in archive", name)
+	}
 
-	// Load the specific file
-	for _, f := range doc.reader.File {
-		if f.Name == name {
-			data, err := doc.loadFile(f)
-			if err != nil {
-				return nil, err
-			}
-			doc.files[name] = data
-			return data, nil
-		}
+	doc.cache.mu.Lock()
+	defer doc.cache.mu.Unlock()
+
+	if data, ok := doc.cache.files[name]; ok {
+		return data, nil
+	}
+	data, err := doc.originalFile(name)
+	if err != nil {
+		return nil, err
+	}
+	doc.cache.files[name] = data
+	return data, nil
+}
+
+// readFile retrieves a file without caching it
+func (doc *ODTDocument) readFile(name string) ([]byte, error) {
+	if data, ok := doc.pkg.changed(name); ok {
+		return data, nil
+	}
+	if !doc.pkg.exists(name) {
+		return nil, fmt.Errorf("file %s not found in archive", name)
+	}
+
+	doc.cache.mu.Lock()
+	data, ok := doc.cache.files[name]
+	doc.cache.mu.Unlock()
+	if ok {
+		return data, nil
 	}
 
-	return nil, fmt.Errorf("file %s not found in archive", name)
+	return doc.originalFile(name)
+}
+
+// originalFile loads a file as it was stored when the document was opened
+func (doc *ODTDocument) originalFile(name string) ([]byte, error) {
+	f := doc.pkg.original(name)
+	if f == nil {
+		return nil, fmt.Errorf("file %s not found in archive", name)
+	}
+	return doc.loadFile(f)
+}
+
+// setFile stores new content for a part, adding it if necessary
+func (doc *ODTDocument) setFile(name string, data []byte) {
+	doc.pkg.set(name, data)
 }
 
-// getContentXML retrieves and caches content.xml
+// deleteFile removes a part from the package
+func (doc *ODTDocument) deleteFile(name string) {
+	doc.pkg.remove(name)
+}
+
+// getContentXML retrieves content.xml
 func (doc *ODTDocument) getContentXML() (string, error) {
 	data, err := doc.getFile("content.xml")
 	if err != nil {
@@ -214,7 +338,7 @@ func (doc *ODTDocument) getContentXML() (string, error) {
 	return string(data), nil
 }
 
-// getManifestXML retrieves and caches manifest.xml
+// getManifestXML retrieves manifest.xml
 func (doc *ODTDocument) getManifestXML() (string, error) {
 	data, err := doc.getFile("META-INF/manifest.xml")
 	if err != nil {
@@ -225,6 +349,9 @@ func (doc *ODTDocument) getManifestXML() (string, error) {
 
 // ReplaceImageByTag replaces an image in the ODT by its draw:name tag
 func (doc *ODTDocument) ReplaceImageByTag(tag, newImagePath string, newImageData []byte) error {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
 	// Validate inputs
 	if tag == "" {
 		return fmt.Errorf("tag cannot be empty")
@@ -242,67 +369,13 @@ func (doc *ODTDocument) ReplaceImageByTag(tag, newImagePath string, newImageData
 		return err
 	}
 
-	// Get content.xml
-	content, err := doc.getContentXML()
-	if err != nil {
-		return err
-	}
-
-	// Build regex pattern for this specific tag
-	pattern := fmt.Sprintf(`(<draw:frame[^>]*draw:name="%s"[^>]*>[\s\S]*?xlink:href=")[^"]+("[\s\S]*?</draw:frame>)`, regexp.QuoteMeta(tag))
-	re, err := regexp.Compile(pattern)
-	if err != nil {
-		return fmt.Errorf("compile replacement regex: %w", err)
-	}
-
-	// Replace the image reference
-	newContent := re.ReplaceAllString(content, "${1}"+newImagePath+"${2}")
-
-	// Check if replacement occurred
-	if newContent == content {
-		return fmt.Errorf("%w: tag '%s'", ErrImageNotFound, tag)
-	}
-
-	// Update content.xml
-	doc.files["content.xml"] = []byte(newContent)
-
-	// Update manifest.xml
-	if err := doc.addImageToManifest(newImagePath); err != nil {
-		return err
-	}
-
-	// Add image data
-	doc.files[newImagePath] = newImageData
-
-	return nil
-}
-
-// addImageToManifest adds or updates an image entry in manifest.xml
-func (doc *ODTDocument) addImageToManifest(imagePath string) error {
-	manifest, err := doc.getManifestXML()
+	results, err := doc.replaceImages(map[Selector]Image{
+		ByName(tag): {Data: newImageData, Path: newImagePath},
+	})
 	if err != nil {
 		return err
 	}
-
-	// Detect MIME type from extension
-	mimeType := detectMIMEType(imagePath)
-
-	// Check if entry already exists
-	entryPattern := fmt.Sprintf(`<manifest:file-entry[^>]*manifest:full-path="%s"[^>]*/>`, regexp.QuoteMeta(imagePath))
-	if matched, _ := regexp.MatchString(entryPattern, manifest); matched {
-		// Entry already exists, no need to add
-		return nil
-	}
-
-	// Create new manifest entry
-	entry := fmt.Sprintf(`    <manifest:file-entry manifest:full-path="%s" manifest:media-type="%s" />`,
-		imagePath, mimeType)
-
-	// Insert before closing tag
-	newManifest := manifestEndRegex.ReplaceAllString(manifest, entry+"\n</manifest:manifest>")
-
-	doc.files["META-INF/manifest.xml"] = []byte(newManifest)
-	return nil
+	return results[0].Err
 }
 
 // detectMIMEType returns MIME type based on file extension
@@ -319,138 +392,175 @@ func detectMIMEType(path string) string {
 		return "image/svg+xml"
 	case ".webp":
 		return "image/webp"
+	case ".bmp":
+		return "image/bmp"
+	case ".tif", ".tiff":
+		return "image/tiff"
+	case ".wmf":
+		return "image/x-wmf"
+	case ".emf":
+		return "image/x-emf"
+	case ".svm":
+		return "image/x-svm"
 	default:
 		return "application/octet-stream"
 	}
 }
 
-// Save writes the modified ODT back to disk
+// Save writes the modified ODT back to disk atomically with
+// DefaultSaveOptions; see SaveWithOptions
 func (doc *ODTDocument) Save(outputPath string) error {
-	// Validate output path
-	if err := validatePath(outputPath); err != nil {
-		return err
-	}
-
-	// Create buffer for ZIP
-	buf := new(bytes.Buffer)
-	writer := zip.NewWriter(buf)
+	return doc.SaveWithOptions(outputPath, DefaultSaveOptions)
+}
 
-	// Write all files from original ZIP, but use modified versions if they exist
-	for _, f := range doc.reader.File {
-		var data []byte
-		var err error
+// SaveToBytes saves the ODT document to a byte slice
+func (doc *ODTDocument) SaveToBytes() ([]byte, error) {
+	return doc.SaveToBytesContext(context.Background())
+}
 
-		// Check if this file has been modified
-		if modifiedData, exists := doc.files[f.Name]; exists {
-			// Use the modified version
-			data = modifiedData
-		} else {
-			// Use the original version
-			data, err = doc.loadFile(f)
-			if err != nil {
-				return fmt.Errorf("load original file %s: %w", f.Name, err)
-			}
-		}
+// SaveToBytesContext is SaveToBytes with cancellation, checked between
+// parts and passed to the thumbnail converter
+func (doc *ODTDocument) SaveToBytesContext(ctx context.Context) ([]byte, error) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
 
-		// Write to new ZIP
-		fw, err := writer.Create(f.Name)
-		if err != nil {
-			return fmt.Errorf("create zip entry %s: %w", f.Name, err)
-		}
-		if _, err := fw.Write(data); err != nil {
-			return fmt.Errorf("write zip entry %s: %w", f.Name, err)
-		}
+	// Uncommitted changes are never saved
+	if doc.snapshot != nil {
+		return nil, ErrTransactionActive
 	}
 
-	// Write any new files that weren't in the original (e.g., new images)
-	for name, data := range doc.files {
-		// Check if this file already exists in original ZIP
-		exists := false
-		for _, f := range doc.reader.File {
-			if f.Name == name {
-				exists = true
-				break
-			}
-		}
+	// Edits made only for saving go to a copy, so the document is left as
+	// it was and saving twice does not apply them twice
+	saved := doc
+	if doc.refreshMeta || doc.thumbnailMode == ThumbnailRegenerate || doc.thumbnailMode == ThumbnailRemove {
+		saved = doc.clone()
+	}
 
-		// Only write if it's a new file
-		if !exists {
-			fw, err := writer.Create(name)
-			if err != nil {
-				return fmt.Errorf("create zip entry %s: %w", name, err)
-			}
-			if _, err := fw.Write(data); err != nil {
-				return fmt.Errorf("write zip entry %s: %w", name, err)
-			}
+	// Update dates, generator and statistics if requested
+	if saved.refreshMeta {
+		if err := saved.applyMetaRefresh(); err != nil {
+			return nil, fmt.Errorf("refresh meta: %w", err)
 		}
 	}
 
-	// Close ZIP writer
-	if err := writer.Close(); err != nil {
-		return fmt.Errorf("close zip writer: %w", err)
+	// Regenerate or remove the thumbnail if requested
+	if err := saved.applyThumbnailMode(ctx); err != nil {
+		return nil, fmt.Errorf("update thumbnail: %w", err)
 	}
 
-	// Write to file with secure permissions
-	if err := os.WriteFile(outputPath, buf.Bytes(), 0644); err != nil {
-		return fmt.Errorf("write output file: %w", err)
+	data, err := saved.writePackage(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	// Signatures broken by the saved edits are reported once written
+	for _, s := range saved.invalidated {
+		if !slices.ContainsFunc(doc.invalidated, func(i Signature) bool { return i.File == s.File && i.ID == s.ID }) {
+			doc.invalidated = append(doc.invalidated, s)
+		}
+	}
+	return data, nil
 }
 
-// SaveToBytes saves the ODT document to a byte slice
-func (doc *ODTDocument) SaveToBytes() ([]byte, error) {
+// writePackage serializes the current state of the package as a ZIP
+func (doc *ODTDocument) writePackage(ctx context.Context) ([]byte, error) {
 	// Create buffer for ZIP
 	buf := new(bytes.Buffer)
 	writer := zip.NewWriter(buf)
 
-	// Write all files from original ZIP, but use modified versions if they exist
-	for _, f := range doc.reader.File {
-		var data []byte
-		var err error
+	// The mimetype must be the first entry and stored uncompressed so that
+	// applications can identify the package without inflating it
+	names := doc.fileNames()
+	for i, name := range names {
+		if name == "mimetype" && i > 0 {
+			copy(names[1:i+1], names[:i])
+			names[0] = name
+			break
+		}
+	}
+
+	// Correct manifest media types that do not match the changed parts
+	manifest, manifestChanged := doc.savedManifest()
 
-		// Check if this file has been modified
-		if modifiedData, exists := doc.files[f.Name]; exists {
-			// Use the modified version
-			data = modifiedData
-		} else {
+	// Prepare every part first; encryption changes the manifest, which may
+	// be written before the parts it describes
+	type part struct {
+		name   string
+		data   []byte
+		method uint16
+		raw    *zip.File
+	}
+	parts := make([]part, 0, len(names))
+	var encrypter *partEncrypter
+	var err error
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		data, modified := doc.pkg.changed(name)
+		encrypt := doc.shouldEncrypt(name)
+
+		// Unchanged parts are copied without decompressing or decrypting
+		// them when they are stored the same way
+		if !modified && name != "mimetype" && name != manifestPath {
+			encrypted := doc.encryption[name] != nil
+			if encrypt == encrypted && !(encrypted && doc.rekey) {
+				parts = append(parts, part{name: name, raw: doc.pkg.original(name)})
+				continue
+			}
+		}
+
+		if !modified {
 			// Use the original version
-			data, err = doc.loadFile(f)
+			data, err = doc.loadFile(doc.pkg.original(name))
 			if err != nil {
-				return nil, fmt.Errorf("load original file %s: %w", f.Name, err)
+				return nil, fmt.Errorf("load original file %s: %w", name, err)
 			}
 		}
 
-		// Write to new ZIP
-		fw, err := writer.Create(f.Name)
-		if err != nil {
-			return nil, fmt.Errorf("create zip entry %s: %w", f.Name, err)
-		}
-		if _, err := fw.Write(data); err != nil {
-			return nil, fmt.Errorf("write zip entry %s: %w", f.Name, err)
+		p := part{name: name, data: data, method: zip.Deflate}
+		switch {
+		case name == "mimetype":
+			p.method = zip.Store
+		case encrypt:
+			if manifest == nil {
+				return nil, fmt.Errorf("encrypt %s: %w", name, ErrManifestNotFound)
+			}
+			if encrypter == nil {
+				if encrypter, err = newPartEncrypter(doc.encryptionParams(), doc.password); err != nil {
+					return nil, fmt.Errorf("derive key: %w", err)
+				}
+			}
+			if p.data, err = encryptPart(manifest, encrypter, name, data); err != nil {
+				return nil, err
+			}
+			// Encrypted data does not compress
+			p.method = zip.Store
+			manifestChanged = true
+		case manifest != nil:
+			if entry := manifest.Entry(name); entry != nil && entry.clearEncryption(manifest.prefix) {
+				manifestChanged = true
+			}
 		}
+		parts = append(parts, p)
 	}
 
-	// Write any new files that weren't in the original (e.g., new images)
-	for name, data := range doc.files {
-		// Check if this file already exists in original ZIP
-		exists := false
-		for _, f := range doc.reader.File {
-			if f.Name == name {
-				exists = true
-				break
+	for _, p := range parts {
+		if p.raw != nil {
+			if err := copyZipEntry(writer, p.raw); err != nil {
+				return nil, err
 			}
+			continue
+		}
+		if p.name == manifestPath && manifestChanged {
+			p.data = manifest.Bytes()
 		}
 
-		// Only write if it's a new file
-		if !exists {
-			fw, err := writer.Create(name)
-			if err != nil {
-				return nil, fmt.Errorf("create zip entry %s: %w", name, err)
-			}
-			if _, err := fw.Write(data); err != nil {
-				return nil, fmt.Errorf("write zip entry %s: %w", name, err)
-			}
+		// Write to new ZIP
+		if err := writeZipEntry(writer, p.name, p.method, p.data); err != nil {
+			return nil, err
 		}
 	}
 
@@ -459,11 +569,19 @@ func (doc *ODTDocument) SaveToBytes() ([]byte, error) {
 		return nil, fmt.Errorf("close zip writer: %w", err)
 	}
 
+	// Wholesome-encrypted documents wrap the package in an encrypted part
+	if doc.outer != nil {
+		return doc.wrapEncryptedPackage(buf.Bytes())
+	}
+
 	return buf.Bytes(), nil
 }
 
 // AddImage adds an image to the ODT at the specified path
 func (doc *ODTDocument) AddImage(imagePath string, imageData []byte) error {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
 	// Validate inputs
 	if len(imageData) == 0 {
 		return fmt.Errorf("image data cannot be empty")
@@ -477,18 +595,16 @@ func (doc *ODTDocument) AddImage(imagePath string, imageData []byte) error {
 		return err
 	}
 
-	// Ensure all files are loaded
-	if len(doc.files) == 0 {
-		if err := doc.loadAllFiles(); err != nil {
-			return err
-		}
+	// Apply the signature policy before touching signed parts
+	if err := doc.checkSignatures(imagePath, manifestPath); err != nil {
+		return err
 	}
 
 	// Add or replace the image
-	doc.files[imagePath] = imageData
+	doc.setFile(imagePath, imageData)
 
 	// Update manifest if needed
-	if err := doc.addImageToManifest(imagePath); err != nil {
+	if err := doc.updateManifestEntry(imagePath, imageData); err != nil {
 		return err
 	}
 
@@ -497,6 +613,9 @@ func (doc *ODTDocument) AddImage(imagePath string, imageData []byte) error {
 
 // FindImageTags finds all image tags (draw:name attributes) in the document
 func (doc *ODTDocument) FindImageTags() ([]string, error) {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
 	content, err := doc.getContentXML()
 	if err != nil {
 		return nil, err
@@ -4,9 +4,11 @@ import (
 	"archive/zip"
 	"bytes"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -130,7 +132,7 @@ func TestODTDocument_AddImage(t *testing.T) {
 	}
 
 	// Verify image was added
-	if data, ok := doc.files["Pictures/test.png"]; !ok {
+	if data, ok := doc.pkg.changed("Pictures/test.png"); !ok {
 		t.Error("Image not found in files map")
 	} else if !bytes.Equal(data, imageData) {
 		t.Error("Image data mismatch")
@@ -280,7 +282,7 @@ func TestODTDocument_ReplaceImageByTag(t *testing.T) {
 	}
 
 	// Verify image was added
-	if data, ok := doc.files["Pictures/newimg.png"]; !ok {
+	if data, ok := doc.pkg.changed("Pictures/newimg.png"); !ok {
 		t.Error("New image not found in files")
 	} else if !bytes.Equal(data, newImage) {
 		t.Error("New image data mismatch")
@@ -343,7 +345,7 @@ func TestODTDocument_PreservesAllFiles(t *testing.T) {
 	}
 
 	// Count original files
-	originalFileCount := len(doc.reader.File)
+	originalFileCount := len(doc.pkg.reader.File)
 
 	// Replace an image (modifies content.xml and manifest.xml)
 	newImage := []byte("new image data")
@@ -365,7 +367,7 @@ func TestODTDocument_PreservesAllFiles(t *testing.T) {
 	}
 
 	// Count files in saved document (should have 1 more: the new image)
-	savedFileCount := len(savedDoc.reader.File)
+	savedFileCount := len(savedDoc.pkg.reader.File)
 	expectedCount := originalFileCount + 1 // original files + new image
 
 	if savedFileCount != expectedCount {
@@ -374,11 +376,11 @@ func TestODTDocument_PreservesAllFiles(t *testing.T) {
 
 	// Verify all original files are still present
 	originalFiles := make(map[string]bool)
-	for _, f := range doc.reader.File {
+	for _, f := range doc.pkg.reader.File {
 		originalFiles[f.Name] = true
 	}
 
-	for _, f := range savedDoc.reader.File {
+	for _, f := range savedDoc.pkg.reader.File {
 		if f.Name != "Pictures/newimg.png" {
 			if !originalFiles[f.Name] {
 				t.Errorf("Saved document has unexpected file: %s", f.Name)
@@ -388,7 +390,7 @@ func TestODTDocument_PreservesAllFiles(t *testing.T) {
 
 	// Verify the new image exists
 	foundNewImage := false
-	for _, f := range savedDoc.reader.File {
+	for _, f := range savedDoc.pkg.reader.File {
 		if f.Name == "Pictures/newimg.png" {
 			foundNewImage = true
 			break
@@ -400,7 +402,7 @@ func TestODTDocument_PreservesAllFiles(t *testing.T) {
 
 	// Verify mimetype is preserved
 	foundMimetype := false
-	for _, f := range savedDoc.reader.File {
+	for _, f := range savedDoc.pkg.reader.File {
 		if f.Name == "mimetype" {
 			foundMimetype = true
 			break
@@ -457,3 +459,91 @@ func BenchmarkReplaceImageByTag(b *testing.B) {
 		}
 	}
 }
+
+func TestODTDocument_Clone(t *testing.T) {
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	clone := doc.Clone()
+	if err := clone.ReplaceImageByTag("image1", "Pictures/clone.png", []byte(testPNG)); err != nil {
+		t.Fatalf("ReplaceImageByTag() on clone error = %v", err)
+	}
+
+	// The original is unaffected by changes to the clone
+	content, _ := doc.getContentXML()
+	if strings.Contains(content, "Pictures/clone.png") {
+		t.Error("modifying the clone changed the original content.xml")
+	}
+	entries, _ := doc.Manifest()
+	for _, entry := range entries {
+		if entry.FullPath == "Pictures/clone.png" {
+			t.Error("modifying the clone changed the original manifest")
+		}
+	}
+
+	cloned, _ := clone.getContentXML()
+	if !strings.Contains(cloned, "Pictures/clone.png") {
+		t.Error("clone content.xml does not reference the new image")
+	}
+}
+
+func TestODTDocument_ConcurrentUse(t *testing.T) {
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < 16; i++ {
+		wg.Add(2)
+
+		// Readers share the document
+		go func() {
+			defer wg.Done()
+			if _, err := doc.FindImageTags(); err != nil {
+				errs <- err
+			}
+			if _, err := doc.ExtractText(); err != nil {
+				errs <- err
+			}
+			doc.Validate()
+		}()
+
+		// Writers work on clones
+		go func(i int) {
+			defer wg.Done()
+			clone := doc.Clone()
+			path := fmt.Sprintf("Pictures/img%d.png", i+10)
+			if err := clone.ReplaceImageByTag("image1", path, []byte(testPNG)); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := clone.SaveToBytes(); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
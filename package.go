@@ -0,0 +1,147 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"maps"
+	"sort"
+)
+
+// entryState describes an entry relative to the archive it was opened from
+type entryState int
+
+// Entry states
+const (
+	entryMissing  entryState = iota // never existed
+	entryOriginal                   // unchanged since opening
+	entryModified                   // original entry with new content
+	entryAdded                      // not in the original archive
+	entryDeleted                    // original entry that was removed
+)
+
+// zipPackage tracks the entries of a package. Original entries are indexed
+// by name once when the archive is opened; modified, added and deleted
+// entries are recorded separately, so lookups never scan the archive and
+// saving is linear in the number of entries.
+type zipPackage struct {
+	reader   *zip.Reader
+	index    map[string]*zip.File // first original entry of each name; read-only
+	modified map[string][]byte    // new content of original entries
+	added    map[string][]byte    // entries not in the original archive
+	deleted  map[string]bool      // original entries that were removed
+}
+
+// newZipPackage indexes the entries of an archive
+func newZipPackage(reader *zip.Reader) *zipPackage {
+	index := make(map[string]*zip.File, len(reader.File))
+	for _, f := range reader.File {
+		if _, ok := index[f.Name]; !ok {
+			index[f.Name] = f
+		}
+	}
+
+	return &zipPackage{
+		reader:   reader,
+		index:    index,
+		modified: make(map[string][]byte),
+		added:    make(map[string][]byte),
+		deleted:  make(map[string]bool),
+	}
+}
+
+// clone returns a copy that shares the archive and its index
+func (p *zipPackage) clone() *zipPackage {
+	return &zipPackage{
+		reader:   p.reader,
+		index:    p.index,
+		modified: maps.Clone(p.modified),
+		added:    maps.Clone(p.added),
+		deleted:  maps.Clone(p.deleted),
+	}
+}
+
+// state returns the state of an entry
+func (p *zipPackage) state(name string) entryState {
+	switch {
+	case p.deleted[name]:
+		return entryDeleted
+	case p.modified[name] != nil:
+		return entryModified
+	case p.added[name] != nil:
+		return entryAdded
+	case p.index[name] != nil:
+		return entryOriginal
+	default:
+		return entryMissing
+	}
+}
+
+// exists reports whether the package currently contains an entry
+func (p *zipPackage) exists(name string) bool {
+	switch p.state(name) {
+	case entryOriginal, entryModified, entryAdded:
+		return true
+	default:
+		return false
+	}
+}
+
+// original returns the archive entry of name, or nil if it was not in the
+// original archive
+func (p *zipPackage) original(name string) *zip.File {
+	return p.index[name]
+}
+
+// changed returns the content of a modified or added entry
+func (p *zipPackage) changed(name string) ([]byte, bool) {
+	if data, ok := p.modified[name]; ok {
+		return data, true
+	}
+	data, ok := p.added[name]
+	return data, ok
+}
+
+// set stores new content for an entry, adding it if necessary
+func (p *zipPackage) set(name string, data []byte) {
+	if data == nil {
+		data = []byte{}
+	}
+	if p.index[name] != nil {
+		delete(p.deleted, name)
+		p.modified[name] = data
+		return
+	}
+	p.added[name] = data
+}
+
+// remove deletes an entry from the package
+func (p *zipPackage) remove(name string) {
+	delete(p.modified, name)
+	delete(p.added, name)
+	if p.index[name] != nil {
+		p.deleted[name] = true
+	}
+}
+
+// dirty reports whether any entry was modified, added or removed
+func (p *zipPackage) dirty() bool {
+	return len(p.modified) > 0 || len(p.added) > 0 || len(p.deleted) > 0
+}
+
+// names returns the entries of the package in archive order, followed by
+// added entries sorted by name
+func (p *zipPackage) names() []string {
+	names := make([]string, 0, len(p.reader.File)+len(p.added))
+	for _, f := range p.reader.File {
+		if !p.deleted[f.Name] {
+			names = append(names, f.Name)
+		}
+	}
+
+	added := make([]string, 0, len(p.added))
+	for name := range p.added {
+		added = append(added, name)
+	}
+	sort.Strings(added)
+
+	return append(names, added...)
+}
@@ -0,0 +1,117 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestZipPackage_States(t *testing.T) {
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"styles.xml", "<styles/>", zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+	})
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newZipPackage(reader)
+	p.set("content.xml", []byte("<new/>"))
+	p.set("Pictures/new.png", []byte(testPNG))
+	p.remove("styles.xml")
+	p.set("Pictures/temp.png", []byte(testPNG))
+	p.remove("Pictures/temp.png")
+
+	tests := []struct {
+		name string
+		want entryState
+	}{
+		{"mimetype", entryOriginal},
+		{"content.xml", entryModified},
+		{"styles.xml", entryDeleted},
+		{"Pictures/new.png", entryAdded},
+		{"Pictures/temp.png", entryMissing},
+		{"settings.xml", entryMissing},
+	}
+	for _, tt := range tests {
+		if got := p.state(tt.name); got != tt.want {
+			t.Errorf("state(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	want := []string{"mimetype", "content.xml", "Pictures/img1.png", "Pictures/new.png"}
+	if got := p.names(); !slices.Equal(got, want) {
+		t.Errorf("names() = %v, want %v", got, want)
+	}
+
+	// Restoring a deleted entry makes it a modified original again
+	p.set("styles.xml", []byte("<styles/>"))
+	if got := p.state("styles.xml"); got != entryModified {
+		t.Errorf("state(styles.xml) after set = %d, want %d", got, entryModified)
+	}
+
+	c := p.clone()
+	c.remove("content.xml")
+	if p.state("content.xml") != entryModified {
+		t.Error("removing from a clone changed the original package")
+	}
+}
+
+func TestManifest_EntryIndex(t *testing.T) {
+	m := newManifest("application/vnd.oasis.opendocument.text")
+	m.Set("content.xml", "text/xml")
+	m.Set("Pictures/a.png", "image/png")
+
+	if entry := m.Entry("Pictures/a.png"); entry == nil || entry.MediaType != "image/png" {
+		t.Errorf("Entry() = %+v", entry)
+	}
+
+	m.Remove("content.xml")
+	if m.Entry("content.xml") != nil {
+		t.Error("Entry() found a removed entry")
+	}
+	if entry := m.Entry("Pictures/a.png"); entry == nil || entry.FullPath != "Pictures/a.png" {
+		t.Errorf("Entry() after Remove = %+v", entry)
+	}
+
+	// Entries changed directly are still found
+	m.Entries = append(m.Entries, ManifestEntry{FullPath: "styles.xml", MediaType: "text/xml"})
+	if m.Entry("styles.xml") == nil {
+		t.Error("Entry() missed an entry appended to Entries")
+	}
+}
+
+func BenchmarkSaveLargePackage(b *testing.B) {
+	entries := []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+	}
+	manifest := newManifest("application/vnd.oasis.opendocument.text")
+	manifest.Set("content.xml", "text/xml")
+	for i := 0; i < 5000; i++ {
+		name := fmt.Sprintf("Pictures/%04d.png", i)
+		entries = append(entries, testEntry{name, testPNG, zip.Deflate})
+		manifest.Set(name, "image/png")
+	}
+	entries = append(entries, testEntry{manifestPath, string(manifest.Bytes()), zip.Deflate})
+	data := buildODT(b, entries)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		doc, err := NewODTDocumentFromBytes(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := doc.ReplaceImageByTag("image1", "Pictures/new.png", []byte(testPNG)); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := doc.SaveToBytes(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
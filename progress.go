@@ -0,0 +1,61 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"sync"
+)
+
+// Progress event types of a replace request, in the order they occur
+const (
+	ProgressTemplateFetched = "template_fetched"
+	ProgressImageFetched    = "image_fetched"
+	ProgressImageReplaced   = "image_replaced"
+	ProgressImageFailed     = "image_failed"
+	ProgressConverted       = "converted"
+	ProgressSaved           = "saved"
+)
+
+// ProgressEvent reports a completed step of a replace request
+type ProgressEvent struct {
+	Type  string `json:"type"`
+	Tag   string `json:"tag,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Done of Total images have completed the current stage, fetching or
+	// replacing
+	Done  int `json:"done,omitempty"`
+	Total int `json:"total,omitempty"`
+	// Size is the size of the template or the saved document in bytes
+	Size int `json:"size,omitempty"`
+}
+
+// ProgressFunc receives the progress events of a replace request. Calls
+// are never concurrent.
+type ProgressFunc func(ProgressEvent)
+
+// serialized returns a ProgressFunc safe to call from several goroutines,
+// or a no-op for a nil f
+func (f ProgressFunc) serialized() ProgressFunc {
+	if f == nil {
+		return func(ProgressEvent) {}
+	}
+	var mu sync.Mutex
+	return func(event ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		f(event)
+	}
+}
+
+// progressConverter reports each successful conversion
+type progressConverter struct {
+	Converter
+	progress ProgressFunc
+}
+
+func (c progressConverter) ConvertContext(ctx context.Context, odt []byte, format string) ([]byte, error) {
+	data, err := convertContext(ctx, c.Converter, odt, format)
+	if err == nil {
+		c.progress(ProgressEvent{Type: ProgressConverted, Size: len(data)})
+	}
+	return data, err
+}
@@ -0,0 +1,116 @@
+package odtimagereplacer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProcessReplaceRequestWithProgress(t *testing.T) {
+	req := ReplaceRequest{
+		Template: TemplateSource{Base64: base64.StdEncoding.EncodeToString(templateTestData(t))},
+		Data: map[string]ImageSource{
+			"image1":  {Base64: base64.StdEncoding.EncodeToString([]byte(testPNG))},
+			"missing": {Base64: "not base64!"},
+		},
+	}
+
+	var types []string
+	_, _, err := ProcessReplaceRequestWithProgress(context.Background(), req, nil, func(event ProgressEvent) {
+		types = append(types, event.Type+":"+event.Tag)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fetch events arrive in completion order
+	if len(types) != 5 {
+		t.Fatalf("events = %v", types)
+	}
+	fetches := append([]string(nil), types[1:3]...)
+	sort.Strings(fetches)
+	want := []string{"template_fetched:", "image_failed:missing", "image_fetched:image1", "image_replaced:image1", "saved:"}
+	got := append([]string{types[0]}, fetches...)
+	got = append(got, types[3:]...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+// readEvents parses a server-sent event stream into event names and data
+func readEvents(t *testing.T, body []byte) (names []string, data []string) {
+	t.Helper()
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event:"); ok {
+			names = append(names, name)
+		}
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, value)
+		}
+	}
+	return names, data
+}
+
+func TestHandleReplaceImages_EventStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := SetupRouter()
+
+	tests := []struct {
+		name      string
+		data      map[string]ImageSource
+		wantFinal string
+	}{
+		{
+			name:      "success",
+			data:      map[string]ImageSource{"image1": {Base64: base64.StdEncoding.EncodeToString([]byte(testPNG))}},
+			wantFinal: "result",
+		},
+		{
+			name:      "failure",
+			data:      map[string]ImageSource{"image1": {Base64: "not base64!"}},
+			wantFinal: "error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(ReplaceRequest{
+				Template: TemplateSource{Base64: base64.StdEncoding.EncodeToString(templateTestData(t))},
+				Data:     tt.data,
+			})
+			req := httptest.NewRequest(http.MethodPost, "/api/replace", bytes.NewReader(body))
+			req.Header.Set("Accept", "text/event-stream")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+				t.Fatalf("Content-Type = %q", ct)
+			}
+			names, data := readEvents(t, w.Body.Bytes())
+			if len(names) < 2 || names[0] != ProgressTemplateFetched || names[len(names)-1] != tt.wantFinal {
+				t.Fatalf("events = %v", names)
+			}
+
+			var final ReplaceResponse
+			if err := json.Unmarshal([]byte(data[len(data)-1]), &final); err != nil {
+				t.Fatal(err)
+			}
+			if final.Success != (tt.wantFinal == "result") || final.Success && final.OutputBase64 == "" {
+				t.Errorf("final event = %+v", final)
+			}
+		})
+	}
+}
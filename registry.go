@@ -0,0 +1,268 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TemplateInfo describes a registered template version
+type TemplateInfo struct {
+	ID      string `json:"id"`
+	Version int    `json:"version"`
+	Size    int    `json:"size"`
+	SHA256  string `json:"sha256"`
+	// Tags are the image names found when the template was uploaded
+	Tags []string `json:"tags"`
+	// Encrypted templates are stored as uploaded; their tags are unknown
+	Encrypted bool      `json:"encrypted,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// TemplateRegistry stores versioned templates by ID in a Storage. Each
+// upload of an ID creates a new version; versions are never reused while
+// the ID exists. A TemplateRegistry is safe for concurrent use.
+type TemplateRegistry struct {
+	storage Storage
+	mu      sync.Mutex // serializes uploads and deletions
+}
+
+// DefaultTemplates resolves template IDs in API requests. It is nil unless
+// configured, e.g. with a FileStorage.
+var DefaultTemplates *TemplateRegistry
+
+// NewTemplateRegistry creates a registry keeping templates in storage
+func NewTemplateRegistry(storage Storage) *TemplateRegistry {
+	return &TemplateRegistry{storage: storage}
+}
+
+// templatePrefix is the storage prefix of all versions of id
+func templatePrefix(id string) string {
+	return "templates/" + id + "/"
+}
+
+func templateKey(id string, version int, ext string) string {
+	return fmt.Sprintf("%s%d.%s", templatePrefix(id), version, ext)
+}
+
+// validateTemplateID rejects IDs that cannot be stored
+func validateTemplateID(id string) error {
+	if !storageIDRegex.MatchString(id) {
+		return fmt.Errorf("%w: invalid template ID %q", ErrInvalidPath, id)
+	}
+	return nil
+}
+
+// Upload stores data as the next version of template id and indexes its
+// image tags
+func (r *TemplateRegistry) Upload(ctx context.Context, id string, data []byte) (*TemplateInfo, error) {
+	if err := validateTemplateID(id); err != nil {
+		return nil, err
+	}
+
+	info := &TemplateInfo{ID: id, Size: len(data), Tags: []string{}}
+	doc, err := NewODTDocumentFromBytes(data)
+	switch {
+	case errors.Is(err, ErrEncrypted):
+		info.Encrypted = true
+	case errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrInvalidODT):
+		return nil, err
+	case err != nil:
+		return nil, fmt.Errorf("%w: %v", ErrInvalidODT, err)
+	default:
+		if info.Tags, err = doc.FindImageTags(); err != nil {
+			return nil, fmt.Errorf("index tags: %w", err)
+		}
+	}
+	sum := sha256.Sum256(data)
+	info.SHA256 = hex.EncodeToString(sum[:])
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if info.Version, err = r.nextVersion(ctx, id); err != nil {
+		return nil, err
+	}
+	info.Created = time.Now().UTC()
+
+	meta, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("encode template info: %w", err)
+	}
+
+	// The info is written last; a version without it does not exist
+	if err := r.storage.Put(ctx, templateKey(id, info.Version, "odt"), data); err != nil {
+		return nil, fmt.Errorf("store template: %w", err)
+	}
+	if err := r.storage.Put(ctx, templateKey(id, info.Version, "json"), meta); err != nil {
+		r.storage.Delete(ctx, templateKey(id, info.Version, "odt"))
+		return nil, fmt.Errorf("store template info: %w", err)
+	}
+	return info, nil
+}
+
+// nextVersion reserves the next version number of id
+func (r *TemplateRegistry) nextVersion(ctx context.Context, id string) (int, error) {
+	key := templatePrefix(id) + "counter"
+
+	last := 0
+	data, err := r.storage.Get(ctx, key)
+	switch {
+	case err == nil:
+		if last, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+			return 0, fmt.Errorf("read version counter of %s: %w", id, err)
+		}
+	case !errors.Is(err, ErrObjectNotFound):
+		return 0, fmt.Errorf("read version counter of %s: %w", id, err)
+	}
+
+	next := last + 1
+	if err := r.storage.Put(ctx, key, []byte(strconv.Itoa(next))); err != nil {
+		return 0, fmt.Errorf("update version counter of %s: %w", id, err)
+	}
+	return next, nil
+}
+
+// Versions returns the info of every version of id, oldest first
+func (r *TemplateRegistry) Versions(ctx context.Context, id string) ([]TemplateInfo, error) {
+	if err := validateTemplateID(id); err != nil {
+		return nil, err
+	}
+
+	keys, err := r.storage.List(ctx, templatePrefix(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []TemplateInfo
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+		data, err := r.storage.Get(ctx, key)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue // deleted concurrently
+		}
+		if err != nil {
+			return nil, err
+		}
+		var info TemplateInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", key, err)
+		}
+		infos = append(infos, info)
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, id)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Version < infos[j].Version })
+	return infos, nil
+}
+
+// Get returns a version of template id and its info; version 0 selects
+// the latest
+func (r *TemplateRegistry) Get(ctx context.Context, id string, version int) ([]byte, *TemplateInfo, error) {
+	versions, err := r.Versions(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &versions[len(versions)-1]
+	if version != 0 {
+		i := sort.Search(len(versions), func(i int) bool { return versions[i].Version >= version })
+		if i == len(versions) || versions[i].Version != version {
+			return nil, nil, fmt.Errorf("%w: %s version %d", ErrTemplateNotFound, id, version)
+		}
+		info = &versions[i]
+	}
+
+	data, err := r.storage.Get(ctx, templateKey(id, info.Version, "odt"))
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, nil, fmt.Errorf("%w: %s version %d", ErrTemplateNotFound, id, info.Version)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, info, nil
+}
+
+// List returns the latest version of every template, sorted by ID
+func (r *TemplateRegistry) List(ctx context.Context) ([]TemplateInfo, error) {
+	keys, err := r.storage.List(ctx, "templates/")
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool)
+	for _, key := range keys {
+		if path.Ext(key) == ".json" {
+			ids[path.Base(path.Dir(key))] = true
+		}
+	}
+
+	infos := make([]TemplateInfo, 0, len(ids))
+	for id := range ids {
+		versions, err := r.Versions(ctx, id)
+		if errors.Is(err, ErrTemplateNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, versions[len(versions)-1])
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos, nil
+}
+
+// Delete removes a version of template id, or every version and the ID
+// itself if version is 0
+func (r *TemplateRegistry) Delete(ctx context.Context, id string, version int) error {
+	versions, err := r.Versions(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if version == 0 {
+		keys, err := r.storage.List(ctx, templatePrefix(id))
+		if err != nil {
+			return err
+		}
+		// Infos go first, so that no version is visible without its data
+		sort.SliceStable(keys, func(i, j int) bool {
+			return strings.HasSuffix(keys[i], ".json") && !strings.HasSuffix(keys[j], ".json")
+		})
+		for _, key := range keys {
+			if err := r.storage.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	found := false
+	for _, info := range versions {
+		found = found || info.Version == version
+	}
+	if !found {
+		return fmt.Errorf("%w: %s version %d", ErrTemplateNotFound, id, version)
+	}
+	if err := r.storage.Delete(ctx, templateKey(id, version, "json")); err != nil {
+		return err
+	}
+	return r.storage.Delete(ctx, templateKey(id, version, "odt"))
+}
@@ -0,0 +1,155 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTemplateRegistry(t *testing.T) {
+	fileStorage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storages := map[string]Storage{
+		"memory": NewMemoryStorage(),
+		"file":   fileStorage,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			registry := NewTemplateRegistry(storage)
+			data := templateTestData(t)
+
+			for want := 1; want <= 3; want++ {
+				info, err := registry.Upload(ctx, "invoice", data)
+				if err != nil {
+					t.Fatalf("Upload() error = %v", err)
+				}
+				if info.Version != want || !reflect.DeepEqual(info.Tags, []string{"image1", "link"}) {
+					t.Errorf("Upload() = %+v, want version %d with tags image1, link", info, want)
+				}
+			}
+			if _, err := registry.Upload(ctx, "letter", data); err != nil {
+				t.Fatal(err)
+			}
+
+			// Deleted versions are not reused
+			if err := registry.Delete(ctx, "invoice", 3); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if info, err := registry.Upload(ctx, "invoice", data); err != nil || info.Version != 4 {
+				t.Errorf("Upload() after Delete() = %+v, %v; want version 4", info, err)
+			}
+
+			versions, err := registry.Versions(ctx, "invoice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var numbers []int
+			for _, v := range versions {
+				numbers = append(numbers, v.Version)
+			}
+			if !reflect.DeepEqual(numbers, []int{1, 2, 4}) {
+				t.Errorf("Versions() = %v, want [1 2 4]", numbers)
+			}
+
+			if got, info, err := registry.Get(ctx, "invoice", 0); err != nil || info.Version != 4 || string(got) != string(data) {
+				t.Errorf("Get(latest) = version %v, %v", info, err)
+			}
+			if _, _, err := registry.Get(ctx, "invoice", 3); !errors.Is(err, ErrTemplateNotFound) {
+				t.Errorf("Get(deleted version) error = %v, want ErrTemplateNotFound", err)
+			}
+
+			list, err := registry.List(ctx)
+			if err != nil || len(list) != 2 || list[0].ID != "invoice" || list[0].Version != 4 || list[1].ID != "letter" {
+				t.Errorf("List() = %+v, %v", list, err)
+			}
+
+			if err := registry.Delete(ctx, "invoice", 0); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := registry.Versions(ctx, "invoice"); !errors.Is(err, ErrTemplateNotFound) {
+				t.Errorf("Versions() after deleting all = %v, want ErrTemplateNotFound", err)
+			}
+		})
+	}
+}
+
+func TestTemplateRegistry_UploadInvalid(t *testing.T) {
+	registry := NewTemplateRegistry(NewMemoryStorage())
+
+	tests := []struct {
+		name    string
+		id      string
+		data    []byte
+		wantErr error
+	}{
+		{"traversal ID", "../invoice", templateTestData(t), ErrInvalidPath},
+		{"slash in ID", "a/b", templateTestData(t), ErrInvalidPath},
+		{"not an ODT", "invoice", []byte("not a zip"), ErrInvalidODT},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := registry.Upload(context.Background(), tt.id, tt.data); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Upload() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := storage.Put(ctx, "../escape", []byte("x")); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Put(../escape) error = %v, want ErrInvalidPath", err)
+	}
+	if err := storage.Put(ctx, "a/b/c.txt", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	// Temporary files of interrupted writes are not listed
+	if err := os.WriteFile(filepath.Join(dir, "a", "b", ".c.txt.tmp-1"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := storage.List(ctx, "a/"); err != nil || !reflect.DeepEqual(keys, []string{"a/b/c.txt"}) {
+		t.Errorf("List() = %v, %v", keys, err)
+	}
+
+	os.Remove(filepath.Join(dir, "a", "b", ".c.txt.tmp-1"))
+	if err := storage.Delete(ctx, "a/b/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Get(ctx, "a/b/c.txt"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrObjectNotFound", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("empty directories left after Delete(): %v", err)
+	}
+}
+
+func TestGetTemplateData_ID(t *testing.T) {
+	defer func(r *TemplateRegistry) { DefaultTemplates = r }(DefaultTemplates)
+	DefaultTemplates = NewTemplateRegistry(NewMemoryStorage())
+	data := templateTestData(t)
+	if _, err := DefaultTemplates.Upload(context.Background(), "invoice", data); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := getTemplateData(context.Background(), TemplateSource{ID: "invoice", Version: 1}, nil)
+	if err != nil || string(got) != string(data) {
+		t.Errorf("getTemplateData() = %d bytes, %v", len(got), err)
+	}
+	if _, err := getTemplateData(context.Background(), TemplateSource{ID: "invoice", Version: 2}, nil); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("getTemplateData(missing version) error = %v, want ErrTemplateNotFound", err)
+	}
+}
@@ -0,0 +1,295 @@
+package odtimagereplacer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// frameNameRegex matches draw:name in the start tag of a draw:frame
+	frameNameRegex = regexp.MustCompile(`^<draw:frame[^>]*\sdraw:name="([^"]*)"`)
+
+	// frameHrefRegex matches the value of the first xlink:href in a frame
+	frameHrefRegex = regexp.MustCompile(`xlink:href="([^"]*)"`)
+
+	// frameTitleRegex matches the svg:title of a frame
+	frameTitleRegex = regexp.MustCompile(`<svg:title>([^<]*)</svg:title>`)
+
+	// unsafeNameRegex matches characters replaced when deriving image names
+	unsafeNameRegex = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// SelectorKind is the frame property a Selector matches
+type SelectorKind string
+
+// Selector kinds
+const (
+	// SelectByName matches the draw:name of a frame
+	SelectByName SelectorKind = "name"
+
+	// SelectByHref matches the current xlink:href of the frame's image
+	SelectByHref SelectorKind = "href"
+
+	// SelectByTitle matches the svg:title of a frame, such as "{logo}"
+	SelectByTitle SelectorKind = "title"
+)
+
+// Selector identifies the frames whose image is replaced
+type Selector struct {
+	Kind  SelectorKind
+	Value string
+}
+
+// ByName selects frames by draw:name
+func ByName(name string) Selector {
+	return Selector{Kind: SelectByName, Value: name}
+}
+
+// ByHref selects frames by the path of their current image
+func ByHref(href string) Selector {
+	return Selector{Kind: SelectByHref, Value: href}
+}
+
+// ByTitle selects frames by svg:title
+func ByTitle(title string) Selector {
+	return Selector{Kind: SelectByTitle, Value: title}
+}
+
+// String returns the selector as kind:value
+func (s Selector) String() string {
+	return string(s.Kind) + ":" + s.Value
+}
+
+// Image is a replacement image
+type Image struct {
+	Data []byte
+
+	// Path is the location inside the package. It defaults to
+	// Pictures/<name>.<ext>, with the extension detected from Data and a
+	// number added if another selector already uses that path.
+	Path string
+}
+
+// ReplaceResult is the outcome of replacing the frames of one selector
+type ReplaceResult struct {
+	Selector Selector
+	Path     string // location the image was stored at
+	Frames   int    // number of frames updated
+	Err      error  // nil if the image was replaced
+}
+
+// frameRef is a draw:frame in content.xml with the position of its image
+// reference. Values are unescaped.
+type frameRef struct {
+	name, href, title  string
+	hrefStart, hrefEnd int
+}
+
+// indexFrames finds every draw:frame with an image reference
+func indexFrames(content string) []frameRef {
+	var frames []frameRef
+	for _, loc := range drawFrameRegex.FindAllStringIndex(content, -1) {
+		frame := content[loc[0]:loc[1]]
+		href := frameHrefRegex.FindStringSubmatchIndex(frame)
+		if href == nil {
+			continue
+		}
+
+		ref := frameRef{
+			href:      html.UnescapeString(frame[href[2]:href[3]]),
+			hrefStart: loc[0] + href[2],
+			hrefEnd:   loc[0] + href[3],
+		}
+		if name := frameNameRegex.FindStringSubmatch(frame); name != nil {
+			ref.name = html.UnescapeString(name[1])
+		}
+		if title := frameTitleRegex.FindStringSubmatch(frame); title != nil {
+			ref.title = html.UnescapeString(title[1])
+		}
+		frames = append(frames, ref)
+	}
+	return frames
+}
+
+// hrefEdit replaces the image reference of a frame
+type hrefEdit struct {
+	start, end int
+	path       string
+}
+
+// spliceHrefs applies edits to content in a single pass
+func spliceHrefs(content string, edits []hrefEdit) string {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var sb strings.Builder
+	sb.Grow(len(content))
+	last := 0
+	for _, e := range edits {
+		sb.WriteString(content[last:e.start])
+		xml.EscapeText(&sb, []byte(e.path))
+		last = e.end
+	}
+	sb.WriteString(content[last:])
+	return sb.String()
+}
+
+// uniqueImagePath numbers path until no other selector uses it
+func uniqueImagePath(path string, claimed map[string]Selector) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for n := 2; ; n++ {
+		if _, taken := claimed[path]; !taken {
+			return path
+		}
+		path = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+}
+
+// defaultImagePath derives the package path of a replacement image from
+// its selector
+func defaultImagePath(sel Selector, data []byte) string {
+	name := sel.Value
+	switch sel.Kind {
+	case SelectByHref:
+		name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	case SelectByTitle:
+		name = strings.Trim(unsafeNameRegex.ReplaceAllString(name, "_"), "_")
+	}
+	return imagePathFor(name, data)
+}
+
+// ReplaceImages replaces the images of all selected frames in one pass over
+// content.xml. A frame matched by several selectors uses the name
+// selector, then href, then title. Per-selector failures such as unknown
+// frames or invalid images are reported in the results, sorted by
+// selector; the returned error is set only if the document could not be
+// modified at all.
+func (doc *ODTDocument) ReplaceImages(images map[Selector]Image) ([]ReplaceResult, error) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	return doc.replaceImages(images)
+}
+
+// replaceImages implements ReplaceImages with the lock held
+func (doc *ODTDocument) replaceImages(images map[Selector]Image) ([]ReplaceResult, error) {
+	content, err := doc.getContentXML()
+	if err != nil {
+		return nil, err
+	}
+
+	selectors := make([]Selector, 0, len(images))
+	for sel := range images {
+		selectors = append(selectors, sel)
+	}
+	sort.Slice(selectors, func(i, j int) bool {
+		if selectors[i].Kind != selectors[j].Kind {
+			return selectors[i].Kind < selectors[j].Kind
+		}
+		return selectors[i].Value < selectors[j].Value
+	})
+
+	// Check the images before touching the document
+	results := make([]ReplaceResult, len(selectors))
+	index := make(map[Selector]int, len(selectors))
+	claimed := make(map[string]Selector, len(selectors))
+	for i, sel := range selectors {
+		image := images[sel]
+		result := &results[i]
+		result.Selector = sel
+
+		// Selectors such as name "logo" and title "{logo}" derive the same
+		// default path; later ones get a numbered path instead
+		result.Path = image.Path
+		if result.Path == "" {
+			result.Path = uniqueImagePath(defaultImagePath(sel, image.Data), claimed)
+		}
+
+		switch {
+		case len(image.Data) == 0:
+			result.Err = fmt.Errorf("image data cannot be empty")
+		case len(image.Data) > MaxIndividualFileSize:
+			result.Err = fmt.Errorf("%w: image size %d exceeds limit", ErrFileTooLarge, len(image.Data))
+		default:
+			result.Err = validateImageName(filepath.Base(result.Path))
+		}
+		if other, taken := claimed[result.Path]; result.Err == nil && taken {
+			result.Err = fmt.Errorf("image path %s is already used by %s", result.Path, other)
+		}
+		if result.Err == nil {
+			index[sel] = i
+			claimed[result.Path] = sel
+		}
+	}
+
+	// Match every frame against the selectors
+	var edits []hrefEdit
+	var names []string
+	for _, frame := range indexFrames(content) {
+		i, ok := index[ByName(frame.name)]
+		if !ok {
+			i, ok = index[ByHref(frame.href)]
+		}
+		if !ok && frame.title != "" {
+			i, ok = index[ByTitle(frame.title)]
+		}
+		if !ok {
+			continue
+		}
+
+		results[i].Frames++
+		edits = append(edits, hrefEdit{frame.hrefStart, frame.hrefEnd, results[i].Path})
+		names = append(names, frame.name)
+	}
+
+	parts := []string{"content.xml", manifestPath}
+	for i := range results {
+		result := &results[i]
+		if result.Err == nil && result.Frames == 0 {
+			result.Err = fmt.Errorf("%w: %s", ErrImageNotFound, result.Selector)
+		}
+		if result.Err == nil {
+			parts = append(parts, result.Path)
+		}
+	}
+	if len(edits) == 0 {
+		return results, nil
+	}
+
+	// Either every replacement is applied or none
+	before := doc.clone()
+
+	// Apply the signature policy before touching signed parts
+	if err := doc.checkSignatures(parts...); err != nil {
+		doc.restore(before)
+		return nil, err
+	}
+
+	doc.setFile("content.xml", []byte(spliceHrefs(content, edits)))
+
+	for i := range results {
+		result := &results[i]
+		if result.Err != nil {
+			continue
+		}
+		data := images[result.Selector].Data
+		if err := doc.updateManifestEntry(result.Path, data); err != nil {
+			doc.restore(before)
+			return nil, err
+		}
+		doc.setFile(result.Path, data)
+	}
+
+	for _, name := range names {
+		if name != "" {
+			doc.replaced[name] = true
+		}
+	}
+
+	return results, nil
+}
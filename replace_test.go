@@ -0,0 +1,179 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestODTDocument_ReplaceImages(t *testing.T) {
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", testContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"Pictures/photo.jpg", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", testManifestXML, zip.Deflate},
+	})
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	jpeg := []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01")
+	results, err := doc.ReplaceImages(map[Selector]Image{
+		ByTitle("{img1}"):            {Data: []byte(testPNG)},
+		ByHref("Pictures/photo.jpg"): {Data: jpeg, Path: "Pictures/new-photo.jpg"},
+		ByName("missing"):            {Data: []byte(testPNG)},
+		ByName("empty"):              {},
+	})
+	if err != nil {
+		t.Fatalf("ReplaceImages() error = %v", err)
+	}
+
+	tests := []struct {
+		selector Selector
+		path     string
+		frames   int
+		wantErr  bool
+	}{
+		{ByHref("Pictures/photo.jpg"), "Pictures/new-photo.jpg", 1, false},
+		{ByName("empty"), "Pictures/empty.png", 0, true},
+		{ByName("missing"), "Pictures/missing.png", 0, true},
+		{ByTitle("{img1}"), "Pictures/img1.png", 1, false},
+	}
+	if len(results) != len(tests) {
+		t.Fatalf("ReplaceImages() returned %d results, want %d", len(results), len(tests))
+	}
+	for i, tt := range tests {
+		r := results[i]
+		if r.Selector != tt.selector || r.Path != tt.path || r.Frames != tt.frames || (r.Err != nil) != tt.wantErr {
+			t.Errorf("results[%d] = %+v, want %s at %s (%d frames, error %v)",
+				i, r, tt.selector, tt.path, tt.frames, tt.wantErr)
+		}
+	}
+	if !errors.Is(results[2].Err, ErrImageNotFound) {
+		t.Errorf("missing frame error = %v, want ErrImageNotFound", results[2].Err)
+	}
+
+	content, err := doc.getContentXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content, `xlink:href="Pictures/new-photo.jpg"`) {
+		t.Errorf("content.xml does not reference the new photo:\n%s", content)
+	}
+	if image, err := doc.readFile("Pictures/new-photo.jpg"); err != nil || string(image) != string(jpeg) {
+		t.Errorf("new photo = %q, %v", image, err)
+	}
+	manifest, err := doc.loadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := manifest.Entry("Pictures/new-photo.jpg"); entry == nil || entry.MediaType != "image/jpeg" {
+		t.Errorf("manifest entry = %+v, want image/jpeg", entry)
+	}
+}
+
+func TestODTDocument_ReplaceImagesSelectorPriority(t *testing.T) {
+	doc, err := NewODTDocumentFromBytes(templateTestData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both selectors match the same frame; the name selector wins
+	results, err := doc.ReplaceImages(map[Selector]Image{
+		ByName("image1"):            {Data: []byte(testPNG), Path: "Pictures/by-name.png"},
+		ByHref("Pictures/img1.png"): {Data: []byte(testPNG), Path: "Pictures/by-href.png"},
+	})
+	if err != nil {
+		t.Fatalf("ReplaceImages() error = %v", err)
+	}
+	for _, r := range results {
+		if r.Selector.Kind == SelectByName && r.Err != nil {
+			t.Errorf("name selector error = %v", r.Err)
+		}
+		if r.Selector.Kind == SelectByHref && !errors.Is(r.Err, ErrImageNotFound) {
+			t.Errorf("href selector error = %v, want ErrImageNotFound", r.Err)
+		}
+	}
+}
+
+func TestODTDocument_ReplaceImagesRefusesSignedParts(t *testing.T) {
+	doc := signedTestDoc(t)
+	doc.SetSignaturePolicy(SignatureRefuse)
+
+	_, err := doc.ReplaceImages(map[Selector]Image{ByName("image1"): {Data: []byte(testPNG)}})
+	if !errors.Is(err, ErrSignedPart) {
+		t.Fatalf("ReplaceImages() error = %v, want ErrSignedPart", err)
+	}
+	if doc.pkg.state("content.xml") != entryOriginal {
+		t.Error("content.xml modified after refusal")
+	}
+}
+
+func TestODTDocument_ReplaceImagesSharedDefaultPath(t *testing.T) {
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0"
+    xmlns:xlink="http://www.w3.org/1999/xlink"
+    xmlns:svg="urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0"
+    xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0">
+    <office:body>
+        <draw:frame draw:name="logo"><draw:image xlink:href="Pictures/a.png" /></draw:frame>
+        <draw:frame draw:name="banner"><draw:image xlink:href="Pictures/b.png" /><svg:title>{logo}</svg:title></draw:frame>
+    </office:body>
+</office:document-content>`
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", content, zip.Deflate},
+		{"Pictures/a.png", testPNG, zip.Deflate},
+		{"Pictures/b.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", testManifestXML, zip.Deflate},
+	})
+
+	t.Run("default paths", func(t *testing.T) {
+		doc, err := NewODTDocumentFromBytes(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Both selectors default to Pictures/logo.png
+		results, err := doc.ReplaceImages(map[Selector]Image{
+			ByName("logo"):    {Data: []byte(testPNG + "name")},
+			ByTitle("{logo}"): {Data: []byte(testPNG + "title")},
+		})
+		if err != nil {
+			t.Fatalf("ReplaceImages() error = %v", err)
+		}
+		if results[0].Err != nil || results[1].Err != nil || results[0].Path == results[1].Path {
+			t.Fatalf("ReplaceImages() results = %+v, want two distinct paths", results)
+		}
+		for _, r := range results {
+			want := testPNG + map[SelectorKind]string{SelectByName: "name", SelectByTitle: "title"}[r.Selector.Kind]
+			if image, err := doc.readFile(r.Path); err != nil || string(image) != want {
+				t.Errorf("%s image at %s = %q, %v", r.Selector, r.Path, image, err)
+			}
+		}
+	})
+
+	t.Run("explicit path", func(t *testing.T) {
+		doc, err := NewODTDocumentFromBytes(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := doc.ReplaceImages(map[Selector]Image{
+			ByName("logo"):    {Data: []byte(testPNG + "name")},
+			ByTitle("{logo}"): {Data: []byte(testPNG + "title"), Path: "Pictures/logo.png"},
+		})
+		if err != nil {
+			t.Fatalf("ReplaceImages() error = %v", err)
+		}
+		if results[0].Err != nil || results[1].Err == nil {
+			t.Errorf("ReplaceImages() results = %+v, want the title selector to fail", results)
+		}
+		if image, err := doc.readFile("Pictures/logo.png"); err != nil || string(image) != testPNG+"name" {
+			t.Errorf("Pictures/logo.png = %q, %v", image, err)
+		}
+	})
+}
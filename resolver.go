@@ -0,0 +1,173 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// SourceResolver resolves image sources of one scheme, such as
+// "asset:logo", to their data. ref is the part after the colon.
+type SourceResolver interface {
+	Resolve(ctx context.Context, ref string) ([]byte, error)
+}
+
+// SourceResolverFunc adapts a function to SourceResolver
+type SourceResolverFunc func(ctx context.Context, ref string) ([]byte, error)
+
+// Resolve calls f(ctx, ref)
+func (f SourceResolverFunc) Resolve(ctx context.Context, ref string) ([]byte, error) {
+	return f(ctx, ref)
+}
+
+var (
+	resolversMu sync.RWMutex
+	resolvers   = map[string]SourceResolver{
+		"data": SourceResolverFunc(resolveDataURI),
+	}
+)
+
+// RegisterResolver makes r resolve sources of scheme; a nil r removes the
+// scheme. Built in are "data" for data URIs and, once configured, "file"
+// (FileResolver) and "asset" (AssetResolver).
+func RegisterResolver(scheme string, r SourceResolver) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if r == nil {
+		delete(resolvers, scheme)
+		return
+	}
+	resolvers[scheme] = r
+}
+
+// resolveSource resolves a "scheme:ref" source with the registered resolver
+func resolveSource(ctx context.Context, source string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	scheme, ref, ok := strings.Cut(source, ":")
+	if !ok || scheme == "" {
+		return nil, fmt.Errorf("%w: %q has no scheme", ErrUnknownSource, source)
+	}
+
+	resolversMu.RLock()
+	r := resolvers[strings.ToLower(scheme)]
+	resolversMu.RUnlock()
+	if r == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSource, scheme)
+	}
+
+	data, err := r.Resolve(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s source: %w", scheme, err)
+	}
+	if len(data) > MaxIndividualFileSize {
+		return nil, fmt.Errorf("%w: %s source exceeds limit", ErrFileTooLarge, scheme)
+	}
+	return data, nil
+}
+
+// isDataURI reports whether s is a data URI
+func isDataURI(s string) bool {
+	return len(s) > 5 && strings.EqualFold(s[:5], "data:")
+}
+
+// resolveDataURI decodes the part of a data URI after "data:", either
+// base64 or percent-encoded
+func resolveDataURI(ctx context.Context, ref string) ([]byte, error) {
+	params, payload, ok := strings.Cut(ref, ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid data URI: missing comma")
+	}
+
+	if strings.HasSuffix(strings.ToLower(params), ";base64") {
+		return decodeBase64Lenient(payload)
+	}
+
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data URI: %w", err)
+	}
+	return []byte(data), nil
+}
+
+// decodeBase64Lenient decodes standard or URL-safe base64, with or
+// without padding, ignoring whitespace and line breaks
+func decodeBase64Lenient(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		case '-':
+			return '+'
+		case '_':
+			return '/'
+		}
+		return r
+	}, s)
+
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode base64: %w", err)
+	}
+	return data, nil
+}
+
+// FileResolver resolves paths relative to Root. Paths cannot leave Root,
+// neither with ".." nor through symbolic links.
+type FileResolver struct {
+	Root string
+}
+
+// Resolve reads the file at ref below Root
+func (r FileResolver) Resolve(ctx context.Context, ref string) ([]byte, error) {
+	name := filepath.ToSlash(ref)
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPath, ref)
+	}
+
+	root, err := os.OpenRoot(r.Root)
+	if err != nil {
+		return nil, fmt.Errorf("open file root: %w", err)
+	}
+	defer root.Close()
+
+	f, err := root.Open(filepath.FromSlash(name))
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(&contextReader{ctx, f}, MaxIndividualFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	return data, nil
+}
+
+// AssetStore holds stored images by ID
+type AssetStore interface {
+	// Get returns the data of an asset, or an error wrapping
+	// ErrAssetNotFound
+	Get(ctx context.Context, id string) ([]byte, error)
+}
+
+// AssetResolver resolves asset IDs from Store
+type AssetResolver struct {
+	Store AssetStore
+}
+
+// Resolve returns the data of the asset ref
+func (r AssetResolver) Resolve(ctx context.Context, ref string) ([]byte, error) {
+	return r.Store.Get(ctx, ref)
+}
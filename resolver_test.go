@@ -0,0 +1,132 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDecodeBase64Image_Formats(t *testing.T) {
+	// 0xfb 0xff encodes to characters that differ between the alphabets
+	raw := append([]byte(testPNG), 0xfb, 0xff)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    []byte
+		wantErr bool
+	}{
+		{"standard", base64.StdEncoding.EncodeToString(raw), raw, false},
+		{"unpadded", base64.RawStdEncoding.EncodeToString(raw), raw, false},
+		{"URL-safe", base64.URLEncoding.EncodeToString(raw), raw, false},
+		{"URL-safe unpadded", base64.RawURLEncoding.EncodeToString(raw), raw, false},
+		{"line breaks", base64.StdEncoding.EncodeToString(raw)[:8] + "\r\n" + base64.StdEncoding.EncodeToString(raw)[8:], raw, false},
+		{"data URI", "data:image/png;base64," + base64.StdEncoding.EncodeToString(raw), raw, false},
+		{"percent-encoded data URI", "data:image/svg+xml,%3Csvg%3E%3C/svg%3E", []byte("<svg></svg>"), false},
+		{"data URI without comma", "data:image/png;base64", nil, true},
+		{"invalid", "not base64!", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBase64Image(context.Background(), tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeBase64Image() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != string(tt.want) {
+				t.Errorf("decodeBase64Image() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	if err := os.MkdirAll(filepath.Join(root, "logos"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "logos", "acme.png"), []byte(testPNG), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret.png"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		if err := os.Symlink(filepath.Join(dir, "secret.png"), filepath.Join(root, "link.png")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ref     string
+		wantErr bool
+	}{
+		{"logos/acme.png", false},
+		{"../secret.png", true},
+		{"/etc/passwd", true},
+		{"logos/../../secret.png", true},
+		{"link.png", runtime.GOOS != "windows"},
+		{"missing.png", true},
+	}
+
+	resolver := FileResolver{Root: root}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			data, err := resolver.Resolve(context.Background(), tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(data) != testPNG {
+				t.Errorf("Resolve() = %q", data)
+			}
+		})
+	}
+}
+
+func TestGetImageData_Source(t *testing.T) {
+	RegisterResolver("db", SourceResolverFunc(func(ctx context.Context, ref string) ([]byte, error) {
+		if ref != "blob/42" {
+			return nil, os.ErrNotExist
+		}
+		return []byte(testPNG), nil
+	}))
+	defer RegisterResolver("db", nil)
+
+	assets := NewAssetLibrary(NewMemoryStorage())
+	if _, err := assets.Upload(context.Background(), "logo", []byte(testPNG), nil); err != nil {
+		t.Fatal(err)
+	}
+	RegisterResolver("asset", AssetResolver{Store: assets})
+	defer RegisterResolver("asset", nil)
+
+	tests := []struct {
+		source  string
+		wantErr error
+	}{
+		{"db:blob/42", nil},
+		{"DB:blob/42", nil},
+		{"db:blob/7", os.ErrNotExist},
+		{"asset:logo", nil},
+		{"asset:missing", ErrAssetNotFound},
+		{"asset:../logo", ErrAssetNotFound},
+		{"ftp:host/logo.png", ErrUnknownSource},
+		{"logo", ErrUnknownSource},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			data, err := getImageData(context.Background(), ImageSource{Source: tt.source}, nil, FetchOptions{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("getImageData() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && string(data) != testPNG {
+				t.Errorf("getImageData() = %q", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package odtimagereplacer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SaveOptions controls how Save writes a document to disk
+type SaveOptions struct {
+	// Backup keeps the previous file as <path>.bak
+	Backup bool
+}
+
+// DefaultSaveOptions are the options used by Save
+var DefaultSaveOptions = SaveOptions{}
+
+// SaveWithOptions writes the document to outputPath atomically: the data is
+// written to a temporary file in the same directory, synced and renamed
+// over the target, so a crash never leaves a partially written file. The
+// mode of an existing file is preserved.
+func (doc *ODTDocument) SaveWithOptions(outputPath string, opts SaveOptions) error {
+	// Validate output path
+	if err := validatePath(outputPath); err != nil {
+		return err
+	}
+
+	data, err := doc.SaveToBytes()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(outputPath, data, opts.Backup)
+}
+
+// writeFileAtomic replaces path with data using a temporary file and rename
+func writeFileAtomic(path string, data []byte, backup bool) error {
+	mode := os.FileMode(0644)
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%w: %s is not a regular file", ErrInvalidPath, path)
+		}
+		mode = info.Mode().Perm()
+	case !os.IsNotExist(err):
+		return fmt.Errorf("stat output file: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return fmt.Errorf("set file mode: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+
+	if backup && info != nil {
+		if err := backupFile(path); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace output file: %w", err)
+	}
+	committed = true
+
+	// Persist the rename; not every platform can sync a directory
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// backupFile keeps the current content of path as path.bak. A hard link is
+// used where possible so the backup costs no extra space or time.
+func backupFile(path string) error {
+	bak := path + ".bak"
+	if err := os.Remove(bak); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove old backup: %w", err)
+	}
+	if err := os.Link(path, bak); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open file for backup: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("stat file for backup: %w", err)
+	}
+	dst, err := os.OpenFile(bak, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("create backup: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("write backup: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return fmt.Errorf("sync backup: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("close backup: %w", err)
+	}
+	return nil
+}
+
+// FileLock is an advisory lock on a document, held by LockFile
+type FileLock struct {
+	file *os.File
+	path string
+}
+
+// LockFile takes an exclusive advisory lock on path, waiting until other
+// holders release it. The lock is held on <path>.lock so that it survives
+// the rename performed by Save; processes that lock the same path before
+// reading and saving it cannot interleave. Locking is a no-op on platforms
+// without flock.
+func LockFile(path string) (*FileLock, error) {
+	if err := validatePath(path); err != nil {
+		return nil, err
+	}
+	lockPath := path + ".lock"
+
+	for {
+		f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open lock file: %w", err)
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+
+		// The previous holder may have removed the lock file while we
+		// waited; retry on the file that is now in place
+		held, err := f.Stat()
+		if err != nil {
+			unlockFile(f)
+			f.Close()
+			return nil, fmt.Errorf("stat lock file: %w", err)
+		}
+		if current, err := os.Stat(lockPath); err == nil && os.SameFile(held, current) {
+			return &FileLock{file: f, path: lockPath}, nil
+		}
+		unlockFile(f)
+		f.Close()
+	}
+}
+
+// Unlock releases the lock and removes the lock file
+func (l *FileLock) Unlock() error {
+	// Remove before unlocking so that waiters notice the stale file
+	os.Remove(l.path)
+	if err := unlockFile(l.file); err != nil {
+		l.file.Close()
+		return fmt.Errorf("unlock: %w", err)
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,107 @@
+package odtimagereplacer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestODTDocument_SaveWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.odt")
+	original := templateTestData(t)
+	if err := os.WriteFile(path, original, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := NewODTDocument(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.ReplaceImageByTag("image1", "Pictures/new.png", []byte(testPNG)); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.SaveWithOptions(path, SaveOptions{Backup: true}); err != nil {
+		t.Fatalf("SaveWithOptions() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	saved, err := NewODTDocument(path)
+	if err != nil {
+		t.Fatalf("saved file does not open: %v", err)
+	}
+	if _, err := saved.readFile("Pictures/new.png"); err != nil {
+		t.Errorf("saved file lacks the new image: %v", err)
+	}
+
+	backup, err := os.ReadFile(path + ".bak")
+	if err != nil || !bytes.Equal(backup, original) {
+		t.Errorf("backup differs from the original file (err %v)", err)
+	}
+
+	// Only the document and its backup remain
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("directory contains %v, want report.odt and report.odt.bak", names)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("advisory locks are not supported on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "report.odt")
+
+	lock, err := LockFile(path)
+	if err != nil {
+		t.Fatalf("LockFile() error = %v", err)
+	}
+
+	acquired := make(chan *FileLock)
+	go func() {
+		second, err := LockFile(path)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- second
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second LockFile() returned while the lock was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	select {
+	case second := <-acquired:
+		if second != nil {
+			second.Unlock()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second LockFile() did not return after Unlock()")
+	}
+
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package odtimagereplacer
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// nsDS is the XML digital signature namespace
+const nsDS = "http://www.w3.org/2000/09/xmldsig#"
+
+// Signature files stored in META-INF
+const (
+	documentSignaturesPath = "META-INF/documentsignatures.xml"
+	macroSignaturesPath    = "META-INF/macrosignatures.xml"
+	packageSignaturesPath  = "META-INF/packagesignatures.xml"
+)
+
+// signatureFiles lists the signature files in the order they are reported
+var signatureFiles = []string{documentSignaturesPath, macroSignaturesPath, packageSignaturesPath}
+
+// SignaturePolicy controls what happens when a signed part is modified
+type SignaturePolicy string
+
+const (
+	// SignatureWarn allows the modification and reports the signature in
+	// InvalidatedSignatures
+	SignatureWarn SignaturePolicy = "warn"
+
+	// SignatureRefuse rejects modifications with ErrSignedPart
+	SignatureRefuse SignaturePolicy = "refuse"
+
+	// SignatureStrip removes the signature file before the modification so
+	// that recipients see an unsigned document instead of a broken signature
+	SignatureStrip SignaturePolicy = "strip"
+)
+
+// Signature describes a digital signature stored in the package
+type Signature struct {
+	ID     string   `json:"id,omitempty"`
+	File   string   `json:"file"`
+	Signer string   `json:"signer,omitempty"`
+	Date   string   `json:"date,omitempty"`
+	Parts  []string `json:"parts"`
+}
+
+// covers reports whether modifying a part breaks the signature. Document
+// signatures must reference every part, so adding a part breaks them too.
+func (s *Signature) covers(part string, exists bool) bool {
+	if slices.Contains(s.Parts, part) {
+		return true
+	}
+	return s.File == documentSignaturesPath && !exists && !slices.Contains(signatureFiles, part)
+}
+
+// SetSignaturePolicy selects how modifications of signed parts are handled.
+// The default is SignatureWarn.
+func (doc *ODTDocument) SetSignaturePolicy(policy SignaturePolicy) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	doc.signaturePolicy = policy
+}
+
+// Signatures returns the digital signatures stored in the package
+func (doc *ODTDocument) Signatures() ([]Signature, error) {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	return doc.signatures()
+}
+
+// signatures parses the signature files present in the package
+func (doc *ODTDocument) signatures() ([]Signature, error) {
+	signatures := make([]Signature, 0)
+	for _, file := range signatureFiles {
+		data, err := doc.readFile(file)
+		if err != nil {
+			continue
+		}
+		parsed, err := parseSignatures(file, data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidODT, err)
+		}
+		signatures = append(signatures, parsed...)
+	}
+	return signatures, nil
+}
+
+// InvalidatedSignatures returns the signatures broken or stripped by
+// modifications made so far
+func (doc *ODTDocument) InvalidatedSignatures() []Signature {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	return slices.Clone(doc.invalidated)
+}
+
+// StripSignatures removes all signature files from the package
+func (doc *ODTDocument) StripSignatures() error {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	signatures, err := doc.signatures()
+	if err != nil {
+		return err
+	}
+	doc.stripSignatures(signatures)
+	return nil
+}
+
+// stripSignatures removes the files holding the given signatures
+func (doc *ODTDocument) stripSignatures(signatures []Signature) {
+	for _, file := range signatureFiles {
+		if !slices.ContainsFunc(signatures, func(s Signature) bool { return s.File == file }) {
+			continue
+		}
+		if m, err := doc.loadManifest(); err == nil {
+			if m = m.clone(); m.Remove(file) {
+				doc.storeManifest(m)
+			}
+		}
+		doc.deleteFile(file)
+	}
+}
+
+// checkSignatures applies the signature policy before parts are modified.
+// It returns ErrSignedPart under SignatureRefuse if any part is signed.
+func (doc *ODTDocument) checkSignatures(parts ...string) error {
+	signatures, err := doc.signatures()
+	if err != nil || len(signatures) == 0 {
+		return err
+	}
+
+	var broken []Signature
+	var brokenPart string
+	for _, s := range signatures {
+		for _, part := range parts {
+			if s.covers(part, doc.pkg.exists(part)) {
+				broken = append(broken, s)
+				brokenPart = part
+				break
+			}
+		}
+	}
+	if len(broken) == 0 {
+		return nil
+	}
+
+	switch doc.signaturePolicy {
+	case SignatureRefuse:
+		return fmt.Errorf("%w: %s (signed by %s)", ErrSignedPart, brokenPart, signerName(broken[0]))
+	case SignatureStrip:
+		doc.stripSignatures(broken)
+	}
+
+	for _, s := range broken {
+		if !slices.ContainsFunc(doc.invalidated, func(i Signature) bool { return i.File == s.File && i.ID == s.ID }) {
+			doc.invalidated = append(doc.invalidated, s)
+		}
+	}
+	return nil
+}
+
+// signerName returns a readable name for error messages
+func signerName(s Signature) string {
+	if s.Signer == "" {
+		return "unknown signer"
+	}
+	return s.Signer
+}
+
+// parseSignatures reads the ds:Signature elements of a signature file
+func parseSignatures(file string, data []byte) ([]Signature, error) {
+	root, err := parseXMLTree(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+
+	var signatures []Signature
+	r := &textRenderer{}
+	walkXMLTree(root, func(n *xmlNode) {
+		if !n.is(nsDS, "Signature") {
+			return
+		}
+
+		s := Signature{ID: n.attr("", "Id"), File: file, Parts: make([]string, 0)}
+		walkXMLTree(n, func(child *xmlNode) {
+			switch {
+			case child.is(nsDS, "Reference"):
+				// References starting with # point into the signature itself
+				uri := child.attr("", "URI")
+				if uri == "" || strings.HasPrefix(uri, "#") {
+					return
+				}
+				if unescaped, err := url.PathUnescape(uri); err == nil {
+					uri = unescaped
+				}
+				s.Parts = append(s.Parts, uri)
+			case child.is(nsDS, "X509Certificate") && s.Signer == "":
+				s.Signer = certificateSubject(r.plain(child))
+			case child.is(nsDC, "date") && s.Date == "":
+				s.Date = strings.TrimSpace(r.plain(child))
+			}
+		})
+		signatures = append(signatures, s)
+	})
+
+	return signatures, nil
+}
+
+// certificateSubject returns the common name, or full subject, of a base64
+// DER certificate
+func certificateSubject(encoded string) string {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return ""
+	}
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
@@ -0,0 +1,160 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a base64 self-signed certificate for commonName
+func testCertificate(t *testing.T, commonName string) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+// signatureXML returns a signature file with one signature over parts
+func signatureXML(root, id, cert string, parts ...string) string {
+	xml := `<?xml version="1.0" encoding="UTF-8"?>
+<` + root + ` xmlns="urn:oasis:names:tc:opendocument:xmlns:digitalsignature:1.0">
+<Signature xmlns="http://www.w3.org/2000/09/xmldsig#" Id="` + id + `"><SignedInfo>`
+	for _, part := range parts {
+		xml += `<Reference URI="` + part + `"><DigestValue>AAAA</DigestValue></Reference>`
+	}
+	xml += `<Reference URI="#idSignedProperties"/></SignedInfo><SignatureValue>AAAA</SignatureValue>
+<KeyInfo><X509Data><X509Certificate>` + cert + `</X509Certificate></X509Data></KeyInfo>
+<Object><SignatureProperties><SignatureProperty><dc:date xmlns:dc="http://purl.org/dc/elements/1.1/">2026-03-01T10:00:00</dc:date></SignatureProperty></SignatureProperties></Object>
+</Signature></` + root + `>`
+	return xml
+}
+
+// signedTestData returns a package with a document signature and a macro
+// signature
+func signedTestData(t *testing.T) []byte {
+	t.Helper()
+
+	cert := testCertificate(t, "Test Signer")
+	return buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"Basic/Standard/Module1.xml", "<module/>", zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+		{documentSignaturesPath, signatureXML("document-signatures", "doc1", cert,
+			"content.xml", "Pictures/img1.png", "META-INF/manifest.xml"), zip.Deflate},
+		{macroSignaturesPath, signatureXML("document-signatures", "macro1", cert,
+			"Basic/Standard/Module1.xml"), zip.Deflate},
+	})
+}
+
+// signedTestDoc opens signedTestData
+func signedTestDoc(t *testing.T) *ODTDocument {
+	t.Helper()
+
+	doc, err := NewODTDocumentFromBytes(signedTestData(t))
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+	return doc
+}
+
+func TestODTDocument_Signatures(t *testing.T) {
+	doc := signedTestDoc(t)
+
+	signatures, err := doc.Signatures()
+	if err != nil {
+		t.Fatalf("Signatures() error = %v", err)
+	}
+	if len(signatures) != 2 {
+		t.Fatalf("Signatures() returned %d signatures, want 2", len(signatures))
+	}
+
+	s := signatures[0]
+	if s.ID != "doc1" || s.File != documentSignaturesPath {
+		t.Errorf("signature = %s in %s", s.ID, s.File)
+	}
+	if s.Signer != "Test Signer" {
+		t.Errorf("Signer = %q, want Test Signer", s.Signer)
+	}
+	if s.Date != "2026-03-01T10:00:00" {
+		t.Errorf("Date = %q", s.Date)
+	}
+	if !slices.Equal(s.Parts, []string{"content.xml", "Pictures/img1.png", "META-INF/manifest.xml"}) {
+		t.Errorf("Parts = %v", s.Parts)
+	}
+}
+
+func TestODTDocument_SignaturePolicy(t *testing.T) {
+	tests := []struct {
+		policy        SignaturePolicy
+		wantErr       error
+		wantRemaining int
+	}{
+		{SignatureWarn, nil, 2},
+		{SignatureRefuse, ErrSignedPart, 2},
+		{SignatureStrip, nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			doc := signedTestDoc(t)
+			doc.SetSignaturePolicy(tt.policy)
+
+			err := doc.ReplaceImageByTag("image1", "Pictures/new.png", []byte(testPNG))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReplaceImageByTag() error = %v, want %v", err, tt.wantErr)
+			}
+
+			data, err := doc.SaveToBytes()
+			if err != nil {
+				t.Fatalf("SaveToBytes() error = %v", err)
+			}
+			saved, err := NewODTDocumentFromBytes(data)
+			if err != nil {
+				t.Fatalf("reopen error = %v", err)
+			}
+			remaining, err := saved.Signatures()
+			if err != nil {
+				t.Fatalf("Signatures() error = %v", err)
+			}
+			if len(remaining) != tt.wantRemaining {
+				t.Errorf("saved document has %d signatures, want %d", len(remaining), tt.wantRemaining)
+			}
+
+			// Only the document signature covers the replaced image
+			invalidated := doc.InvalidatedSignatures()
+			if tt.wantErr != nil {
+				if len(invalidated) != 0 {
+					t.Errorf("InvalidatedSignatures() = %v after refusal", invalidated)
+				}
+				return
+			}
+			if len(invalidated) != 1 || invalidated[0].ID != "doc1" {
+				t.Errorf("InvalidatedSignatures() = %v, want doc1", invalidated)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// hrefSpan is the position of an xlink:href value in content.xml
+type hrefSpan struct {
+	start, end int
+}
+
+// Template is a pre-parsed ODT package for rendering many documents from
+// the same template. Frames, the manifest and the raw ZIP entries are
+// indexed once; Render only splices content.xml and copies the remaining
+// entries without decompressing them. Encrypted templates and regenerated
+// thumbnails need the whole document, so they are rendered from a clone
+// of it instead.
+//
+// Rendering changes content.xml, which breaks document signatures; the
+// signature policy of the options is applied once when the Template is
+// created. A Template is immutable and safe for concurrent use.
+type Template struct {
+	reader      *zip.Reader
+	mimetype    []byte
+	content     string
+	frames      map[string][]hrefSpan
+	tags        []string
+	manifest    *Manifest
+	signatures  []Signature
+	invalidated []Signature
+
+	// meta is refreshed with stats on every render if set
+	meta  []byte
+	stats map[string]int
+
+	// doc renders templates the ZIP entries cannot be copied from
+	doc *ODTDocument
+}
+
+// TemplateOptions control the documents rendered from a Template. The
+// zero value renders like Render always has: no metadata changes, the
+// thumbnail kept and invalidated signatures reported.
+type TemplateOptions struct {
+	// Password opens an encrypted template; outputs are encrypted with it
+	Password string
+	// Meta is set on every output
+	Meta *DocumentMeta
+	// RefreshMeta updates dates, generator and statistics on every render,
+	// like SetMetaRefresh
+	RefreshMeta bool
+	// Thumbnail is applied like SetThumbnailMode, with Converter
+	Thumbnail ThumbnailMode
+	Converter Converter
+	// Signatures is applied like SetSignaturePolicy
+	Signatures SignaturePolicy
+}
+
+// NewTemplate parses an ODT package for repeated rendering with the zero
+// TemplateOptions
+func NewTemplate(data []byte) (*Template, error) {
+	return NewTemplateWithOptions(data, TemplateOptions{})
+}
+
+// NewTemplateWithOptions parses an ODT package for repeated rendering.
+// Changes shared by every output, such as opts.Meta, are made once here.
+// Under SignatureRefuse, a template whose signatures rendering would
+// break returns ErrSignedPart.
+func NewTemplateWithOptions(data []byte, opts TemplateOptions) (*Template, error) {
+	doc, err := NewODTDocumentFromBytesWithPassword(data, opts.Password)
+	if err != nil {
+		return nil, err
+	}
+	signatures, err := doc.Signatures()
+	if err != nil {
+		return nil, err
+	}
+
+	doc.SetSignaturePolicy(opts.Signatures)
+	doc.SetMetaRefresh(opts.RefreshMeta)
+	doc.SetThumbnailMode(opts.Thumbnail)
+	doc.SetConverter(opts.Converter)
+
+	// Every render changes content.xml and the manifest
+	if opts.Meta != nil {
+		if err := doc.SetMeta(*opts.Meta); err != nil {
+			return nil, err
+		}
+	}
+	if err := doc.checkSignatures("content.xml", manifestPath); err != nil {
+		return nil, err
+	}
+
+	if doc.password != "" || opts.Thumbnail == ThumbnailRegenerate {
+		content, err := doc.getContentXML()
+		if err != nil {
+			return nil, err
+		}
+		t := &Template{signatures: signatures, doc: doc}
+		t.indexFrames(content)
+		return t, nil
+	}
+
+	// The rest of the edits made on save are made once, so that the
+	// signature policy applies to them here
+	if opts.RefreshMeta {
+		if err := doc.applyMetaRefresh(); err != nil {
+			return nil, fmt.Errorf("refresh meta: %w", err)
+		}
+	}
+	if err := doc.applyThumbnailMode(context.Background()); err != nil {
+		return nil, fmt.Errorf("update thumbnail: %w", err)
+	}
+	invalidated := doc.InvalidatedSignatures()
+
+	// Entries are copied from the archive, so edits are saved first
+	if doc.pkg.dirty() {
+		if data, err = doc.writePackage(context.Background()); err != nil {
+			return nil, err
+		}
+		if doc, err = NewODTDocumentFromBytes(data); err != nil {
+			return nil, err
+		}
+	}
+
+	content, err := doc.getContentXML()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := doc.loadManifest()
+	if err != nil && !errors.Is(err, ErrManifestNotFound) {
+		return nil, err
+	}
+
+	mimetype, err := doc.readFile("mimetype")
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing mimetype", ErrInvalidODT)
+	}
+
+	if manifest != nil {
+		manifest = manifest.clone()
+	}
+
+	t := &Template{
+		reader:      doc.pkg.reader,
+		mimetype:    mimetype,
+		content:     content,
+		manifest:    manifest,
+		signatures:  signatures,
+		invalidated: invalidated,
+	}
+	if opts.RefreshMeta {
+		if t.meta, err = doc.readFile(metaPath); err != nil {
+			return nil, err
+		}
+		// Replacing images does not change the statistics
+		if t.stats, err = doc.documentStatistics(); err != nil {
+			return nil, err
+		}
+	}
+	t.indexFrames(content)
+
+	return t, nil
+}
+
+// indexFrames records the image reference of every named frame
+func (t *Template) indexFrames(content string) {
+	t.frames = make(map[string][]hrefSpan)
+	for _, frame := range indexFrames(content) {
+		if frame.name == "" {
+			continue
+		}
+		if _, ok := t.frames[frame.name]; !ok {
+			t.tags = append(t.tags, frame.name)
+		}
+		t.frames[frame.name] = append(t.frames[frame.name], hrefSpan{frame.hrefStart, frame.hrefEnd})
+	}
+}
+
+// Tags returns the draw:name of every frame that can be replaced
+func (t *Template) Tags() []string {
+	return slices.Clone(t.tags)
+}
+
+// Signatures returns the signatures of the template
+func (t *Template) Signatures() []Signature {
+	return slices.Clone(t.signatures)
+}
+
+// InvalidatedSignatures returns the signatures of the template that are
+// broken, or stripped under SignatureStrip, in every rendered document
+func (t *Template) InvalidatedSignatures() []Signature {
+	if t.doc != nil {
+		return t.doc.InvalidatedSignatures()
+	}
+	return slices.Clone(t.invalidated)
+}
+
+// Render produces a new ODT with the images of the given frames replaced.
+// Images are stored as Pictures/<tag>.<ext> like the HTTP API does.
+func (t *Template) Render(images map[string][]byte) ([]byte, error) {
+	if t.doc != nil {
+		return t.renderDocument(images)
+	}
+
+	tags := make([]string, 0, len(images))
+	for tag := range images {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	// Resolve the new image paths and the references to rewrite
+	var edits []hrefEdit
+	paths := make(map[string][]byte, len(images))
+	var manifest *Manifest
+	if t.manifest != nil {
+		manifest = t.manifest.clone()
+	}
+
+	for _, tag := range tags {
+		data := images[tag]
+		if len(data) == 0 {
+			return nil, fmt.Errorf("image data for tag '%s' cannot be empty", tag)
+		}
+		if len(data) > MaxIndividualFileSize {
+			return nil, fmt.Errorf("%w: image size %d exceeds limit", ErrFileTooLarge, len(data))
+		}
+
+		spans, ok := t.frames[tag]
+		if !ok {
+			return nil, fmt.Errorf("%w: tag '%s'", ErrImageNotFound, tag)
+		}
+
+		path := imagePathFor(tag, data)
+		if err := validateImageName(filepath.Base(path)); err != nil {
+			return nil, err
+		}
+		for _, span := range spans {
+			edits = append(edits, hrefEdit{span.start, span.end, path})
+		}
+		paths[path] = data
+
+		if manifest != nil {
+			mediaType := mediaTypeFor(path, data)
+			if mediaType == "" {
+				mediaType = detectMIMEType(path)
+			}
+			manifest.Set(path, mediaType)
+		}
+	}
+
+	// Splice the new references into content.xml
+	content := spliceHrefs(t.content, edits)
+
+	buf := new(bytes.Buffer)
+	writer := zip.NewWriter(buf)
+
+	// The mimetype must be the first entry and stored uncompressed
+	if err := writeZipEntry(writer, "mimetype", zip.Store, t.mimetype); err != nil {
+		return nil, err
+	}
+
+	for _, f := range t.reader.File {
+		var err error
+		switch {
+		case f.Name == "mimetype" || paths[f.Name] != nil:
+			continue
+		case f.Name == "content.xml":
+			err = writeZipEntry(writer, f.Name, zip.Deflate, []byte(content))
+		case f.Name == manifestPath && manifest != nil:
+			err = writeZipEntry(writer, f.Name, zip.Deflate, manifest.Bytes())
+		case f.Name == metaPath && t.meta != nil:
+			var meta []byte
+			if meta, err = editMetaXML(t.meta, metaRefresh(t.stats)); err == nil {
+				err = writeZipEntry(writer, f.Name, zip.Deflate, meta)
+			}
+		default:
+			// Unchanged parts are copied without recompressing them
+			err = copyZipEntry(writer, f)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, tag := range tags {
+		path := imagePathFor(tag, images[tag])
+		data, ok := paths[path]
+		if !ok {
+			continue
+		}
+		if err := writeZipEntry(writer, path, zip.Deflate, data); err != nil {
+			return nil, err
+		}
+		delete(paths, path)
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("close zip writer: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renderDocument renders from a clone of the template document, which
+// encrypts the output and renders thumbnails on save
+func (t *Template) renderDocument(images map[string][]byte) ([]byte, error) {
+	selected := make(map[Selector]Image, len(images))
+	for tag, data := range images {
+		if len(data) == 0 {
+			return nil, fmt.Errorf("image data for tag '%s' cannot be empty", tag)
+		}
+		selected[ByName(tag)] = Image{Data: data}
+	}
+
+	doc := t.doc.Clone()
+	results, err := doc.ReplaceImages(selected)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			return nil, result.Err
+		}
+	}
+	return doc.SaveToBytes()
+}
+
+// imagePathFor returns the package path for a replacement image, using
+// the extension detected from its content and .png otherwise
+func imagePathFor(tag string, data []byte) string {
+	ext := detectImageExtension(data)
+	if ext == "" {
+		ext = ".png"
+	}
+	return "Pictures/" + tag + ext
+}
@@ -0,0 +1,267 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// templateTestData returns a package with two named frames
+func templateTestData(t *testing.T) []byte {
+	t.Helper()
+
+	return buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+}
+
+func TestTemplate_RenderMatchesReplaceImageByTag(t *testing.T) {
+	data := templateTestData(t)
+
+	tmpl, err := NewTemplate(data)
+	if err != nil {
+		t.Fatalf("NewTemplate() error = %v", err)
+	}
+	if got := tmpl.Tags(); len(got) != 2 || got[0] != "image1" || got[1] != "link" {
+		t.Errorf("Tags() = %v", got)
+	}
+
+	jpeg := "\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01"
+	rendered, err := tmpl.Render(map[string][]byte{"image1": []byte(jpeg)})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	want, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := want.ReplaceImageByTag("image1", "Pictures/image1.jpg", []byte(jpeg)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewODTDocumentFromBytes(rendered)
+	if err != nil {
+		t.Fatalf("rendered output does not open: %v", err)
+	}
+	gotContent, _ := got.getContentXML()
+	wantContent, _ := want.getContentXML()
+	if gotContent != wantContent {
+		t.Errorf("content.xml = %s\nwant %s", gotContent, wantContent)
+	}
+
+	image, err := got.readFile("Pictures/image1.jpg")
+	if err != nil || string(image) != jpeg {
+		t.Errorf("rendered image = %q, %v", image, err)
+	}
+	if report := got.Validate(); !report.Valid {
+		t.Errorf("Validate() findings = %+v", report.Findings)
+	}
+}
+
+func TestTemplate_RenderErrors(t *testing.T) {
+	tmpl, err := NewTemplate(templateTestData(t))
+	if err != nil {
+		t.Fatalf("NewTemplate() error = %v", err)
+	}
+
+	if _, err := tmpl.Render(map[string][]byte{"missing": []byte(testPNG)}); !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("Render() unknown tag error = %v, want ErrImageNotFound", err)
+	}
+	if _, err := tmpl.Render(map[string][]byte{"image1": nil}); err == nil {
+		t.Error("Render() accepted empty image data")
+	}
+}
+
+func TestTemplate_RenderConcurrent(t *testing.T) {
+	tmpl, err := NewTemplate(templateTestData(t))
+	if err != nil {
+		t.Fatalf("NewTemplate() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			image := fmt.Sprintf("%s-%d", testPNG, i)
+			rendered, err := tmpl.Render(map[string][]byte{"image1": []byte(image)})
+			if err != nil {
+				errs <- err
+				return
+			}
+			doc, err := NewODTDocumentFromBytes(rendered)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if data, err := doc.readFile("Pictures/image1.png"); err != nil || string(data) != image {
+				errs <- fmt.Errorf("render %d: image = %q, %v", i, data, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestTemplate_SignaturePolicy(t *testing.T) {
+	data := signedTestData(t)
+	images := map[string][]byte{"image1": []byte(testPNG)}
+
+	if _, err := NewTemplateWithOptions(data, TemplateOptions{Signatures: SignatureRefuse}); !errors.Is(err, ErrSignedPart) {
+		t.Errorf("NewTemplateWithOptions(refuse) error = %v, want ErrSignedPart", err)
+	}
+
+	tests := []struct {
+		policy       SignaturePolicy
+		wantDocument bool // documentsignatures.xml is still in the output
+	}{
+		{SignatureWarn, true},
+		{SignatureStrip, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			tmpl, err := NewTemplateWithOptions(data, TemplateOptions{Signatures: tt.policy})
+			if err != nil {
+				t.Fatalf("NewTemplateWithOptions() error = %v", err)
+			}
+			// Macros are not changed, so only the document signature breaks
+			if got := tmpl.InvalidatedSignatures(); len(got) != 1 || got[0].ID != "doc1" {
+				t.Errorf("InvalidatedSignatures() = %+v", got)
+			}
+
+			rendered, err := tmpl.Render(images)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			doc, err := NewODTDocumentFromBytes(rendered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := doc.pkg.exists(documentSignaturesPath); got != tt.wantDocument {
+				t.Errorf("documentsignatures.xml in output = %v, want %v", got, tt.wantDocument)
+			}
+			if !doc.pkg.exists(macroSignaturesPath) {
+				t.Error("macro signature missing from output")
+			}
+		})
+	}
+}
+
+func TestTemplate_MetaAndThumbnail(t *testing.T) {
+	fixed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{thumbnailPath, testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	tmpl, err := NewTemplateWithOptions(data, TemplateOptions{
+		Meta:        &DocumentMeta{Title: "Invoice"},
+		RefreshMeta: true,
+		Thumbnail:   ThumbnailRemove,
+	})
+	if err != nil {
+		t.Fatalf("NewTemplateWithOptions() error = %v", err)
+	}
+
+	// Every render is dated when it is made
+	fixed = fixed.Add(time.Hour)
+	rendered, err := tmpl.Render(map[string][]byte{"image1": []byte(testPNG)})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	doc, err := NewODTDocumentFromBytes(rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta, err := doc.Meta(); err != nil || meta.Title != "Invoice" {
+		t.Errorf("Meta() = %+v, %v", meta, err)
+	}
+	raw, _ := doc.readFile(metaPath)
+	for _, want := range []string{"<dc:date>2026-01-02T04:04:05Z</dc:date>", "<meta:generator>" + Generator, `meta:image-count="2"`} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("meta.xml missing %s:\n%s", want, raw)
+		}
+	}
+	if doc.pkg.exists(thumbnailPath) {
+		t.Error("thumbnail not removed")
+	}
+	if report := doc.Validate(); !report.Valid {
+		t.Errorf("Validate() findings = %+v", report.Findings)
+	}
+}
+
+func TestTemplate_Encrypted(t *testing.T) {
+	data := encryptedTestDoc(t, "secret")
+
+	if _, err := NewTemplate(data); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("NewTemplate(encrypted) error = %v, want ErrEncrypted", err)
+	}
+
+	tmpl, err := NewTemplateWithOptions(data, TemplateOptions{Password: "secret"})
+	if err != nil {
+		t.Fatalf("NewTemplateWithOptions() error = %v", err)
+	}
+	rendered, err := tmpl.Render(map[string][]byte{"image1": []byte(testPNG + "new")})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	// The output is encrypted with the template password
+	if _, err := NewODTDocumentFromBytes(rendered); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("rendered output opens without a password: %v", err)
+	}
+	doc, err := NewODTDocumentFromBytesWithPassword(rendered, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image, err := doc.readFile("Pictures/image1.png"); err != nil || string(image) != testPNG+"new" {
+		t.Errorf("rendered image = %q, %v", image, err)
+	}
+}
+
+func BenchmarkTemplateRender(b *testing.B) {
+	data := buildODT(b, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	tmpl, err := NewTemplate(data)
+	if err != nil {
+		b.Fatalf("NewTemplate() error = %v", err)
+	}
+	images := map[string][]byte{"image1": []byte(testPNG)}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := tmpl.Render(images); err != nil {
+				b.Errorf("Render() error = %v", err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,227 @@
+//go:build ignore
+
+// genencrypted writes the password-protected fixtures used by
+// encryption_test.go. It follows the manifest and cipher layout of
+// LibreOffice and deliberately does not use the package under test:
+//
+//	encrypted-cbc-pbkdf2.odt   per-part AES-256-CBC, SHA-256 start key,
+//	                           PBKDF2 (HMAC-SHA1, 100000 iterations),
+//	                           sha256-1k checksum (LibreOffice 3.5 to 7.x)
+//	encrypted-gcm-argon2id.odt wholesome AES-256-GCM, SHA-256 start key,
+//	                           Argon2id (3 passes, 64 MiB, 4 lanes)
+//	                           (LibreOffice 24.2 and later)
+//
+// Run it from the repository root with: go run testdata/genencrypted.go
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// password opens both fixtures
+const password = "Fixture-Passw0rd"
+
+const mimeType = "application/vnd.oasis.opendocument.text"
+
+const contentXML = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:style="urn:oasis:names:tc:opendocument:xmlns:style:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:svg="urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0" office:version="1.3"><office:automatic-styles><style:style style:name="fr1" style:family="graphic" style:parent-style-name="Graphics"/></office:automatic-styles><office:body><office:text><text:p text:style-name="Standard">Encrypted fixture</text:p><text:p text:style-name="Standard"><draw:frame draw:style-name="fr1" draw:name="image1" text:anchor-type="as-char" svg:width="2cm" svg:height="2cm" draw:z-index="0"><draw:image xlink:href="Pictures/img1.png" xlink:type="simple" xlink:show="embed" xlink:actuate="onLoad" draw:mime-type="image/png"/></draw:frame></text:p></office:text></office:body></office:document-content>`
+
+const stylesXML = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-styles xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:style="urn:oasis:names:tc:opendocument:xmlns:style:1.0" office:version="1.3"><office:styles><style:style style:name="Standard" style:family="paragraph" style:class="text"/><style:style style:name="Graphics" style:family="graphic"/></office:styles></office:document-styles>`
+
+const metaXML = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-meta xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:meta="urn:oasis:names:tc:opendocument:xmlns:meta:1.0" xmlns:dc="http://purl.org/dc/elements/1.1/" office:version="1.3"><office:meta><meta:generator>LibreOffice/24.2.7.2$Linux_X86_64 LibreOffice_project/420$Build-2</meta:generator><dc:title>Encrypted fixture</dc:title><meta:document-statistic meta:image-count="1" meta:paragraph-count="1"/></office:meta></office:document-meta>`
+
+const (
+	nsManifest = "urn:oasis:names:tc:opendocument:xmlns:manifest:1.0"
+	nsLoext    = "urn:org:documentfoundation:names:experimental:office:xmlns:loext:1.0"
+)
+
+// part is one file of a package
+type part struct {
+	name, mediaType string
+	data            []byte
+}
+
+func main() {
+	var img bytes.Buffer
+	canvas := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	canvas.Set(0, 0, color.RGBA{R: 0xff, A: 0xff})
+	if err := png.Encode(&img, canvas); err != nil {
+		log.Fatal(err)
+	}
+
+	parts := []part{
+		{"content.xml", "text/xml", []byte(contentXML)},
+		{"styles.xml", "text/xml", []byte(stylesXML)},
+		{"meta.xml", "text/xml", []byte(metaXML)},
+		{"Pictures/img1.png", "image/png", img.Bytes()},
+	}
+
+	write("encrypted-cbc-pbkdf2.odt", cbcPackage(parts))
+	write("encrypted-gcm-argon2id.odt", gcmPackage(parts))
+}
+
+func write(name string, data []byte) {
+	path := filepath.Join("testdata", name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("wrote", path)
+}
+
+// cbcPackage encrypts every part except mimetype and the manifest
+func cbcPackage(parts []part) []byte {
+	var entries strings.Builder
+	files := make([]part, 0, len(parts))
+	for _, p := range parts {
+		salt, iv := random(16), random(16)
+		startKey := sha256.Sum256([]byte(password))
+		key, err := pbkdf2.Key(sha1.New, string(startKey[:]), salt, 100000, 32)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		compressed := deflate(p.data)
+		checksum := sha256.Sum256(compressed[:min(len(compressed), 1024)])
+
+		// W3C padding: random filler, the last byte is the padding length
+		padding := aes.BlockSize - len(compressed)%aes.BlockSize
+		padded := append(append([]byte{}, compressed...), random(padding)...)
+		padded[len(padded)-1] = byte(padding)
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ciphertext := make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+		fmt.Fprintf(&entries, ` <manifest:file-entry manifest:full-path="%s" manifest:media-type="%s" manifest:size="%d">
+  <manifest:encryption-data manifest:checksum-type="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0#sha256-1k" manifest:checksum="%s">
+   <manifest:algorithm manifest:algorithm-name="http://www.w3.org/2001/04/xmlenc#aes256-cbc" manifest:initialisation-vector="%s"/>
+   <manifest:start-key-generation manifest:start-key-generation-name="http://www.w3.org/2000/09/xmldsig#sha256" manifest:key-size="32"/>
+   <manifest:key-derivation manifest:key-derivation-name="PBKDF2" manifest:key-size="32" manifest:iteration-count="100000" manifest:salt="%s"/>
+  </manifest:encryption-data>
+ </manifest:file-entry>
+`, p.name, p.mediaType, len(p.data), b64(checksum[:]), b64(iv), b64(salt))
+
+		// Encrypted parts are stored, the ciphertext does not compress
+		files = append(files, part{p.name, "", ciphertext})
+	}
+
+	return zipPackage(manifest(entries.String(), ""), files, zip.Store)
+}
+
+// gcmPackage encrypts a complete plain package as the encrypted-package part
+func gcmPackage(parts []part) []byte {
+	var entries strings.Builder
+	for _, p := range parts {
+		fmt.Fprintf(&entries, " <manifest:file-entry manifest:full-path=\"%s\" manifest:media-type=\"%s\"/>\n", p.name, p.mediaType)
+	}
+	inner := zipPackage(manifest(entries.String(), ""), parts, zip.Deflate)
+
+	salt, iv := random(16), random(12)
+	startKey := sha256.Sum256([]byte(password))
+	key := argon2.IDKey(startKey[:], salt, 3, 65536, 4, 32)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ciphertext := aead.Seal(nil, iv, deflate(inner), nil)
+
+	entry := fmt.Sprintf(` <manifest:file-entry manifest:full-path="encrypted-package" manifest:media-type="" manifest:size="%d">
+  <manifest:encryption-data>
+   <manifest:algorithm manifest:algorithm-name="http://www.w3.org/2009/xmlenc11#aes256-gcm" manifest:initialisation-vector="%s"/>
+   <manifest:start-key-generation manifest:start-key-generation-name="http://www.w3.org/2000/09/xmldsig#sha256" manifest:key-size="32"/>
+   <manifest:key-derivation manifest:key-derivation-name="urn:org:documentfoundation:names:experimental:office:manifest:argon2id" manifest:key-size="32" manifest:salt="%s" loext:argon2-iterations="3" loext:argon2-memory="65536" loext:argon2-lanes="4"/>
+  </manifest:encryption-data>
+ </manifest:file-entry>
+`, len(inner), b64(iv), b64(salt))
+
+	outer := []part{{"encrypted-package", "", ciphertext}}
+	return zipPackage(manifest(entry, ` xmlns:loext="`+nsLoext+`"`), outer, zip.Store)
+}
+
+// manifest returns manifest.xml with the root entry followed by entries
+func manifest(entries, extraNS string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="` + nsManifest + `" manifest:version="1.3"` + extraNS + `>
+ <manifest:file-entry manifest:full-path="/" manifest:version="1.3" manifest:media-type="` + mimeType + `"/>
+` + entries + `</manifest:manifest>
+`)
+}
+
+// zipPackage writes mimetype first and uncompressed, then files with
+// method, then the deflated manifest
+func zipPackage(manifest []byte, files []part, method uint16) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	add := func(name string, data []byte, method uint16) {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := f.Write(data); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	add("mimetype", []byte(mimeType), zip.Store)
+	for _, f := range files {
+		add(f.name, f.data, method)
+	}
+	add("META-INF/manifest.xml", manifest, zip.Deflate)
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// deflate compresses data as a raw deflate stream without a zlib header
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func random(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func b64(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,552 @@
+package odtimagereplacer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// OpenDocument namespace URIs used when walking content.xml
+const (
+	nsOffice = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	nsText   = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+	nsTable  = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	nsDraw   = "urn:oasis:names:tc:opendocument:xmlns:drawing:1.0"
+	nsSVG    = "urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0"
+	nsXLink  = "http://www.w3.org/1999/xlink"
+
+	// nsXML is bound to the xml: prefix without being declared
+	nsXML = "http://www.w3.org/XML/1998/namespace"
+)
+
+// maxRepeatedCells caps table:number-columns-repeated expansion for cells
+// that carry content, so a malformed table cannot blow up the output
+const maxRepeatedCells = 256
+
+// xmlNode is a minimal element tree used for text extraction
+type xmlNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     string // character data; set only on text nodes
+}
+
+// attr returns the value of the attribute with the given namespace and local name
+func (n *xmlNode) attr(space, local string) string {
+	for _, a := range n.attrs {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// is reports whether the node is the element space:local
+func (n *xmlNode) is(space, local string) bool {
+	return n.name.Space == space && n.name.Local == local
+}
+
+// parseXMLTree parses an XML document into an xmlNode tree
+func parseXMLTree(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse XML: %w", err)
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name, attrs: t.Attr}
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			parent.children = append(parent.children, &xmlNode{text: string(t)})
+		}
+	}
+
+	return root, nil
+}
+
+// marshalXMLTree serializes a tree read by parseXMLTree. Names are written
+// with the prefixes the document declares for their namespaces, so the
+// output keeps the original bindings.
+func marshalXMLTree(root *xmlNode) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	for _, child := range root.children {
+		if child.name.Local != "" {
+			writeXMLNode(&buf, child, map[string]string{nsXML: "xml"})
+		}
+	}
+	return buf.Bytes()
+}
+
+// writeXMLNode writes n and its descendants; prefixes maps the namespaces
+// declared by the ancestors of n to their prefixes
+func writeXMLNode(buf *bytes.Buffer, n *xmlNode, prefixes map[string]string) {
+	if n.name.Local == "" {
+		// Unlike xml.EscapeText, the encoder keeps newlines in text as they are
+		e := xml.NewEncoder(buf)
+		e.EncodeToken(xml.CharData(n.text))
+		e.Flush()
+		return
+	}
+
+	scope := prefixes
+	for _, a := range n.attrs {
+		switch {
+		case a.Name.Space == "xmlns":
+			scope = maps.Clone(scope)
+			scope[a.Value] = a.Name.Local
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			scope = maps.Clone(scope)
+			scope[a.Value] = ""
+		}
+	}
+
+	name := qualifiedName(n.name, scope)
+	buf.WriteString("<" + name)
+	for _, a := range n.attrs {
+		writeAttr(buf, qualifiedName(a.Name, scope), a.Value)
+	}
+	if len(n.children) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteString(">")
+	for _, child := range n.children {
+		writeXMLNode(buf, child, scope)
+	}
+	buf.WriteString("</" + name + ">")
+}
+
+// qualifiedName returns the prefixed form of a name resolved by the decoder
+func qualifiedName(name xml.Name, prefixes map[string]string) string {
+	switch name.Space {
+	case "":
+		return name.Local
+	case "xmlns":
+		return "xmlns:" + name.Local
+	}
+
+	prefix, ok := prefixes[name.Space]
+	switch {
+	case !ok:
+		// The decoder leaves undeclared prefixes in place of the namespace
+		return name.Space + ":" + name.Local
+	case prefix == "":
+		return name.Local
+	}
+	return prefix + ":" + name.Local
+}
+
+// declareNamespace binds uri on element n unless n already declares it,
+// using prefix or, if that is bound to another namespace, a numbered one
+func declareNamespace(n *xmlNode, uri, prefix string) {
+	bound := make(map[string]bool)
+	for _, a := range n.attrs {
+		if a.Name.Space == "xmlns" {
+			if a.Value == uri {
+				return
+			}
+			bound[a.Name.Local] = true
+		}
+	}
+
+	candidate := prefix
+	for i := 2; bound[candidate]; i++ {
+		candidate = prefix + strconv.Itoa(i)
+	}
+	n.attrs = append(n.attrs, xml.Attr{Name: xml.Name{Space: "xmlns", Local: candidate}, Value: uri})
+}
+
+// ExtractText returns the document body as plain text. Paragraphs and
+// headings are separated by newlines, list items are prefixed with "- ",
+// table cells are tab separated and images are rendered as their alt text.
+func (doc *ODTDocument) ExtractText() (string, error) {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	return doc.extract(false)
+}
+
+// ExtractMarkdown returns the document body as Markdown, including
+// headings, nested lists, tables, links and images with alt text
+func (doc *ODTDocument) ExtractMarkdown() (string, error) {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	return doc.extract(true)
+}
+
+// extract parses content.xml and renders the office:body
+func (doc *ODTDocument) extract(markdown bool) (string, error) {
+	content, err := doc.getContentXML()
+	if err != nil {
+		return "", err
+	}
+
+	root, err := parseXMLTree([]byte(content))
+	if err != nil {
+		return "", err
+	}
+
+	r := &textRenderer{markdown: markdown}
+	blocks := r.blocks(root, 0)
+
+	sep := "\n"
+	if markdown {
+		sep = "\n\n"
+	}
+	return strings.Join(blocks, sep), nil
+}
+
+// textRenderer converts an OpenDocument element tree to text or Markdown
+type textRenderer struct {
+	markdown bool
+}
+
+// blocks renders block-level content (paragraphs, headings, lists, tables)
+func (r *textRenderer) blocks(n *xmlNode, depth int) []string {
+	var out []string
+	for _, child := range n.children {
+		switch {
+		case child.name.Local == "":
+			// Whitespace between block elements
+		case child.is(nsText, "p"):
+			out = appendBlock(out, r.inline(child))
+		case child.is(nsText, "h"):
+			out = appendBlock(out, r.heading(child))
+		case child.is(nsText, "list"):
+			out = appendBlock(out, r.list(child, depth))
+		case child.is(nsTable, "table"):
+			out = appendBlock(out, r.table(child))
+		case child.is(nsDraw, "frame"):
+			out = appendBlock(out, r.frame(child))
+		case r.skipped(child):
+		default:
+			out = append(out, r.blocks(child, depth)...)
+		}
+	}
+	return out
+}
+
+// appendBlock appends a block unless it is blank
+func appendBlock(blocks []string, block string) []string {
+	if strings.TrimSpace(block) == "" {
+		return blocks
+	}
+	return append(blocks, block)
+}
+
+// skipped reports whether an element carries no body text worth extracting
+func (r *textRenderer) skipped(n *xmlNode) bool {
+	if n.name.Space == nsOffice {
+		switch n.name.Local {
+		case "annotation", "annotation-end", "forms", "scripts", "font-face-decls", "automatic-styles":
+			return true
+		}
+	}
+	if n.name.Space == nsText {
+		switch n.name.Local {
+		case "tracked-changes", "sequence-decls", "variable-decls", "user-field-decls",
+			"note", "table-of-content-source", "alphabetical-index-source",
+			"illustration-index-source", "table-index-source", "bibliography-source":
+			return true
+		}
+	}
+	return false
+}
+
+// heading renders a text:h element
+func (r *textRenderer) heading(n *xmlNode) string {
+	text := r.inline(n)
+	if !r.markdown || strings.TrimSpace(text) == "" {
+		return text
+	}
+
+	level, err := strconv.Atoi(n.attr(nsText, "outline-level"))
+	if err != nil || level < 1 {
+		level = 1
+	}
+	if level > 6 {
+		level = 6
+	}
+	return strings.Repeat("#", level) + " " + text
+}
+
+// inline renders the character content of a paragraph-like element
+func (r *textRenderer) inline(n *xmlNode) string {
+	var sb strings.Builder
+	for _, child := range n.children {
+		switch {
+		case child.name.Local == "":
+			sb.WriteString(r.escape(collapseSpace(child.text)))
+		case child.is(nsText, "s"):
+			count, err := strconv.Atoi(child.attr(nsText, "c"))
+			if err != nil || count < 1 {
+				count = 1
+			}
+			sb.WriteString(strings.Repeat(" ", count))
+		case child.is(nsText, "tab"):
+			sb.WriteString("\t")
+		case child.is(nsText, "line-break"):
+			if r.markdown {
+				sb.WriteString("  ")
+			}
+			sb.WriteString("\n")
+		case child.is(nsText, "a"):
+			sb.WriteString(r.link(child))
+		case child.is(nsDraw, "frame"):
+			sb.WriteString(r.frame(child))
+		case r.skipped(child):
+		default:
+			sb.WriteString(r.inline(child))
+		}
+	}
+	return sb.String()
+}
+
+// link renders a text:a hyperlink
+func (r *textRenderer) link(n *xmlNode) string {
+	text := r.inline(n)
+	href := n.attr(nsXLink, "href")
+	if !r.markdown || href == "" || text == "" {
+		return text
+	}
+	return fmt.Sprintf("[%s](%s)", text, href)
+}
+
+// frame renders a draw:frame as an image reference or its text box content
+func (r *textRenderer) frame(n *xmlNode) string {
+	var alt, href string
+	var parts []string
+
+	for _, child := range n.children {
+		switch {
+		case child.is(nsDraw, "image"):
+			if href == "" {
+				href = child.attr(nsXLink, "href")
+			}
+		case child.is(nsSVG, "desc"):
+			if desc := strings.TrimSpace(r.plain(child)); desc != "" {
+				alt = desc
+			}
+		case child.is(nsSVG, "title"):
+			if alt == "" {
+				alt = strings.TrimSpace(r.plain(child))
+			}
+		case child.is(nsDraw, "text-box"):
+			parts = append(parts, r.blocks(child, 0)...)
+		}
+	}
+
+	if href != "" {
+		if alt == "" {
+			alt = n.attr(nsDraw, "name")
+		}
+		if r.markdown {
+			parts = append([]string{fmt.Sprintf("![%s](%s)", r.escape(alt), href)}, parts...)
+		} else if alt != "" {
+			parts = append([]string{"[" + alt + "]"}, parts...)
+		}
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+// plain returns the raw character data below a node
+func (r *textRenderer) plain(n *xmlNode) string {
+	var sb strings.Builder
+	for _, child := range n.children {
+		if child.name.Local == "" {
+			sb.WriteString(child.text)
+		} else {
+			sb.WriteString(r.plain(child))
+		}
+	}
+	return sb.String()
+}
+
+// list renders a text:list with nested items indented by depth
+func (r *textRenderer) list(n *xmlNode, depth int) string {
+	indent := strings.Repeat("  ", depth)
+	var lines []string
+
+	for _, item := range n.children {
+		if !item.is(nsText, "list-item") && !item.is(nsText, "list-header") {
+			continue
+		}
+
+		first := true
+		for _, child := range item.children {
+			var block string
+			switch {
+			case child.is(nsText, "list"):
+				lines = appendBlock(lines, r.list(child, depth+1))
+				continue
+			case child.is(nsText, "p"):
+				block = r.inline(child)
+			case child.is(nsText, "h"):
+				block = r.heading(child)
+			case child.name.Local != "":
+				block = strings.Join(r.blocks(&xmlNode{children: []*xmlNode{child}}, depth+1), "\n")
+			}
+			if strings.TrimSpace(block) == "" {
+				continue
+			}
+
+			prefix := indent + "  "
+			if first {
+				prefix = indent + "- "
+				first = false
+			}
+			lines = append(lines, prefix+strings.ReplaceAll(block, "\n", "\n"+indent+"  "))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// table renders a table:table as a Markdown table or tab separated rows
+func (r *textRenderer) table(n *xmlNode) string {
+	var rows [][]string
+	r.collectRows(n, &rows)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	if !r.markdown {
+		lines := make([]string, 0, len(rows))
+		for _, row := range rows {
+			lines = append(lines, strings.Join(row, "\t"))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return ""
+	}
+
+	lines := make([]string, 0, len(rows)+1)
+	for i, row := range rows {
+		cells := make([]string, columns)
+		for j := range cells {
+			if j < len(row) {
+				cells[j] = strings.ReplaceAll(row[j], "|", `\|`)
+			}
+		}
+		lines = append(lines, "| "+strings.Join(cells, " | ")+" |")
+
+		if i == 0 {
+			sep := make([]string, columns)
+			for j := range sep {
+				sep[j] = "---"
+			}
+			lines = append(lines, "| "+strings.Join(sep, " | ")+" |")
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// collectRows gathers table rows, descending into header and row groups
+func (r *textRenderer) collectRows(n *xmlNode, rows *[][]string) {
+	for _, child := range n.children {
+		switch {
+		case child.is(nsTable, "table-row"):
+			*rows = append(*rows, r.row(child))
+		case child.is(nsTable, "table-header-rows"), child.is(nsTable, "table-rows"),
+			child.is(nsTable, "table-row-group"):
+			r.collectRows(child, rows)
+		}
+	}
+}
+
+// row renders the cells of a table:table-row
+func (r *textRenderer) row(n *xmlNode) []string {
+	var cells []string
+	for _, cell := range n.children {
+		if !cell.is(nsTable, "table-cell") && !cell.is(nsTable, "covered-table-cell") {
+			continue
+		}
+
+		text := strings.Join(r.blocks(cell, 0), " ")
+		text = strings.ReplaceAll(text, "\n", " ")
+
+		repeat, err := strconv.Atoi(cell.attr(nsTable, "number-columns-repeated"))
+		if err != nil || repeat < 1 {
+			repeat = 1
+		}
+		repeat = min(repeat, maxRepeatedCells)
+
+		for range repeat {
+			cells = append(cells, text)
+		}
+	}
+
+	// Drop trailing empty cells produced by repeated filler columns
+	for len(cells) > 0 && cells[len(cells)-1] == "" {
+		cells = cells[:len(cells)-1]
+	}
+	return cells
+}
+
+// escape escapes Markdown control characters in character data
+func (r *textRenderer) escape(s string) string {
+	if !r.markdown {
+		return s
+	}
+	return markdownEscaper.Replace(s)
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"[", `\[`,
+	"]", `\]`,
+)
+
+// collapseSpace collapses runs of XML whitespace into single spaces, as
+// OpenDocument requires for character data inside paragraphs
+func collapseSpace(s string) string {
+	if !strings.ContainsAny(s, " \t\n\r") {
+		return s
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(s))
+	space := false
+	for _, c := range s {
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			if !space {
+				sb.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,123 @@
+package odtimagereplacer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTextContentXML = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+    xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+    xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+    xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0"
+    xmlns:svg="urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0"
+    xmlns:xlink="http://www.w3.org/1999/xlink">
+<office:body><office:text>
+<text:sequence-decls><text:sequence-decl text:name="Figure"/></text:sequence-decls>
+<text:h text:outline-level="2">Quarterly Report</text:h>
+<text:p>Total:<text:tab/>42<text:s text:c="3"/>units<text:line-break/>next line</text:p>
+<text:list>
+<text:list-item><text:p>First</text:p>
+<text:list><text:list-item><text:p>Nested</text:p></text:list-item></text:list>
+</text:list-item>
+<text:list-item><text:p>Second</text:p></text:list-item>
+</text:list>
+<table:table table:name="T1">
+<table:table-column table:number-columns-repeated="2"/>
+<table:table-header-rows><table:table-row>
+<table:table-cell><text:p>Name</text:p></table:table-cell>
+<table:table-cell><text:p>Qty</text:p></table:table-cell>
+</table:table-row></table:table-header-rows>
+<table:table-row>
+<table:table-cell><text:p>Bolt|M4</text:p></table:table-cell>
+<table:table-cell><text:p>7</text:p></table:table-cell>
+<table:table-cell table:number-columns-repeated="1000"/>
+</table:table-row>
+</table:table>
+<text:p><draw:frame draw:name="logo"><draw:image xlink:href="Pictures/logo.png"/><svg:title>Company logo</svg:title></draw:frame></text:p>
+<text:p>Deleted<text:note><text:note-citation>1</text:note-citation><text:note-body><text:p>footnote</text:p></text:note-body></text:note></text:p>
+</office:text></office:body>
+</office:document-content>`
+
+func TestODTDocument_ExtractText(t *testing.T) {
+	tmpDir := t.TempDir()
+	testODT := filepath.Join(tmpDir, "test.odt")
+
+	if err := createODTWithContent(testODT, testTextContentXML); err != nil {
+		t.Fatalf("Failed to create test ODT: %v", err)
+	}
+
+	doc, err := NewODTDocument(testODT)
+	if err != nil {
+		t.Fatalf("NewODTDocument() error = %v", err)
+	}
+
+	text, err := doc.ExtractText()
+	if err != nil {
+		t.Fatalf("ExtractText() error = %v", err)
+	}
+
+	expected := strings.Join([]string{
+		"Quarterly Report",
+		"Total:\t42   units\nnext line",
+		"- First\n  - Nested\n- Second",
+		"Name\tQty\nBolt|M4\t7",
+		"[Company logo]",
+		"Deleted",
+	}, "\n")
+
+	if text != expected {
+		t.Errorf("ExtractText() =\n%q\nwant\n%q", text, expected)
+	}
+}
+
+func TestODTDocument_ExtractMarkdown(t *testing.T) {
+	tmpDir := t.TempDir()
+	testODT := filepath.Join(tmpDir, "test.odt")
+
+	if err := createODTWithContent(testODT, testTextContentXML); err != nil {
+		t.Fatalf("Failed to create test ODT: %v", err)
+	}
+
+	doc, err := NewODTDocument(testODT)
+	if err != nil {
+		t.Fatalf("NewODTDocument() error = %v", err)
+	}
+
+	markdown, err := doc.ExtractMarkdown()
+	if err != nil {
+		t.Fatalf("ExtractMarkdown() error = %v", err)
+	}
+
+	expected := strings.Join([]string{
+		"## Quarterly Report",
+		"Total:\t42   units  \nnext line",
+		"- First\n  - Nested\n- Second",
+		"| Name | Qty |\n| --- | --- |\n| Bolt\\|M4 | 7 |",
+		"![Company logo](Pictures/logo.png)",
+		"Deleted",
+	}, "\n\n")
+
+	if markdown != expected {
+		t.Errorf("ExtractMarkdown() =\n%q\nwant\n%q", markdown, expected)
+	}
+}
+
+func TestCollapseSpace(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "plain"},
+		{"a  b", "a b"},
+		{"a\n\t b", "a b"},
+		{"\n  ", " "},
+	}
+
+	for _, tt := range tests {
+		if got := collapseSpace(tt.in); got != tt.want {
+			t.Errorf("collapseSpace(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
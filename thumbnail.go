@@ -0,0 +1,348 @@
+package odtimagereplacer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+)
+
+// Namespaces used when reading page layouts from styles.xml
+const (
+	nsStyle = "urn:oasis:names:tc:opendocument:xmlns:style:1.0"
+	nsFO    = "urn:oasis:names:tc:opendocument:xmlns:xsl-fo-compatible:1.0"
+)
+
+// thumbnailPath is the location of the preview image inside the package
+const thumbnailPath = "Thumbnails/thumbnail.png"
+
+// thumbnailSize is the longest edge of thumbnails rendered by a converter
+const thumbnailSize = 256
+
+// ThumbnailMode controls how Thumbnails/thumbnail.png is handled on save
+type ThumbnailMode string
+
+const (
+	// ThumbnailKeep leaves the template's thumbnail untouched
+	ThumbnailKeep ThumbnailMode = "keep"
+
+	// ThumbnailRegenerate renders a new thumbnail with the converter if one
+	// is set, otherwise composites replaced first-page images onto the
+	// original thumbnail, and removes the thumbnail if neither is possible
+	ThumbnailRegenerate ThumbnailMode = "regenerate"
+
+	// ThumbnailRemove removes the thumbnail and its manifest entry
+	ThumbnailRemove ThumbnailMode = "remove"
+)
+
+// errCannotComposite indicates the thumbnail cannot be updated in place
+var errCannotComposite = errors.New("replaced images cannot be placed on the thumbnail")
+
+// SetThumbnailMode selects how the thumbnail is handled when saving
+func (doc *ODTDocument) SetThumbnailMode(mode ThumbnailMode) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	doc.thumbnailMode = mode
+}
+
+// SetConverter sets the converter used to render thumbnails
+func (doc *ODTDocument) SetConverter(converter Converter) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	doc.converter = converter
+}
+
+// applyThumbnailMode updates or removes the thumbnail before saving
+func (doc *ODTDocument) applyThumbnailMode(ctx context.Context) error {
+	if _, err := doc.readFile(thumbnailPath); err != nil {
+		// Nothing to update
+		return nil
+	}
+
+	switch doc.thumbnailMode {
+	case ThumbnailRegenerate:
+		if len(doc.replaced) == 0 {
+			return nil
+		}
+		if doc.converter != nil {
+			thumbnail, err := doc.renderThumbnail(ctx)
+			if err == nil {
+				return doc.setThumbnail(thumbnail)
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+		}
+		if thumbnail, err := doc.compositeThumbnail(); err == nil {
+			return doc.setThumbnail(thumbnail)
+		}
+		return doc.removeFile(thumbnailPath)
+	case ThumbnailRemove:
+		return doc.removeFile(thumbnailPath)
+	}
+
+	return nil
+}
+
+// setThumbnail stores a new thumbnail and its manifest entry
+func (doc *ODTDocument) setThumbnail(data []byte) error {
+	if err := doc.checkSignatures(thumbnailPath, manifestPath); err != nil {
+		return err
+	}
+	doc.setFile(thumbnailPath, data)
+	return doc.updateManifestEntry(thumbnailPath, data)
+}
+
+// renderThumbnail renders the first page with the converter
+func (doc *ODTDocument) renderThumbnail(ctx context.Context) ([]byte, error) {
+	odt, err := doc.writePackage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := convertContext(ctx, doc.converter, odt, "png")
+	if err != nil {
+		return nil, err
+	}
+
+	page, _, err := image.Decode(bytes.NewReader(rendered))
+	if err != nil {
+		return nil, fmt.Errorf("decode rendered page: %w", err)
+	}
+
+	bounds := page.Bounds()
+	scale := float64(thumbnailSize) / float64(max(bounds.Dx(), bounds.Dy()))
+	width := max(1, int(float64(bounds.Dx())*scale))
+	height := max(1, int(float64(bounds.Dy())*scale))
+
+	return encodePNG(scaleImage(page, width, height))
+}
+
+// compositeThumbnail draws replaced images onto the original thumbnail at
+// their frame positions. Only frames anchored to the first page can be
+// placed; errCannotComposite is returned if any replaced frame is
+// anchored elsewhere.
+func (doc *ODTDocument) compositeThumbnail() ([]byte, error) {
+	original, err := doc.originalFile(thumbnailPath)
+	if err != nil {
+		return nil, err
+	}
+	thumb, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return nil, fmt.Errorf("decode thumbnail: %w", err)
+	}
+
+	pageWidth, pageHeight, err := doc.pageSize()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := doc.getContentXML()
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseXMLTree([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+
+	canvas := image.NewRGBA(thumb.Bounds())
+	draw.Draw(canvas, canvas.Bounds(), thumb, thumb.Bounds().Min, draw.Src)
+	scaleX := float64(canvas.Bounds().Dx()) / pageWidth
+	scaleY := float64(canvas.Bounds().Dy()) / pageHeight
+
+	var frameErr error
+	walkXMLTree(root, func(n *xmlNode) {
+		if frameErr != nil || !n.is(nsDraw, "frame") || !doc.replaced[n.attr(nsDraw, "name")] {
+			return
+		}
+
+		switch n.attr(nsText, "anchor-type") {
+		case "page":
+			if page := n.attr(nsText, "anchor-page-number"); page != "" && page != "1" {
+				return
+			}
+		default:
+			frameErr = errCannotComposite
+			return
+		}
+
+		frameErr = doc.drawFrame(canvas, n, scaleX, scaleY)
+	})
+	if frameErr != nil {
+		return nil, frameErr
+	}
+
+	return encodePNG(canvas)
+}
+
+// drawFrame draws the image of a page-anchored frame onto the canvas
+func (doc *ODTDocument) drawFrame(canvas *image.RGBA, frame *xmlNode, scaleX, scaleY float64) error {
+	var x, y, width, height float64
+	for _, v := range []struct {
+		dst   *float64
+		local string
+	}{{&x, "x"}, {&y, "y"}, {&width, "width"}, {&height, "height"}} {
+		value, err := parseLength(frame.attr(nsSVG, v.local))
+		if err != nil {
+			return errCannotComposite
+		}
+		*v.dst = value
+	}
+
+	var href string
+	for _, child := range frame.children {
+		if child.is(nsDraw, "image") {
+			href = child.attr(nsXLink, "href")
+			break
+		}
+	}
+
+	data, err := doc.readFile(href)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return errCannotComposite
+	}
+
+	rect := image.Rect(
+		int(x*scaleX), int(y*scaleY),
+		int((x+width)*scaleX), int((y+height)*scaleY),
+	).Add(canvas.Bounds().Min)
+	if rect.Dx() < 1 || rect.Dy() < 1 {
+		return nil
+	}
+
+	scaled := scaleImage(img, rect.Dx(), rect.Dy())
+	draw.Draw(canvas, rect, scaled, image.Point{}, draw.Over)
+	return nil
+}
+
+// pageSize returns the width and height in inches of the page layout used
+// by the Standard master page
+func (doc *ODTDocument) pageSize() (float64, float64, error) {
+	styles, err := doc.readFile("styles.xml")
+	if err != nil {
+		return 0, 0, err
+	}
+	root, err := parseXMLTree(styles)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	layoutName := ""
+	layouts := make(map[string]*xmlNode)
+	walkXMLTree(root, func(n *xmlNode) {
+		switch {
+		case n.is(nsStyle, "master-page"):
+			if layoutName == "" || n.attr(nsStyle, "name") == "Standard" {
+				layoutName = n.attr(nsStyle, "page-layout-name")
+			}
+		case n.is(nsStyle, "page-layout"):
+			layouts[n.attr(nsStyle, "name")] = n
+		}
+	})
+
+	layout, ok := layouts[layoutName]
+	if !ok {
+		return 0, 0, errCannotComposite
+	}
+	for _, child := range layout.children {
+		if !child.is(nsStyle, "page-layout-properties") {
+			continue
+		}
+		width, err := parseLength(child.attr(nsFO, "page-width"))
+		if err != nil {
+			return 0, 0, errCannotComposite
+		}
+		height, err := parseLength(child.attr(nsFO, "page-height"))
+		if err != nil {
+			return 0, 0, errCannotComposite
+		}
+		if width <= 0 || height <= 0 {
+			return 0, 0, errCannotComposite
+		}
+		return width, height, nil
+	}
+
+	return 0, 0, errCannotComposite
+}
+
+// parseLength converts an OpenDocument length such as "2.5cm" to inches
+func parseLength(s string) (float64, error) {
+	units := []struct {
+		suffix string
+		inches float64
+	}{
+		{"in", 1},
+		{"cm", 1 / 2.54},
+		{"mm", 1 / 25.4},
+		{"pt", 1.0 / 72},
+		{"pc", 1.0 / 6},
+		{"px", 1.0 / 96},
+	}
+
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			value, err := strconv.ParseFloat(strings.TrimSuffix(s, unit.suffix), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid length %q: %w", s, err)
+			}
+			return value * unit.inches, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid length %q", s)
+}
+
+// scaleImage resizes src to width x height by averaging source pixels
+func scaleImage(src image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	bounds := src.Bounds()
+
+	for dy := 0; dy < height; dy++ {
+		y0 := bounds.Min.Y + dy*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(dy+1)*bounds.Dy()/height)
+
+		for dx := 0; dx < width; dx++ {
+			x0 := bounds.Min.X + dx*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(dx+1)*bounds.Dx()/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.Set(dx, dy, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
+
+// encodePNG encodes an image as PNG
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
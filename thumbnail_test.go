@@ -0,0 +1,194 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+const testStylesXML = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-styles xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+    xmlns:style="urn:oasis:names:tc:opendocument:xmlns:style:1.0"
+    xmlns:fo="urn:oasis:names:tc:opendocument:xmlns:xsl-fo-compatible:1.0">
+<office:automatic-styles><style:page-layout style:name="pm1">
+<style:page-layout-properties fo:page-width="8.5in" fo:page-height="11in"/>
+</style:page-layout></office:automatic-styles>
+<office:master-styles><style:master-page style:name="Standard" style:page-layout-name="pm1"/></office:master-styles>
+</office:document-styles>`
+
+// thumbnailContentXML places a page-anchored frame at 1in,1in sized 2in x 2in
+func thumbnailContentXML(anchor string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0"
+    xmlns:xlink="http://www.w3.org/1999/xlink"
+    xmlns:svg="urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0"
+    xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+    xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0">
+<office:body><office:text>
+<draw:frame draw:name="image1" text:anchor-type="` + anchor + `" text:anchor-page-number="1" svg:x="1in" svg:y="1in" svg:width="2in" svg:height="2in"><draw:image xlink:href="Pictures/img1.png"/></draw:frame>
+</office:text></office:body>
+</office:document-content>`
+}
+
+// solidPNG returns a PNG filled with a single color
+func solidPNG(t *testing.T, width, height int, c color.Color) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// thumbnailTestDoc opens a package with a white 85x110 thumbnail
+func thumbnailTestDoc(t *testing.T, anchor string) *ODTDocument {
+	t.Helper()
+
+	manifest := strings.Replace(validManifestXML, "</manifest:manifest>",
+		`<manifest:file-entry manifest:full-path="Thumbnails/thumbnail.png" manifest:media-type="image/png"/>
+<manifest:file-entry manifest:full-path="styles.xml" manifest:media-type="text/xml"/>
+</manifest:manifest>`, 1)
+
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", thumbnailContentXML(anchor), zip.Deflate},
+		{"styles.xml", testStylesXML, zip.Deflate},
+		{"Pictures/img1.png", string(solidPNG(t, 4, 4, color.White)), zip.Deflate},
+		{"Thumbnails/thumbnail.png", string(solidPNG(t, 85, 110, color.White)), zip.Deflate},
+		{"META-INF/manifest.xml", manifest, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+	return doc
+}
+
+func TestODTDocument_ThumbnailComposite(t *testing.T) {
+	doc := thumbnailTestDoc(t, "page")
+	red := color.RGBA{R: 255, A: 255}
+
+	if err := doc.ReplaceImageByTag("image1", "Pictures/red.png", solidPNG(t, 4, 4, red)); err != nil {
+		t.Fatalf("ReplaceImageByTag() error = %v", err)
+	}
+
+	doc.SetThumbnailMode(ThumbnailRegenerate)
+	saved := saveAndReopen(t, doc)
+
+	data, err := saved.readFile(thumbnailPath)
+	if err != nil {
+		t.Fatalf("thumbnail missing after regeneration: %v", err)
+	}
+	thumb, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode thumbnail: %v", err)
+	}
+
+	// 10px per inch: the frame covers 10..30 on both axes
+	if r, g, _, _ := thumb.At(20, 20).RGBA(); r>>8 != 255 || g>>8 != 0 {
+		t.Errorf("pixel inside frame = %v, want red", thumb.At(20, 20))
+	}
+	if _, g, _, _ := thumb.At(5, 5).RGBA(); g>>8 != 255 {
+		t.Errorf("pixel outside frame = %v, want white", thumb.At(5, 5))
+	}
+}
+
+func TestODTDocument_ThumbnailRemovedWhenNotPlaceable(t *testing.T) {
+	doc := thumbnailTestDoc(t, "paragraph")
+
+	if err := doc.ReplaceImageByTag("image1", "Pictures/img2.png", []byte(testPNG)); err != nil {
+		t.Fatalf("ReplaceImageByTag() error = %v", err)
+	}
+
+	doc.SetThumbnailMode(ThumbnailRegenerate)
+	saved := saveAndReopen(t, doc)
+
+	if _, err := saved.readFile(thumbnailPath); err == nil {
+		t.Error("thumbnail should be removed when frames cannot be placed")
+	}
+	entries, _ := saved.Manifest()
+	for _, entry := range entries {
+		if entry.FullPath == thumbnailPath {
+			t.Error("thumbnail is still listed in the manifest")
+		}
+	}
+}
+
+func TestODTDocument_ThumbnailConverter(t *testing.T) {
+	doc := thumbnailTestDoc(t, "paragraph")
+
+	if err := doc.ReplaceImageByTag("image1", "Pictures/img2.png", []byte(testPNG)); err != nil {
+		t.Fatalf("ReplaceImageByTag() error = %v", err)
+	}
+
+	converter := &fakeConverter{output: solidPNG(t, 850, 1100, color.Black)}
+	doc.SetThumbnailMode(ThumbnailRegenerate)
+	doc.SetConverter(converter)
+	saved := saveAndReopen(t, doc)
+
+	if converter.format != "png" {
+		t.Errorf("converter called with format %q, want png", converter.format)
+	}
+
+	data, err := saved.readFile(thumbnailPath)
+	if err != nil {
+		t.Fatalf("thumbnail missing: %v", err)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode thumbnail: %v", err)
+	}
+	if cfg.Height != thumbnailSize {
+		t.Errorf("thumbnail height = %d, want %d", cfg.Height, thumbnailSize)
+	}
+}
+
+// fakeConverter returns a fixed rendering
+type fakeConverter struct {
+	output []byte
+	format string
+}
+
+func (c *fakeConverter) Convert(odt []byte, format string) ([]byte, error) {
+	c.format = format
+	return c.output, nil
+}
+
+func TestParseLength(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"1in", 1, false},
+		{"2.54cm", 1, false},
+		{"72pt", 1, false},
+		{"25.4mm", 1, false},
+		{"12", 0, true},
+		{"abcin", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseLength(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLength(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (got < tt.want-1e-9 || got > tt.want+1e-9) {
+			t.Errorf("parseLength(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package odtimagereplacer
+
+import (
+	"sort"
+)
+
+// Part change actions
+const (
+	PartAdded    = "added"
+	PartModified = "modified"
+	PartRemoved  = "removed"
+)
+
+// PartChange is an entry of the change log: a part that differs from the
+// package as it was opened
+type PartChange struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+}
+
+// Begin starts a transaction. Changes made until Commit can be undone
+// with Rollback, and the document cannot be saved while the transaction
+// is open. Transactions do not nest.
+func (doc *ODTDocument) Begin() error {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	if doc.snapshot != nil {
+		return ErrTransactionActive
+	}
+	doc.snapshot = doc.clone()
+	return nil
+}
+
+// Commit keeps the changes made since Begin
+func (doc *ODTDocument) Commit() error {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	if doc.snapshot == nil {
+		return ErrNoTransaction
+	}
+	doc.snapshot = nil
+	return nil
+}
+
+// Rollback discards every change made since Begin, including settings
+// such as the password and signature policy
+func (doc *ODTDocument) Rollback() error {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	if doc.snapshot == nil {
+		return ErrNoTransaction
+	}
+	doc.restore(doc.snapshot)
+	doc.snapshot = nil
+	return nil
+}
+
+// Apply runs fn in a transaction. The changes are kept if fn succeeds and
+// discarded if it returns an error, so the document never holds a
+// partially applied set of edits.
+func (doc *ODTDocument) Apply(fn func(doc *ODTDocument) error) error {
+	if err := doc.Begin(); err != nil {
+		return err
+	}
+	if err := fn(doc); err != nil {
+		if rbErr := doc.Rollback(); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+	return doc.Commit()
+}
+
+// restore resets the document to a state saved by clone, with the lock
+// held. An open transaction stays open.
+func (doc *ODTDocument) restore(s *ODTDocument) {
+	doc.path = s.path
+	doc.pkg = s.pkg
+	doc.cache = s.cache
+	doc.manifest = s.manifest
+
+	doc.refreshMeta = s.refreshMeta
+	doc.thumbnailMode = s.thumbnailMode
+	doc.converter = s.converter
+	doc.replaced = s.replaced
+
+	doc.password = s.password
+	doc.readPassword = s.readPassword
+	doc.encryption = s.encryption
+	doc.plainParts = s.plainParts
+	doc.rekey = s.rekey
+	doc.outer = s.outer
+
+	doc.signaturePolicy = s.signaturePolicy
+	doc.invalidated = s.invalidated
+}
+
+// Changes returns the change log: every part added, modified or removed
+// since the document was opened, in package order followed by removed
+// parts sorted by path
+func (doc *ODTDocument) Changes() []PartChange {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	return doc.changes()
+}
+
+// changes implements Changes with the lock held
+func (doc *ODTDocument) changes() []PartChange {
+	changes := make([]PartChange, 0)
+	for _, name := range doc.fileNames() {
+		switch doc.pkg.state(name) {
+		case entryAdded:
+			changes = append(changes, PartChange{Path: name, Action: PartAdded})
+		case entryModified:
+			changes = append(changes, PartChange{Path: name, Action: PartModified})
+		}
+	}
+
+	removed := make([]string, 0, len(doc.pkg.deleted))
+	for name := range doc.pkg.deleted {
+		removed = append(removed, name)
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		changes = append(changes, PartChange{Path: name, Action: PartRemoved})
+	}
+
+	return changes
+}
@@ -0,0 +1,92 @@
+package odtimagereplacer
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestODTDocument_Rollback(t *testing.T) {
+	doc, err := NewODTDocumentFromBytes(templateTestData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, _ := doc.getContentXML()
+
+	if err := doc.Begin(); err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if err := doc.Begin(); !errors.Is(err, ErrTransactionActive) {
+		t.Errorf("nested Begin() error = %v, want ErrTransactionActive", err)
+	}
+	if err := doc.ReplaceImageByTag("image1", "Pictures/new.png", []byte(testPNG)); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.RemoveFile("Pictures/img1.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doc.SaveToBytes(); !errors.Is(err, ErrTransactionActive) {
+		t.Errorf("SaveToBytes() in transaction error = %v, want ErrTransactionActive", err)
+	}
+
+	if err := doc.Rollback(); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if content, _ := doc.getContentXML(); content != original {
+		t.Error("content.xml not restored by Rollback()")
+	}
+	if changes := doc.Changes(); len(changes) != 0 {
+		t.Errorf("Changes() after Rollback() = %v", changes)
+	}
+	if err := doc.Rollback(); !errors.Is(err, ErrNoTransaction) {
+		t.Errorf("second Rollback() error = %v, want ErrNoTransaction", err)
+	}
+	if _, err := doc.SaveToBytes(); err != nil {
+		t.Errorf("SaveToBytes() after Rollback() error = %v", err)
+	}
+}
+
+func TestODTDocument_Apply(t *testing.T) {
+	doc, err := NewODTDocumentFromBytes(templateTestData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failing step discards the steps before it
+	failure := errors.New("step failed")
+	err = doc.Apply(func(doc *ODTDocument) error {
+		if err := doc.AddImage("Pictures/extra.png", []byte(testPNG)); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Apply() error = %v, want %v", err, failure)
+	}
+	if changes := doc.Changes(); len(changes) != 0 {
+		t.Errorf("Changes() after failed Apply() = %v", changes)
+	}
+
+	err = doc.Apply(func(doc *ODTDocument) error {
+		if err := doc.ReplaceImageByTag("image1", "Pictures/new.png", []byte(testPNG)); err != nil {
+			return err
+		}
+		return doc.RemoveFile("Pictures/img1.png")
+	})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	want := []PartChange{
+		{"content.xml", PartModified},
+		{manifestPath, PartModified},
+		{"Pictures/new.png", PartAdded},
+		{"Pictures/img1.png", PartRemoved},
+	}
+	if got := doc.Changes(); !slices.Equal(got, want) {
+		t.Errorf("Changes() = %v, want %v", got, want)
+	}
+	if err := doc.Commit(); !errors.Is(err, ErrNoTransaction) {
+		t.Errorf("Commit() after Apply() error = %v, want ErrNoTransaction", err)
+	}
+}
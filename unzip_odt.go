@@ -46,7 +46,7 @@ func UnzipMem(path string) error {
 	}
 
 	// Print file names
-	for name := range doc.files {
+	for _, name := range doc.fileNames() {
 		fmt.Println(name)
 	}
 
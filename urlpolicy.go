@@ -0,0 +1,212 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// URLPolicy restricts the URLs that templates and images are downloaded
+// from, protecting servers against request forgery towards internal
+// services. Host patterns are host names or IP addresses; "*.example.com"
+// matches every subdomain of example.com.
+type URLPolicy struct {
+	// AllowedSchemes lists the permitted URL schemes
+	AllowedSchemes []string
+
+	// AllowedHosts, if not empty, lists the only hosts that may be contacted
+	AllowedHosts []string
+
+	// DeniedHosts lists hosts that may never be contacted
+	DeniedHosts []string
+
+	// AllowPrivate permits loopback, private, link-local and other
+	// non-public addresses. They are blocked after DNS resolution otherwise.
+	AllowPrivate bool
+
+	// MaxRedirects limits the redirects followed per download; zero
+	// disables redirects
+	MaxRedirects int
+}
+
+// DefaultURLPolicy is used by DefaultHTTPClient: http and https URLs of
+// public hosts, with up to five redirects
+var DefaultURLPolicy = URLPolicy{
+	AllowedSchemes: []string{"http", "https"},
+	MaxRedirects:   5,
+}
+
+// blockedPrefixes are non-public networks not covered by the netip
+// classification methods
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),  // documentation
+	netip.MustParsePrefix("2002::/16"),      // 6to4, may embed private IPv4
+	netip.MustParsePrefix("fec0::/10"),      // deprecated site-local
+	netip.MustParsePrefix("100::/64"),       // discard-only
+	netip.MustParsePrefix("2001::/23"),      // IETF protocol assignments
+}
+
+// checkURL verifies the scheme and host of a request URL
+func (p URLPolicy) checkURL(scheme, host string) error {
+	if !slices.Contains(p.AllowedSchemes, strings.ToLower(scheme)) {
+		return fmt.Errorf("%w: scheme %q", ErrURLNotAllowed, scheme)
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrURLNotAllowed)
+	}
+	if matchHost(p.DeniedHosts, host) {
+		return fmt.Errorf("%w: host %s is denied", ErrURLNotAllowed, host)
+	}
+	if len(p.AllowedHosts) > 0 && !matchHost(p.AllowedHosts, host) {
+		return fmt.Errorf("%w: host %s is not allowed", ErrURLNotAllowed, host)
+	}
+	return nil
+}
+
+// checkAddress verifies an address a connection is about to be made to
+func (p URLPolicy) checkAddress(address string) error {
+	if p.AllowPrivate {
+		return nil
+	}
+
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+	if !isPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// isPublicAddr reports whether addr is a globally routable unicast address
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchHost reports whether host matches one of the patterns
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// policyTransport checks every request, including redirects, against a
+// URL policy before sending it
+type policyTransport struct {
+	policy URLPolicy
+	next   http.RoundTripper
+}
+
+func (t *policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.policy.checkURL(req.URL.Scheme, req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
+
+// clientURLPolicy returns the policy of a client made by NewHTTPClient
+func clientURLPolicy(client HTTPClient) (URLPolicy, bool) {
+	if c, ok := client.(*http.Client); ok {
+		if t, ok := c.Transport.(*policyTransport); ok {
+			return t.policy, true
+		}
+	}
+	return URLPolicy{}, false
+}
+
+// NewHTTPClient returns an HTTP client that only contacts URLs permitted
+// by policy. Addresses are checked after DNS resolution, when connecting,
+// so redirects and DNS rebinding cannot reach blocked hosts. Proxies from
+// the environment are not used, since they would hide the real target.
+func NewHTTPClient(policy URLPolicy, timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			return policy.checkAddress(address)
+		},
+	}
+
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &policyTransport{policy: policy, next: transport},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > policy.MaxRedirects {
+				return fmt.Errorf("%w: more than %d", ErrTooManyRedirects, policy.MaxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// Error codes reported in API responses
+const (
+	CodeURLNotAllowed     = "url_not_allowed"
+	CodeBlockedAddress    = "blocked_address"
+	CodeTooManyRedirects  = "too_many_redirects"
+	CodeFetchTimeout      = "fetch_timeout"
+	CodeUnexpectedContent = "unexpected_content"
+)
+
+// fetchErrorCode returns the API error code of a download error, or ""
+func fetchErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrURLNotAllowed):
+		return CodeURLNotAllowed
+	case errors.Is(err, ErrBlockedAddress):
+		return CodeBlockedAddress
+	case errors.Is(err, ErrTooManyRedirects):
+		return CodeTooManyRedirects
+	case errors.Is(err, ErrUnexpectedContent):
+		return CodeUnexpectedContent
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeFetchTimeout
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+package odtimagereplacer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"224.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLPolicy_CheckURL(t *testing.T) {
+	policy := URLPolicy{
+		AllowedSchemes: []string{"https"},
+		AllowedHosts:   []string{"*.example.com", "cdn.test"},
+		DeniedHosts:    []string{"internal.example.com"},
+	}
+
+	tests := []struct {
+		scheme, host string
+		wantErr      bool
+	}{
+		{"https", "img.example.com", false},
+		{"HTTPS", "IMG.Example.com.", false},
+		{"https", "cdn.test", false},
+		{"http", "img.example.com", true},
+		{"file", "", true},
+		{"https", "example.com", true},
+		{"https", "internal.example.com", true},
+		{"https", "evil-example.com", true},
+	}
+
+	for _, tt := range tests {
+		err := policy.checkURL(tt.scheme, tt.host)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkURL(%s, %s) error = %v, wantErr %v", tt.scheme, tt.host, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrURLNotAllowed) {
+			t.Errorf("checkURL(%s, %s) error = %v, want ErrURLNotAllowed", tt.scheme, tt.host, err)
+		}
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image":
+			w.Write([]byte(testPNG))
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/to-localhost":
+			http.Redirect(w, r, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/image", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	private := DefaultURLPolicy
+	private.AllowPrivate = true
+	denyLocalhost := private
+	denyLocalhost.DeniedHosts = []string{"localhost"}
+
+	tests := []struct {
+		name     string
+		policy   URLPolicy
+		path     string
+		wantErr  error
+		wantCode string
+	}{
+		{"loopback blocked by default", DefaultURLPolicy, "/image", ErrBlockedAddress, CodeBlockedAddress},
+		{"private allowed", private, "/image", nil, ""},
+		{"redirect limit", private, "/loop", ErrTooManyRedirects, CodeTooManyRedirects},
+		{"redirect to denied host", denyLocalhost, "/to-localhost", ErrURLNotAllowed, CodeURLNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewHTTPClient(tt.policy, 5*time.Second)
+			data, _, err := fetchURL(context.Background(), server.URL+tt.path, nil, client)
+			if tt.wantErr == nil {
+				if err != nil || string(data) != testPNG {
+					t.Errorf("fetchURL() = %d bytes, %v", len(data), err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("fetchURL() error = %v, want %v", err, tt.wantErr)
+			}
+			if code := fetchErrorCode(err); code != tt.wantCode {
+				t.Errorf("fetchErrorCode() = %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestProcessReplaceRequest_BlockedURL(t *testing.T) {
+	req := ReplaceRequest{
+		Template: TemplateSource{URL: "http://169.254.169.254/latest/meta-data"},
+		Data:     map[string]ImageSource{"image1": {URL: "http://127.0.0.1/image.png"}},
+	}
+	response, _, err := ProcessReplaceRequestWithClient(req, NewHTTPClient(DefaultURLPolicy, 5*time.Second))
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("ProcessReplaceRequestWithClient() error = %v, want ErrBlockedAddress", err)
+	}
+	if response.ErrorCode != CodeBlockedAddress {
+		t.Errorf("ErrorCode = %q, want %q", response.ErrorCode, CodeBlockedAddress)
+	}
+}
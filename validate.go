@@ -0,0 +1,364 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Severity levels for validation findings
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Validation finding codes
+const (
+	CodeMimetypeMissing    = "mimetype-missing"
+	CodeMimetypeNotFirst   = "mimetype-not-first"
+	CodeMimetypeCompressed = "mimetype-compressed"
+	CodeMimetypeExtraField = "mimetype-extra-field"
+	CodeMimetypeInvalid    = "mimetype-invalid"
+	CodeUnsafePath         = "unsafe-path"
+	CodeManifestMissing    = "manifest-missing"
+	CodeManifestEntry      = "manifest-entry-missing"
+	CodeManifestStale      = "manifest-entry-stale"
+	CodeManifestMediaType  = "manifest-media-type"
+	CodeMalformedXML       = "malformed-xml"
+	CodeDanglingHref       = "dangling-href"
+	CodeDuplicateName      = "duplicate-name"
+	CodeUnnamedFrame       = "unnamed-frame"
+	CodeUnsupportedImage   = "unsupported-image"
+)
+
+// Finding describes a single problem found while validating a package
+type Finding struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Part     string `json:"part,omitempty"`
+	Message  string `json:"message"`
+}
+
+// ValidationReport is the result of validating an ODT package
+type ValidationReport struct {
+	Valid    bool      `json:"valid"`
+	Findings []Finding `json:"findings"`
+}
+
+// add records a finding and clears Valid for errors
+func (r *ValidationReport) add(severity, code, part, format string, args ...any) {
+	r.Findings = append(r.Findings, Finding{
+		Severity: severity,
+		Code:     code,
+		Part:     part,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	if severity == SeverityError {
+		r.Valid = false
+	}
+}
+
+// xmlParts lists the XML parts checked for well-formedness and references
+var xmlParts = []string{"content.xml", "styles.xml", "meta.xml", "settings.xml"}
+
+// Validate checks the package structure and returns a report of findings.
+// It checks mimetype placement, manifest entries against the archive,
+// malformed XML, dangling xlink:href references, duplicate or missing
+// draw:name values and unsupported image formats.
+func (doc *ODTDocument) Validate() *ValidationReport {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	report := &ValidationReport{Valid: true, Findings: []Finding{}}
+
+	doc.validateMimetype(report)
+
+	names := doc.fileNames()
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		present[name] = true
+		if err := validatePath(name); err != nil {
+			report.add(SeverityError, CodeUnsafePath, name, "entry name is not a safe relative path")
+		}
+	}
+
+	doc.validateManifest(report, names, present)
+
+	for _, part := range xmlParts {
+		if !present[part] {
+			continue
+		}
+		data, err := doc.readFile(part)
+		if err != nil {
+			report.add(SeverityError, CodeMalformedXML, part, "cannot read part: %v", err)
+			continue
+		}
+		root, err := parseXMLTree(data)
+		if err != nil {
+			report.add(SeverityError, CodeMalformedXML, part, "%v", err)
+			continue
+		}
+		if part == "content.xml" || part == "styles.xml" {
+			doc.validateReferences(report, part, root, present)
+		}
+		if part == "content.xml" {
+			validateFrames(report, part, root)
+		}
+	}
+
+	if !present["content.xml"] {
+		report.add(SeverityError, CodeMalformedXML, "content.xml", "content.xml is missing")
+	}
+
+	return report
+}
+
+// validateMimetype checks that mimetype is the first, uncompressed entry
+func (doc *ODTDocument) validateMimetype(report *ValidationReport) {
+	var mimetype *zip.File
+	for i, f := range doc.pkg.reader.File {
+		if f.Name != "mimetype" {
+			continue
+		}
+		mimetype = f
+		if i != 0 {
+			report.add(SeverityError, CodeMimetypeNotFirst, f.Name, "mimetype must be the first entry in the archive (found at position %d)", i+1)
+		}
+		break
+	}
+
+	if mimetype == nil {
+		report.add(SeverityError, CodeMimetypeMissing, "mimetype", "mimetype entry is missing")
+		return
+	}
+
+	if mimetype.Method != zip.Store {
+		report.add(SeverityError, CodeMimetypeCompressed, mimetype.Name, "mimetype must be stored without compression")
+	}
+	if len(mimetype.Extra) > 0 {
+		report.add(SeverityWarning, CodeMimetypeExtraField, mimetype.Name, "mimetype entry should not have an extra field")
+	}
+
+	data, err := doc.readFile("mimetype")
+	if err != nil {
+		report.add(SeverityError, CodeMimetypeInvalid, mimetype.Name, "cannot read mimetype: %v", err)
+		return
+	}
+	if !strings.HasPrefix(string(data), "application/vnd.oasis.opendocument.") {
+		report.add(SeverityError, CodeMimetypeInvalid, mimetype.Name, "unexpected media type %q", string(data))
+	}
+}
+
+// validateManifest compares manifest entries with the archive contents
+func (doc *ODTDocument) validateManifest(report *ValidationReport, names []string, present map[string]bool) {
+	if !present[manifestPath] {
+		report.add(SeverityError, CodeManifestMissing, manifestPath, "manifest is missing")
+		return
+	}
+
+	data, err := doc.readFile(manifestPath)
+	if err != nil {
+		report.add(SeverityError, CodeManifestMissing, manifestPath, "cannot read manifest: %v", err)
+		return
+	}
+
+	m, err := parseManifest(data)
+	if err != nil {
+		report.add(SeverityError, CodeMalformedXML, manifestPath, "%v", err)
+		return
+	}
+	entries := m.Entries
+
+	listed := make(map[string]ManifestEntry, len(entries))
+	for _, entry := range entries {
+		listed[entry.FullPath] = entry
+	}
+
+	// Every stored file must be listed, except the mimetype and META-INF
+	for _, name := range names {
+		if isUnlistedPart(name) {
+			continue
+		}
+		entry, ok := listed[name]
+		if !ok {
+			report.add(SeverityError, CodeManifestEntry, name, "file is not listed in the manifest")
+			continue
+		}
+		if expected := doc.sniffMediaType(name); expected != "" && entry.MediaType != expected {
+			report.add(SeverityWarning, CodeManifestMediaType, name, "manifest media type %q does not match content (%s)", entry.MediaType, expected)
+		}
+	}
+
+	// Every listed file must exist in the archive
+	for _, entry := range entries {
+		switch {
+		case entry.FullPath == "/":
+			if mimetype, err := doc.readFile("mimetype"); err == nil && entry.MediaType != string(mimetype) {
+				report.add(SeverityError, CodeManifestMediaType, "/", "root media type %q does not match mimetype %q", entry.MediaType, string(mimetype))
+			}
+		case strings.HasSuffix(entry.FullPath, "/"):
+			if !hasPrefix(names, entry.FullPath) {
+				report.add(SeverityWarning, CodeManifestStale, entry.FullPath, "manifest lists a directory that is not in the archive")
+			}
+		case !present[entry.FullPath]:
+			report.add(SeverityError, CodeManifestStale, entry.FullPath, "manifest lists a file that is not in the archive")
+		}
+	}
+}
+
+// validateReferences reports xlink:href values that point to missing parts
+func (doc *ODTDocument) validateReferences(report *ValidationReport, part string, root *xmlNode, present map[string]bool) {
+	names := doc.fileNames()
+
+	walkXMLTree(root, func(n *xmlNode) {
+		href := n.attr(nsXLink, "href")
+		target, ok := packagePath(href)
+		if !ok {
+			return
+		}
+
+		// Embedded objects reference a sub-directory of the package
+		if !present[target] && !hasPrefix(names, strings.TrimSuffix(target, "/")+"/") {
+			report.add(SeverityError, CodeDanglingHref, part, "%s references missing part %q", n.name.Local, href)
+			return
+		}
+
+		if n.is(nsDraw, "image") && present[target] {
+			data, err := doc.readFile(target)
+			if err == nil && detectImageFormat(data) == "" {
+				report.add(SeverityWarning, CodeUnsupportedImage, target, "image format is not recognized")
+			}
+		}
+	})
+}
+
+// validateFrames reports duplicate and missing draw:name values on frames
+func validateFrames(report *ValidationReport, part string, root *xmlNode) {
+	seen := make(map[string]int)
+	unnamed := 0
+
+	walkXMLTree(root, func(n *xmlNode) {
+		if !n.is(nsDraw, "frame") {
+			return
+		}
+		name := n.attr(nsDraw, "name")
+		if name == "" {
+			unnamed++
+			return
+		}
+		seen[name]++
+	})
+
+	duplicates := make([]string, 0)
+	for name, count := range seen {
+		if count > 1 {
+			duplicates = append(duplicates, name)
+		}
+	}
+	sort.Strings(duplicates)
+
+	for _, name := range duplicates {
+		report.add(SeverityError, CodeDuplicateName, part, "draw:name %q is used by %d frames", name, seen[name])
+	}
+	if unnamed > 0 {
+		report.add(SeverityWarning, CodeUnnamedFrame, part, "%d frame(s) have no draw:name and cannot be targeted by tag", unnamed)
+	}
+}
+
+// walkXMLTree calls fn for every element below n
+func walkXMLTree(n *xmlNode, fn func(*xmlNode)) {
+	for _, child := range n.children {
+		if child.name.Local == "" {
+			continue
+		}
+		fn(child)
+		walkXMLTree(child, fn)
+	}
+}
+
+// packagePath converts an xlink:href to a package path, reporting false
+// for external links, fragments and references outside the package.
+// Hrefs are URIs, so escapes such as %20 are decoded.
+func packagePath(href string) (string, bool) {
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "/") ||
+		strings.HasPrefix(href, "../") || strings.Contains(href, ":") {
+		return "", false
+	}
+	return strings.TrimPrefix(href, "./"), true
+}
+
+// isUnlistedPart reports whether a part is exempt from manifest listing
+func isUnlistedPart(name string) bool {
+	return name == "mimetype" || strings.HasPrefix(name, "META-INF/") || strings.HasSuffix(name, "/")
+}
+
+// hasPrefix reports whether any name starts with prefix
+func hasPrefix(names []string, prefix string) bool {
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffMediaType returns the image media type detected from a part's
+// content, or "" for parts that are not images
+func (doc *ODTDocument) sniffMediaType(name string) string {
+	if !strings.HasPrefix(detectMIMEType(name), "image/") {
+		return ""
+	}
+	data, err := doc.readFile(name)
+	if err != nil {
+		return ""
+	}
+	format := detectImageFormat(data)
+	if format == "" {
+		return ""
+	}
+	return detectMIMEType("image" + format)
+}
+
+// detectImageFormat extends detectImageExtension with the vector and
+// legacy formats LibreOffice can embed
+func detectImageFormat(data []byte) string {
+	if ext := detectImageExtension(data); ext != "" {
+		return ext
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("BM")):
+		return ".bmp"
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return ".tif"
+	case bytes.HasPrefix(data, []byte("\xd7\xcd\xc6\x9a")), bytes.HasPrefix(data, []byte("\x01\x00\x09\x00")):
+		return ".wmf"
+	case len(data) >= 44 && bytes.Equal(data[40:44], []byte(" EMF")):
+		return ".emf"
+	case bytes.HasPrefix(data, []byte("VCLMTF")):
+		return ".svm"
+	}
+
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if i := bytes.Index(head, []byte("<svg")); i >= 0 && i+4 < len(head) &&
+		bytes.IndexByte([]byte(" \t\r\n>"), head[i+4]) >= 0 {
+		return ".svg"
+	}
+
+	return ""
+}
+
+// fileNames returns the names of all parts in archive order, followed by
+// parts added since the document was opened
+func (doc *ODTDocument) fileNames() []string {
+	return doc.pkg.names()
+}
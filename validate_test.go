@@ -0,0 +1,192 @@
+package odtimagereplacer
+
+import (
+	"archive/zip"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testEntry is an archive entry for buildODT
+type testEntry struct {
+	name   string
+	data   string
+	method uint16
+}
+
+// buildODT creates an ODT package with entries in the given order
+func buildODT(t testing.TB, entries []testEntry) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	writer := zip.NewWriter(buf)
+	for _, e := range entries {
+		fw, err := writer.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// saveAndReopen saves doc and opens the output
+func saveAndReopen(t testing.TB, doc *ODTDocument) *ODTDocument {
+	t.Helper()
+
+	output, err := doc.SaveToBytes()
+	if err != nil {
+		t.Fatalf("SaveToBytes() error = %v", err)
+	}
+	saved, err := NewODTDocumentFromBytes(output)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes(saved) error = %v", err)
+	}
+	return saved
+}
+
+const validManifestXML = `<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0">
+    <manifest:file-entry manifest:full-path="/" manifest:media-type="application/vnd.oasis.opendocument.text" />
+    <manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml" />
+    <manifest:file-entry manifest:full-path="Pictures/img1.png" manifest:media-type="image/png" />
+</manifest:manifest>`
+
+const validContentXML = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0"
+    xmlns:xlink="http://www.w3.org/1999/xlink"
+    xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0">
+    <office:body>
+        <draw:frame draw:name="image1"><draw:image xlink:href="Pictures/img1.png" /></draw:frame>
+        <draw:frame draw:name="link"><draw:image xlink:href="https://example.com/a.png" /></draw:frame>
+    </office:body>
+</office:document-content>`
+
+// testPNG is the PNG signature padded to the minimum sniffing length
+const testPNG = "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"
+
+func TestODTDocument_Validate_Valid(t *testing.T) {
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", validContentXML, zip.Deflate},
+		{"Pictures/img1.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	report := doc.Validate()
+	if !report.Valid || len(report.Findings) != 0 {
+		t.Errorf("Validate() = %+v, want valid without findings", report)
+	}
+}
+
+func TestODTDocument_Validate_EscapedHref(t *testing.T) {
+	manifest := strings.Replace(validManifestXML, "Pictures/img1.png", "Pictures/my photo.png", 1)
+	content := strings.Replace(validContentXML, "Pictures/img1.png", "Pictures/my%20photo.png", 1)
+	data := buildODT(t, []testEntry{
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Store},
+		{"content.xml", content, zip.Deflate},
+		{"Pictures/my photo.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", manifest, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	if report := doc.Validate(); !report.Valid || len(report.Findings) != 0 {
+		t.Errorf("Validate() = %+v, want the escaped href resolved", report)
+	}
+}
+
+func TestODTDocument_Validate_Findings(t *testing.T) {
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0"
+    xmlns:xlink="http://www.w3.org/1999/xlink"
+    xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0">
+    <office:body>
+        <draw:frame draw:name="image1"><draw:image xlink:href="Pictures/img1.png" /></draw:frame>
+        <draw:frame draw:name="image1"><draw:image xlink:href="Pictures/missing.png" /></draw:frame>
+        <draw:frame><draw:image xlink:href="Pictures/extra.png" /></draw:frame>
+    </office:body>
+</office:document-content>`
+
+	data := buildODT(t, []testEntry{
+		{"content.xml", content, zip.Deflate},
+		{"mimetype", "application/vnd.oasis.opendocument.text", zip.Deflate},
+		{"styles.xml", "<office:document-styles>", zip.Deflate},
+		{"Pictures/img1.png", "not an image", zip.Deflate},
+		{"Pictures/extra.png", testPNG, zip.Deflate},
+		{"META-INF/manifest.xml", validManifestXML, zip.Deflate},
+	})
+
+	doc, err := NewODTDocumentFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewODTDocumentFromBytes() error = %v", err)
+	}
+
+	report := doc.Validate()
+	if report.Valid {
+		t.Error("Validate() reported an invalid package as valid")
+	}
+
+	codes := make(map[string]bool)
+	for _, f := range report.Findings {
+		codes[f.Code] = true
+	}
+
+	for _, code := range []string{
+		CodeMimetypeNotFirst,
+		CodeMimetypeCompressed,
+		CodeManifestEntry,
+		CodeMalformedXML,
+		CodeDanglingHref,
+		CodeDuplicateName,
+		CodeUnnamedFrame,
+		CodeUnsupportedImage,
+	} {
+		if !codes[code] {
+			t.Errorf("Validate() missing finding %q in %+v", code, report.Findings)
+		}
+	}
+}
+
+func TestODTDocument_Save_MimetypeFirst(t *testing.T) {
+	tmpDir := t.TempDir()
+	testODT := filepath.Join(tmpDir, "test.odt")
+
+	if err := createMinimalODT(testODT); err != nil {
+		t.Fatalf("Failed to create test ODT: %v", err)
+	}
+
+	doc, err := NewODTDocument(testODT)
+	if err != nil {
+		t.Fatalf("NewODTDocument() error = %v", err)
+	}
+
+	output, err := doc.SaveToBytes()
+	if err != nil {
+		t.Fatalf("SaveToBytes() error = %v", err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(output), int64(len(output)))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+
+	first := reader.File[0]
+	if first.Name != "mimetype" || first.Method != zip.Store {
+		t.Errorf("first entry = %s (method %d), want stored mimetype", first.Name, first.Method)
+	}
+}